@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/webdevwilson/tfwatch/context"
+)
+
+// export writes an archive of the store to the archive file, returning the exit code
+func export(cfg *context.Configuration) int {
+	var w io.Writer = os.Stdout
+	if cfg.Archive != "-" {
+		f, err := os.OpenFile(cfg.Archive, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := context.Export(cfg, w); err != nil {
+		log.Printf("[ERROR] Error exporting store: %s", err)
+		return 1
+	}
+	return 0
+}
+
+// importArchive loads the archive file into the store, returning the exit code
+func importArchive(cfg *context.Configuration) int {
+	var r io.Reader = os.Stdin
+	if cfg.Archive != "-" {
+		f, err := os.Open(cfg.Archive)
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := context.Import(cfg, r)
+	if err != nil {
+		log.Printf("[ERROR] Error importing archive: %s", err)
+		return 1
+	}
+
+	fmt.Printf("Imported %d values into %d namespaces, skipped %d and removed %d\n",
+		report.Imported, report.Namespaces, report.Skipped, report.Removed)
+	return 0
+}
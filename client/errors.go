@@ -0,0 +1,29 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// APIError is an error returned by the API, with the code and message of its body
+type APIError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RequestID  string `json:"request_id"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Invalid status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s (status %d, request %s)", e.Message, e.StatusCode, e.RequestID)
+}
+
+// responseError returns the *APIError of a response, reading its body
+func responseError(resp *http.Response) error {
+	e := &APIError{StatusCode: resp.StatusCode}
+	json.NewDecoder(resp.Body).Decode(e)
+	return e
+}
@@ -4,56 +4,82 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"strings"
 
 	"github.com/webdevwilson/tfwatch/model"
 )
 
 type Projects struct {
 	sockAddr string
+	token    string
 }
 
 // NewProjectClient is used to create a project client
 func NewProjectClient(sockAddr string) *Projects {
-	return &Projects{sockAddr}
+	return &Projects{sockAddr: sockAddr}
 }
 
-// Create creates a new project
-func (p *Projects) Create(prj *model.Project) error {
+// WithToken sets the API token sent with each request, returning the client
+func (p *Projects) WithToken(token string) *Projects {
+	p.token = token
+	return p
+}
+
+// do sends a request with the client's token
+func (p *Projects) do(method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// Create creates a new project in a directory of the checkout directory
+func (p *Projects) Create(in *model.ProjectInput) (*model.Project, error) {
 	url := fmt.Sprintf("%s/api/projects", p.sockAddr)
-	body, err := json.Marshal(prj)
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(body))
+	body, err := json.Marshal(in)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.do(http.MethodPut, url, bytes.NewBuffer(body))
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("Invalid status code %d", resp.StatusCode)
+		return nil, responseError(resp)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(prj)
+	var result = &model.Project{}
+	err = json.NewDecoder(resp.Body).Decode(result)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return result, nil
 }
 
-// Update updates the project by guid
+// Update replaces the editable fields of the project by guid, the project is updated with the response
 func (p *Projects) Update(prj *model.Project) error {
 	url := fmt.Sprintf("%s/api/projects/%s", p.sockAddr, prj.GUID)
 
-	body, err := json.Marshal(prj)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	body, err := json.Marshal(prj.Input())
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.do(http.MethodPost, url, bytes.NewBuffer(body))
 
 	if err != nil {
 		return err
@@ -62,7 +88,7 @@ func (p *Projects) Update(prj *model.Project) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("Invalid status code %d", resp.StatusCode)
+		return responseError(resp)
 	}
 
 	err = json.NewDecoder(resp.Body).Decode(prj)
@@ -73,16 +99,40 @@ func (p *Projects) Update(prj *model.Project) error {
 	return nil
 }
 
-// Delete deletes a project by it's guid
-func (p *Projects) Delete(guid string) error {
+// Patch applies a JSON merge patch to the project by guid
+func (p *Projects) Patch(guid string, patch interface{}) (*model.Project, error) {
 	url := fmt.Sprintf("%s/api/projects/%s", p.sockAddr, guid)
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.do(http.MethodPatch, url, bytes.NewBuffer(body))
 
-	req, err := http.NewRequest(http.MethodDelete, url, strings.NewReader(""))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, responseError(resp)
+	}
+
+	var result = &model.Project{}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Delete deletes a project by it's guid
+func (p *Projects) Delete(guid string) error {
+	url := fmt.Sprintf("%s/api/projects/%s", p.sockAddr, guid)
+
+	resp, err := p.do(http.MethodDelete, url, nil)
 
 	if err != nil {
 		return err
@@ -91,7 +141,7 @@ func (p *Projects) Delete(guid string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("Invalid status code %d", resp.StatusCode)
+		return responseError(resp)
 	}
 
 	return nil
@@ -101,7 +151,7 @@ func (p *Projects) Delete(guid string) error {
 func (p *Projects) Get(guid string) (*model.Project, error) {
 	url := fmt.Sprintf("%s/api/projects/%s", p.sockAddr, guid)
 
-	resp, err := http.Get(url)
+	resp, err := p.do(http.MethodGet, url, nil)
 
 	if err != nil {
 		return nil, err
@@ -110,7 +160,7 @@ func (p *Projects) Get(guid string) (*model.Project, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Invalid status code %d", resp.StatusCode)
+		return nil, responseError(resp)
 	}
 
 	var result = &model.Project{}
@@ -126,7 +176,7 @@ func (p *Projects) Get(guid string) (*model.Project, error) {
 func (p *Projects) List() ([]model.Project, error) {
 	url := fmt.Sprintf("%s/api/projects", p.sockAddr)
 
-	resp, err := http.Get(url)
+	resp, err := p.do(http.MethodGet, url, nil)
 
 	if err != nil {
 		return nil, err
@@ -135,7 +185,7 @@ func (p *Projects) List() ([]model.Project, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Invalid status code %d", resp.StatusCode)
+		return nil, responseError(resp)
 	}
 
 	var result []model.Project
@@ -1,17 +1,24 @@
 package context
 
 import (
+	"encoding/json"
+	"io"
 	"log"
+	"net"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"fmt"
 	"github.com/hashicorp/logutils"
 	"github.com/webdevwilson/tfwatch/controller"
 	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
 	"github.com/webdevwilson/tfwatch/persist"
 	"github.com/webdevwilson/tfwatch/routes"
+	"github.com/webdevwilson/tfwatch/secure"
 )
 
 // Settings contains all the configuration values for the service. Do not put public stuff
@@ -20,21 +27,54 @@ import (
 // systems. Even further, these systems should be communicating across a messaging channel as opposed to being
 // tightly coupled.
 type Instance struct {
-	Server   routes.HTTPServer
-	Projects controller.Projects
-	System   controller.System
+	Admin       controller.Admin
+	Changes     persist.Watcher
+	Credentials controller.Credentials
+	Events      controller.Events
+	Server      routes.HTTPServer
+	Outputs     controller.Outputs
+	Projects    controller.Projects
+	Pruner      controller.Pruner
+	State       controller.State
+	System      controller.System
+	Tokens      controller.Tokens
+	Users       controller.Users
+	Variables   controller.Variables
 }
 
 // Configuration settings for the application
 type Configuration struct {
-	CheckoutDir string
-	StateDir    string
-	ClearState  bool
-	LogDir      string
-	LogLevel    logutils.LogLevel
-	SiteDir     string
-	Port        uint16
-	RunPlan     bool
+	Command        string
+	DryRun         bool
+	Archive        string
+	Strategy       persist.ImportStrategy
+	CheckoutDir    string
+	StateDir       string
+	ClearState     bool
+	LogDir         string
+	LogLevel       logutils.LogLevel
+	SiteDir        string
+	Port           uint16
+	RunPlan        bool
+	MasterKey      string
+	MasterKeyFile  string
+	SecretsDir     string
+	SecretsEnv     []string
+	VaultAddr      string
+	VaultPaths     []string
+	VaultToken     string
+	Retention      controller.RetentionPolicy
+	PruneInterval  time.Duration
+	Store          string
+	StoreEncoding  persist.Encoding
+	NoAuth         bool
+	TokenCommand   string
+	Token          model.Token
+	AdminPassword  string
+	ProxyHeader    string
+	TrustedProxies []*net.IPNet
+	UserCommand    string
+	User           model.User
 }
 
 // NewContext creates the execution context for server. The context is the root
@@ -62,24 +102,82 @@ func NewContext(cfg *Configuration) *Instance {
 		log.Fatalf("Error creating state directory '%s': %s", cfg.StateDir, err)
 	}
 
-	// initialize the data store
-	store, err := persist.NewBoltStore(path.Join(cfg.StateDir))
+	// logging configuration
+	configureLogging(cfg.LogLevel)
+
+	// initialize the data store, and upgrade the values in it
+	store, err := OpenStore(cfg)
 	if err != nil {
 		log.Fatalf("[FATAL] Error initializing persistence: %s", err)
 	}
 
-	// logging configuration
-	configureLogging(cfg.LogLevel)
+	// stores outside the state directory are cleared too
+	if cfg.ClearState {
+		if err := store.Destroy(); err != nil {
+			log.Fatalf("[FATAL] Error clearing persistence: %s", err)
+		}
+	}
+
+	_, err = persist.Migrate(store, BackupDir(cfg), false)
+	if err != nil {
+		log.Fatalf("[FATAL] Error migrating persistence: %s", err)
+	}
+
+	// changes made by the controllers are published to subscribers of the change feed
+	changes := persist.NewWatchedStore(store)
+	store = changes
 
-	// create an executor
-	executor := execute.NewExecutor(store, path.Join(cfg.LogDir, "executor"))
+	// create an executor, resolving secret references with the configured providers
+	executorLogDir := path.Join(cfg.LogDir, "executor")
+	executor := execute.NewExecutor(store, executorLogDir, secretProviders(cfg))
+
+	// load the master key used to encrypt secrets at rest
+	key, err := masterKey(cfg)
+	if err != nil {
+		log.Fatalf("[FATAL] Error loading master key: %s", err)
+	}
+
+	cipher, err := secure.NewCipher(key)
+	if err != nil {
+		log.Fatalf("[FATAL] Error loading master key: %s", err)
+	}
+
+	// create the variables and credentials controllers
+	variables := controller.NewVariablesController(store, cipher)
+	credentials := controller.NewCredentialsController(store, cipher)
+
+	// create the state and outputs controllers
+	state := controller.NewStateController(executor, credentials)
+	outputs := controller.NewOutputsController(store, state)
+
+	// create the events controller, publishing what happens to projects to the dashboard
+	events := controller.NewEventsController(store, changes)
 
 	// create the controller
-	projects := controller.NewProjectsController(cfg.CheckoutDir, store, executor, 5*time.Minute, cfg.RunPlan)
+	projects := controller.NewProjectsController(cfg.CheckoutDir, store, executor, outputs, variables, credentials, events, 5*time.Minute, cfg.RunPlan)
+
+	// create the pruner, removing executions and logs that are no longer retained
+	pruner := controller.NewPrunerController(store, projects, executorLogDir, cfg.Retention, cfg.PruneInterval)
+
+	// create the admin controller
+	admin := controller.NewAdminController(store, BackupDir(cfg))
 
 	// create the system controller
 	system := controller.NewSystemController(systemConfigValues(cfg), executor)
 
+	// create the tokens and users controllers, API requests are authenticated with tokens or a user's
+	// session unless auth is disabled
+	tokens := controller.NewTokensController(store)
+	users := controller.NewUsersController(store)
+	if cfg.NoAuth {
+		log.Printf("[WARN] Authentication is disabled, anyone who can reach port %d can apply plans", cfg.Port)
+	} else {
+		bootstrapAdmin(users, cfg.AdminPassword)
+	}
+	if cfg.ProxyHeader != "" {
+		log.Printf("[INFO] Trusting the %s header of requests from %s", cfg.ProxyHeader, cfg.TrustedProxies)
+	}
+
 	// create the HTTP server
 	accessLogDir := path.Join(cfg.LogDir, "http")
 	err = os.MkdirAll(accessLogDir, os.ModePerm)
@@ -95,14 +193,183 @@ func NewContext(cfg *Configuration) *Instance {
 
 	siteDir := cfg.SiteDir
 	port := cfg.Port
-	server := routes.InitializeServer(port, accessLog, system, projects, siteDir)
+	server := routes.InitializeServer(port, accessLog, routes.Controllers{
+		Admin:       admin,
+		Credentials: credentials,
+		Events:      events,
+		Outputs:     outputs,
+		Projects:    projects,
+		Pruner:      pruner,
+		State:       state,
+		System:      system,
+		Tokens:      tokens,
+		Users:       users,
+		Variables:   variables,
+	}, siteDir, routes.AuthConfig{
+		Enabled:        !cfg.NoAuth,
+		ProxyHeader:    cfg.ProxyHeader,
+		TrustedProxies: cfg.TrustedProxies,
+	})
 
 	// initialize the context
 	return &Instance{
-		Projects: projects,
-		Server:   server,
-		System:   system,
+		Admin:       admin,
+		Changes:     changes,
+		Credentials: credentials,
+		Events:      events,
+		Outputs:     outputs,
+		Projects:    projects,
+		Pruner:      pruner,
+		Server:      server,
+		State:       state,
+		System:      system,
+		Tokens:      tokens,
+		Users:       users,
+		Variables:   variables,
+	}
+}
+
+// bootstrapAdmin creates the admin user when there are no users, with the admin password or a random
+// password. The random password is printed once to standard output, never to the log, which is often
+// shipped elsewhere.
+func bootstrapAdmin(users controller.Users, adminPassword string) {
+	password, err := users.Bootstrap(adminPassword)
+	if err != nil {
+		log.Printf("[ERROR] Error creating the '%s' user: %s", controller.BootstrapUsername, err)
+		return
+	}
+
+	switch {
+	case password == "":
+	case adminPassword != "":
+		log.Printf("[INFO] Created the '%s' user with the admin password", controller.BootstrapUsername)
+	default:
+		log.Printf("[WARN] Created the '%s' user with a random password, printed to standard output once, log in and change it", controller.BootstrapUsername)
+		fmt.Printf("Created the '%s' user with password:\n%s\nLog in and change it, it is not shown again\n", controller.BootstrapUsername, password)
+	}
+}
+
+// Migrate upgrades the values in the store without starting the service, returning the migrations
+// that ran. A dry run returns the migrations that would run.
+func Migrate(cfg *Configuration, dryRun bool) ([]persist.Migration, error) {
+	configureLogging(cfg.LogLevel)
+
+	store, err := OpenStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return persist.Migrate(store, BackupDir(cfg), dryRun)
+}
+
+// Export writes an archive of the store to w without starting the service
+func Export(cfg *Configuration, w io.Writer) error {
+	configureLogging(cfg.LogLevel)
+
+	store, err := OpenStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	return controller.NewAdminController(store, BackupDir(cfg)).Export(w)
+}
+
+// Import loads an archive read from r into the store without starting the service
+func Import(cfg *Configuration, r io.Reader) (*persist.ImportReport, error) {
+	configureLogging(cfg.LogLevel)
+
+	var archive persist.Archive
+	err := json.NewDecoder(r).Decode(&archive)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading archive: %s", err)
+	}
+
+	store, err := OpenStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return controller.NewAdminController(store, BackupDir(cfg)).Import(&archive, cfg.Strategy)
+}
+
+// Tokens returns the tokens controller of the store without starting the service
+func Tokens(cfg *Configuration) (controller.Tokens, error) {
+	configureLogging(cfg.LogLevel)
+
+	store, err := OpenStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return controller.NewTokensController(store), nil
+}
+
+// Users returns the users controller of the store without starting the service
+func Users(cfg *Configuration) (controller.Users, error) {
+	configureLogging(cfg.LogLevel)
+
+	store, err := OpenStore(cfg)
+	if err != nil {
+		return nil, err
 	}
+
+	return controller.NewUsersController(store), nil
+}
+
+// OpenStore opens the configured store. The store is bolt, a Bolt DB in the state directory, memory,
+// which keeps nothing once the process exits, local:<dir>, the files of the local file store in dir,
+// sqlite:<file>, a SQLite database that is tfwatch.db in the state directory when no file is given, or
+// the postgres:// URL of a Postgres database.
+func OpenStore(cfg *Configuration) (persist.Store, error) {
+	switch {
+	case cfg.Store == "" || cfg.Store == "bolt":
+		return persist.NewBoltStore(cfg.StateDir, cfg.StoreEncoding)
+	case cfg.Store == "memory":
+		return persist.NewMemoryStore(), nil
+	case cfg.Store == "local" || strings.HasPrefix(cfg.Store, "local:"):
+		// the directory is not defaulted, every directory in it is read as a namespace
+		dir := strings.TrimPrefix(strings.TrimPrefix(cfg.Store, "local"), ":")
+		if dir == "" {
+			return nil, fmt.Errorf("The local store needs the directory of its files, as local:<dir>")
+		}
+		return persist.NewLocalFileStore(dir)
+	case cfg.Store == "sqlite" || strings.HasPrefix(cfg.Store, "sqlite:"):
+		file := strings.TrimPrefix(strings.TrimPrefix(cfg.Store, "sqlite"), ":")
+		if file == "" {
+			file = path.Join(cfg.StateDir, "tfwatch.db")
+		}
+		return persist.NewSQLStore("sqlite", file, cfg.StoreEncoding)
+	case strings.HasPrefix(cfg.Store, "postgres://") || strings.HasPrefix(cfg.Store, "postgresql://"):
+		return persist.NewSQLStore("postgres", cfg.Store, cfg.StoreEncoding)
+	}
+	return nil, fmt.Errorf("Unknown store '%s', expected bolt, memory, local:<dir>, sqlite:<file> or a postgres:// URL", cfg.Store)
+}
+
+// masterKey loads the master key, which must be configured. A key kept in the state directory is
+// copied along with the store it decrypts, by backups and copies of the directory.
+func masterKey(cfg *Configuration) ([]byte, error) {
+	if cfg.MasterKey == "" && cfg.MasterKeyFile == "" {
+		return nil, fmt.Errorf("No master key is configured, set TFWATCH_MASTER_KEY or MASTER_KEY_FILE to a file outside the state directory")
+	}
+
+	if cfg.MasterKey == "" {
+		file, _ := filepath.Abs(cfg.MasterKeyFile)
+		dir, _ := filepath.Abs(cfg.StateDir)
+		if strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			log.Printf("[WARN] ********************************************************************")
+			log.Printf("[WARN] The master key file '%s' is in the state directory '%s'.", cfg.MasterKeyFile, cfg.StateDir)
+			log.Printf("[WARN] Anyone with a copy of the state directory or its backups can decrypt")
+			log.Printf("[WARN] every variable and credential. Move the key outside the state directory.")
+			log.Printf("[WARN] ********************************************************************")
+		}
+	}
+
+	return secure.LoadKey(cfg.MasterKey, cfg.MasterKeyFile)
+}
+
+// BackupDir is the directory the store is backed up to before it is migrated
+func BackupDir(cfg *Configuration) string {
+	return path.Join(cfg.StateDir, "backups")
 }
 
 func configureLogging(level logutils.LogLevel) {
@@ -120,5 +387,27 @@ func systemConfigValues(cfg *Configuration) []controller.SystemConfigurationValu
 		{"CheckoutDir", "Checkout Directory", cfg.CheckoutDir},
 		{"LogLevel", "Log Level", string(cfg.LogLevel)},
 		{"Port", "HTTP Port", fmt.Sprintf("%d", cfg.Port)},
+		{"Auth", "Authentication", fmt.Sprintf("%t", !cfg.NoAuth)},
+		{"ProxyHeader", "Trusted Proxy Header", cfg.ProxyHeader},
+	}
+}
+
+// secretProviders creates the providers secret references in variables and settings are resolved with
+func secretProviders(cfg *Configuration) *secure.Secrets {
+	secrets := secure.NewSecrets()
+	if len(cfg.SecretsEnv) > 0 {
+		secrets.Register("env", &secure.EnvProvider{Allowed: cfg.SecretsEnv})
 	}
+
+	if cfg.SecretsDir != "" {
+		secrets.Register("file", &secure.FileProvider{Dir: cfg.SecretsDir})
+	}
+
+	if cfg.VaultAddr != "" && len(cfg.VaultPaths) > 0 {
+		secrets.Register("vault", secure.NewVaultProvider(cfg.VaultAddr, cfg.VaultToken, cfg.VaultPaths))
+	} else if cfg.VaultAddr != "" {
+		log.Printf("[WARN] VAULT_ADDR is set without VAULT_PATHS, Vault references are disabled")
+	}
+
+	return secrets
 }
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// Admin exports the values of every controller to an archive, and imports them from one
+type Admin interface {
+	Export(w io.Writer) error
+	Import(archive *persist.Archive, strategy persist.ImportStrategy) (*persist.ImportReport, error)
+}
+
+type admin struct {
+	store     persist.Store
+	backupDir string
+}
+
+// NewAdminController creates a controller exporting and importing the store, which is backed up to
+// backupDir before an import
+func NewAdminController(store persist.Store, backupDir string) Admin {
+	return &admin{
+		store:     store,
+		backupDir: backupDir,
+	}
+}
+
+// Export writes an archive of every namespace in the store to w. Variables and credentials are
+// encrypted before they are stored, so they remain encrypted with the master key in the archive.
+func (a *admin) Export(w io.Writer) error {
+	return persist.Export(a.store, w)
+}
+
+// Import loads an archive into the store after backing it up, then migrates the imported values when
+// the archive is of an earlier schema version
+func (a *admin) Import(archive *persist.Archive, strategy persist.ImportStrategy) (*persist.ImportReport, error) {
+	if b, ok := persist.Unwrap(a.store).(persist.Backuper); ok {
+		backup, err := b.Backup(a.backupDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error backing up store before importing: %s", err)
+		}
+		log.Printf("[INFO] Backed up store to '%s' before importing", backup)
+	}
+
+	report, err := persist.Import(a.store, archive, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = persist.Migrate(a.store, a.backupDir, false)
+	return report, err
+}
@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+	"github.com/webdevwilson/tfwatch/secure"
+)
+
+const credentialsNS = "credentials"
+
+// Credentials stores credential profiles, encrypted at rest, and passes them to the projects they
+// are assigned to
+type Credentials interface {
+	List() ([]*model.CredentialProfile, error)
+	Get(guid string) (*model.CredentialProfile, error)
+	Create(c *model.CredentialProfile) error
+	Update(c *model.CredentialProfile) error
+	Delete(guid string) error
+	Inject(prj *model.Project, t *execute.Task) error
+}
+
+// storedCredentials is the encrypted form of a profile, the secret is the encrypted JSON of the profile
+type storedCredentials struct {
+	Name   string
+	Type   model.CredentialType
+	Secret []byte
+}
+
+type credentials struct {
+	store  persist.Store
+	cipher *secure.Cipher
+}
+
+// NewCredentialsController creates a controller for credential profiles encrypted with the cipher
+func NewCredentialsController(store persist.Store, cipher *secure.Cipher) Credentials {
+	store.CreateNamespace(credentialsNS)
+
+	return &credentials{
+		store:  store,
+		cipher: cipher,
+	}
+}
+
+// List returns the profiles ordered by name, secrets are masked
+func (c *credentials) List() ([]*model.CredentialProfile, error) {
+	guids, err := c.store.List(credentialsNS)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*model.CredentialProfile, len(guids))
+	for i, guid := range guids {
+		profiles[i], err = c.Get(guid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	model.SortCredentialProfiles(profiles)
+	return profiles, nil
+}
+
+// Get returns a profile, secrets are masked
+func (c *credentials) Get(guid string) (*model.CredentialProfile, error) {
+	profile, err := c.get(guid)
+	if err != nil {
+		return nil, err
+	}
+	return profile.Masked(), nil
+}
+
+// Create stores a new profile, names are unique
+func (c *credentials) Create(profile *model.CredentialProfile) error {
+	if err := c.validate(profile); err != nil {
+		return err
+	}
+
+	stored, err := c.encrypt(profile)
+	if err != nil {
+		return err
+	}
+
+	guid, err := c.store.Create(credentialsNS, stored)
+	if err != nil {
+		return err
+	}
+
+	profile.GUID = guid
+	*profile = *profile.Masked()
+	return nil
+}
+
+// Update replaces a stored profile. Secrets left empty keep their stored value, so a profile can be
+// renamed or moved to another region without resending its keys.
+func (c *credentials) Update(profile *model.CredentialProfile) error {
+	existing, err := c.get(profile.GUID)
+	if err != nil {
+		return err
+	}
+	profile.KeepSecrets(existing)
+
+	if err := c.validate(profile); err != nil {
+		return err
+	}
+
+	stored, err := c.encrypt(profile)
+	if err != nil {
+		return err
+	}
+
+	err = c.store.Update(credentialsNS, profile.GUID, stored)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Updated credential profile '%s'", profile.Name)
+	*profile = *profile.Masked()
+	return nil
+}
+
+// Delete removes a profile, profiles assigned to a project cannot be deleted
+func (c *credentials) Delete(guid string) error {
+	profile, err := c.get(guid)
+	if err != nil {
+		return err
+	}
+
+	guids, err := c.store.List(projectNS)
+	if err != nil {
+		return err
+	}
+	for _, prjGUID := range guids {
+		var prj model.Project
+		if err := c.store.Get(projectNS, prjGUID, &prj); err != nil {
+			return err
+		}
+		for _, assigned := range prj.Credentials {
+			if assigned == guid {
+				return Conflict("Credential profile '%s' is assigned to project '%s'", profile.Name, prj.Name)
+			}
+		}
+	}
+
+	return c.store.Delete(credentialsNS, guid)
+}
+
+// Inject passes the profiles assigned to the project to a task, in the order they are assigned. AWS
+// profiles using shared files write them to temporary files that only exist while the task runs.
+func (c *credentials) Inject(prj *model.Project, t *execute.Task) error {
+	if len(prj.Credentials) == 0 {
+		return nil
+	}
+
+	if t.Environment == nil {
+		t.Environment = make(map[string]string)
+	}
+
+	for _, guid := range prj.Credentials {
+		profile, err := c.get(guid)
+		if err != nil {
+			return fmt.Errorf("Error reading credential profile '%s' of project '%s': %s", guid, prj.Name, err)
+		}
+
+		env := profile.Environment()
+		if profile.UsesSharedFiles() {
+			env, err = c.sharedFiles(profile, t)
+			if err != nil {
+				return err
+			}
+		}
+
+		for k, v := range env {
+			t.Environment[k] = v
+		}
+		log.Printf("[DEBUG] Passing credential profile '%s' to project '%s'", profile.Name, prj.Name)
+	}
+
+	return nil
+}
+
+// sharedFiles adds the AWS shared files of the profile to the task, returning the environment using them
+func (c *credentials) sharedFiles(profile *model.CredentialProfile, t *execute.Task) (map[string]string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	credentialsFile := filepath.Join(os.TempDir(), fmt.Sprintf("tfwatch-%s.credentials", id))
+	configFile := filepath.Join(os.TempDir(), fmt.Sprintf("tfwatch-%s.config", id))
+	credentials, config, env := profile.SharedFiles(credentialsFile, configFile)
+
+	if t.Files == nil {
+		t.Files = make(map[string][]byte)
+	}
+	t.Files[credentialsFile] = credentials
+	t.Files[configFile] = config
+
+	return env, nil
+}
+
+// validate checks the profile is complete and its name is unique
+func (c *credentials) validate(profile *model.CredentialProfile) error {
+	if err := profile.Validate(); err != nil {
+		return &ValidationError{err}
+	}
+
+	existing, err := c.List()
+	if err != nil {
+		return err
+	}
+	for _, e := range existing {
+		if e.Name == profile.Name && e.GUID != profile.GUID {
+			return Conflict("Credential profile '%s' already exists", profile.Name)
+		}
+	}
+	return nil
+}
+
+// get returns a decrypted profile
+func (c *credentials) get(guid string) (*model.CredentialProfile, error) {
+	var stored storedCredentials
+	err := c.store.Get(credentialsNS, guid, &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := c.cipher.Decrypt(stored.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting credential profile '%s', has the master key changed? %s", stored.Name, err)
+	}
+
+	var profile model.CredentialProfile
+	if err := json.Unmarshal(secret, &profile); err != nil {
+		return nil, err
+	}
+
+	profile.GUID = guid
+	return &profile, nil
+}
+
+// encrypt creates the stored form of a profile
+func (c *credentials) encrypt(profile *model.CredentialProfile) (*storedCredentials, error) {
+	b, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := c.cipher.Encrypt(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedCredentials{
+		Name:   profile.Name,
+		Type:   profile.Type,
+		Secret: secret,
+	}, nil
+}
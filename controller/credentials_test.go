@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/secure"
+)
+
+func TestCredentials_rotate_and_inject(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	cipher, err := secure.NewCipher(bytes.Repeat([]byte{3}, secure.KeySize))
+	assert.NoError(t, err)
+	creds := NewCredentialsController(store, cipher)
+
+	profile := &model.CredentialProfile{
+		Name: "prod",
+		Type: model.CredentialTypeAWS,
+		AWS:  &model.AWSCredentials{AccessKeyID: "AKIA1", SecretAccessKey: "first", Region: "us-east-1"},
+	}
+	assert.NoError(t, creds.Create(profile))
+	assert.Equal(t, "", profile.AWS.SecretAccessKey)
+	assert.Error(t, creds.Create(&model.CredentialProfile{Name: "prod", Type: model.CredentialTypeEnv, Env: map[string]string{"A": "b"}}))
+
+	// secrets are not stored in the clear
+	var stored storedCredentials
+	assert.NoError(t, store.Get(credentialsNS, profile.GUID, &stored))
+	assert.False(t, bytes.Contains(stored.Secret, []byte("first")))
+
+	prj := createProject(t, store, "applied")
+	prj.Credentials = []string{profile.GUID}
+	assert.NoError(t, store.Update(projectNS, prj.GUID, prj))
+
+	// rotating the key is a single update
+	assert.NoError(t, creds.Update(&model.CredentialProfile{
+		GUID: profile.GUID,
+		Name: "prod",
+		Type: model.CredentialTypeAWS,
+		AWS:  &model.AWSCredentials{AccessKeyID: "AKIA2", SecretAccessKey: "second", Region: "us-east-1"},
+	}))
+
+	task := &execute.Task{Command: "terraform"}
+	assert.NoError(t, creds.Inject(prj, task))
+	assert.Equal(t, "AKIA2", task.Environment["AWS_ACCESS_KEY_ID"])
+	assert.Equal(t, "second", task.Environment["AWS_SECRET_ACCESS_KEY"])
+	assert.Equal(t, 0, len(task.Files))
+
+	// updating without the secret keeps it, assuming a role uses shared files
+	assert.NoError(t, creds.Update(&model.CredentialProfile{
+		GUID: profile.GUID,
+		Name: "prod",
+		Type: model.CredentialTypeAWS,
+		AWS:  &model.AWSCredentials{AccessKeyID: "AKIA2", RoleARN: "arn:aws:iam::123:role/deploy"},
+	}))
+
+	task = &execute.Task{Command: "terraform"}
+	assert.NoError(t, creds.Inject(prj, task))
+	assert.NotContains(t, task.Environment, "AWS_SECRET_ACCESS_KEY")
+	assert.Equal(t, 2, len(task.Files))
+	assert.Contains(t, string(task.Files[task.Environment["AWS_SHARED_CREDENTIALS_FILE"]]), "aws_secret_access_key = second")
+
+	// assigned profiles cannot be deleted
+	assert.Error(t, creds.Delete(profile.GUID))
+	prj.Credentials = nil
+	assert.NoError(t, store.Update(projectNS, prj.GUID, prj))
+	assert.NoError(t, creds.Delete(profile.GUID))
+}
+
+// recordingExecutor records the tasks scheduled, which fail without running
+type recordingExecutor struct {
+	tasks []*execute.Task
+}
+
+func (e *recordingExecutor) Schedule(task *execute.Task) (*execute.ScheduledTask, error) {
+	e.tasks = append(e.tasks, task)
+	ch := make(chan *execute.Result, 1)
+	ch <- &execute.Result{Task: *task, ExitCode: 1}
+	return &execute.ScheduledTask{Task: *task, Channel: ch}, nil
+}
+
+func TestState_pull_injects_credentials(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	cipher, err := secure.NewCipher(bytes.Repeat([]byte{3}, secure.KeySize))
+	assert.NoError(t, err)
+	creds := NewCredentialsController(store, cipher)
+
+	profile := &model.CredentialProfile{
+		Name: "state",
+		Type: model.CredentialTypeAWS,
+		AWS:  &model.AWSCredentials{AccessKeyID: "AKIA1", SecretAccessKey: "secret", Region: "us-east-1"},
+	}
+	assert.NoError(t, creds.Create(profile))
+
+	// without a local state file, the state is pulled from the backend
+	dir, err := ioutil.TempDir("", "tfwatch-remote")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prj := createProject(t, store, "remote")
+	prj.LocalPath = dir
+	prj.Credentials = []string{profile.GUID}
+
+	executor := &recordingExecutor{}
+	_, err = NewStateController(executor, creds).Get(prj)
+	assert.Error(t, err)
+	if assert.Equal(t, 1, len(executor.tasks)) {
+		task := executor.tasks[0]
+		assert.Equal(t, []string{"state", "pull"}, task.Args)
+		assert.Equal(t, dir, task.WorkingDirectory)
+		assert.Equal(t, "AKIA1", task.Environment["AWS_ACCESS_KEY_ID"])
+		assert.Equal(t, "secret", task.Environment["AWS_SECRET_ACCESS_KEY"])
+	}
+}
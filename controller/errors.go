@@ -0,0 +1,63 @@
+package controller
+
+import "fmt"
+
+// NotFoundError is returned when an entity does not exist
+type NotFoundError struct {
+	error
+}
+
+// ValidationError is returned when a request is invalid, the request should not be repeated as is
+type ValidationError struct {
+	error
+}
+
+// ConflictError is returned when a request conflicts with the current state of an entity
+type ConflictError struct {
+	error
+}
+
+// UnauthorizedError is returned when the caller has not authenticated, or its credentials are invalid
+type UnauthorizedError struct {
+	error
+}
+
+// ForbiddenError is returned when the caller is not allowed to make a request
+type ForbiddenError struct {
+	error
+}
+
+// UnavailableError is returned when a request cannot be served now, but may be later
+type UnavailableError struct {
+	error
+}
+
+// NotFound returns a *NotFoundError with a formatted message
+func NotFound(format string, a ...interface{}) error {
+	return &NotFoundError{fmt.Errorf(format, a...)}
+}
+
+// Invalid returns a *ValidationError with a formatted message
+func Invalid(format string, a ...interface{}) error {
+	return &ValidationError{fmt.Errorf(format, a...)}
+}
+
+// Conflict returns a *ConflictError with a formatted message
+func Conflict(format string, a ...interface{}) error {
+	return &ConflictError{fmt.Errorf(format, a...)}
+}
+
+// Unauthorized returns an *UnauthorizedError with a formatted message
+func Unauthorized(format string, a ...interface{}) error {
+	return &UnauthorizedError{fmt.Errorf(format, a...)}
+}
+
+// Forbidden returns a *ForbiddenError with a formatted message
+func Forbidden(format string, a ...interface{}) error {
+	return &ForbiddenError{fmt.Errorf(format, a...)}
+}
+
+// Unavailable returns an *UnavailableError with a formatted message
+func Unavailable(format string, a ...interface{}) error {
+	return &UnavailableError{fmt.Errorf(format, a...)}
+}
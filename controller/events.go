@@ -0,0 +1,348 @@
+package controller
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// EventType is the kind of an event
+type EventType string
+
+// Types of events
+const (
+	EventProjectStatusChanged EventType = "project_status_changed"
+	EventPlanStarted          EventType = "plan_started"
+	EventPlanFinished         EventType = "plan_finished"
+	EventExecutionCreated     EventType = "execution_created"
+	EventApplyRequested       EventType = "apply_requested"
+
+	// EventReset is sent to a subscription that missed events, instead of the events it missed. It is
+	// the last event of a subscription that fell behind, and the first of one resuming after an event
+	// that is no longer held. Subscribers reload what they show on a reset, it is not filtered.
+	EventReset EventType = "reset"
+)
+
+// EventTypes are the types of events published
+var EventTypes = []EventType{
+	EventProjectStatusChanged,
+	EventPlanStarted,
+	EventPlanFinished,
+	EventExecutionCreated,
+	EventApplyRequested,
+}
+
+// eventHistory is the number of events held for subscribers resuming after a disconnect
+const eventHistory = 1024
+
+// eventBuffer is the number of events a subscription holds that have not been received
+const eventBuffer = 64
+
+// Event is something that happened to a project. Ids increase with each event published, and start
+// from the time the service started so ids published after a restart are higher than those before it.
+type Event struct {
+	ID          uint64      `json:"id"`
+	Type        EventType   `json:"type"`
+	ProjectGUID string      `json:"project_guid"`
+	Time        time.Time   `json:"time"`
+	Data        interface{} `json:"data,omitempty"`
+}
+
+// StatusChange is the data of a project_status_changed event. Previous is empty for a new project.
+type StatusChange struct {
+	Previous model.ProjectStatus `json:"previous"`
+	Status   model.ProjectStatus `json:"status"`
+}
+
+// TaskEvent is the data of the events of a task run in a project
+type TaskEvent struct {
+	TaskID   string     `json:"task_id"`
+	Command  string     `json:"command,omitempty"`
+	Trigger  string     `json:"trigger,omitempty"`
+	Actor    string     `json:"actor,omitempty"`
+	ExitCode *int       `json:"exit_code,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// EventFilter selects the events of a subscription, an empty field selects every event
+type EventFilter struct {
+	ProjectGUID string
+	Types       []EventType
+}
+
+// matches returns true when the filter selects an event
+func (f EventFilter) matches(e *Event) bool {
+	if f.ProjectGUID != "" && f.ProjectGUID != e.ProjectGUID {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Publisher publishes events
+type Publisher interface {
+	Publish(t EventType, projectGUID string, data interface{})
+}
+
+// Events publishes what happens to projects to subscribers. Status changes and executions are read
+// from the store's change feed, plans and applies are published by the projects controller.
+type Events interface {
+	Publisher
+
+	// Subscribe returns the held events published after the event with id after that match the filter,
+	// and subscribes to the matching events published later. An after of 0 returns no held events, an
+	// after that is no longer held returns a reset.
+	Subscribe(after uint64, filter EventFilter) ([]*Event, *EventSubscription)
+}
+
+// EventSubscription receives events on Events, in the order they were published. An event published
+// while the subscription's buffer is full is dropped for that subscription and counted by Dropped, a
+// reset event is sent in its place and the subscription is closed. Events is closed when the
+// subscription is closed.
+type EventSubscription struct {
+	Events <-chan *Event
+
+	events  chan *Event
+	filter  EventFilter
+	dropped uint64
+	hub     *events
+}
+
+// Dropped returns the number of events dropped because the subscription's buffer was full, the
+// subscription is closed after the first
+func (s *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close ends the subscription, closing Events
+func (s *EventSubscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+type events struct {
+	store         persist.Store
+	lock          sync.Mutex
+	id            uint64
+	history       []*Event
+	subscriptions map[*EventSubscription]bool
+	statuses      map[string]model.ProjectStatus
+}
+
+// NewEventsController creates a controller publishing events, reading the changes to projects and
+// their executions from the change feed
+func NewEventsController(store persist.Store, changes persist.Watcher) Events {
+	e := &events{
+		store:         store,
+		id:            uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		subscriptions: make(map[*EventSubscription]bool),
+		statuses:      make(map[string]model.ProjectStatus),
+	}
+
+	sub := changes.Watch(persist.DefaultWatchBuffer, func(ns string) bool {
+		return ns == projectNS || executionProject(ns) != ""
+	})
+	e.reload(false)
+	go e.watch(sub)
+
+	return e
+}
+
+// Publish numbers an event, holds it for resuming subscribers, and sends it to the matching
+// subscriptions without blocking
+func (e *events) Publish(t EventType, projectGUID string, data interface{}) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.id++
+	evt := &Event{e.id, t, projectGUID, time.Now().UTC(), data}
+
+	e.history = append(e.history, evt)
+	if len(e.history) > eventHistory {
+		e.history = e.history[len(e.history)-eventHistory:]
+	}
+
+	for s := range e.subscriptions {
+		if !s.filter.matches(evt) {
+			continue
+		}
+
+		// only Publish sends, under the lock, so the space kept for the reset is never taken
+		if len(s.events) < eventBuffer {
+			s.events <- evt
+			continue
+		}
+
+		atomic.AddUint64(&s.dropped, 1)
+		log.Printf("[DEBUG] Dropped event %d, the subscriber's buffer is full, resetting it", evt.ID)
+		s.events <- &Event{ID: evt.ID, Type: EventReset, Time: evt.Time}
+		delete(e.subscriptions, s)
+		close(s.events)
+	}
+}
+
+// Subscribe returns the held events after an id and subscribes to later events. Both are taken under
+// the lock, so no event is missed or received twice between them. Resuming after an event that is no
+// longer held, or that was published before a restart, returns a reset instead.
+func (e *events) Subscribe(after uint64, filter EventFilter) ([]*Event, *EventSubscription) {
+	// the buffer keeps space for the reset sent when it is full
+	ch := make(chan *Event, eventBuffer+1)
+	s := &EventSubscription{Events: ch, events: ch, filter: filter, hub: e}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	held := []*Event{}
+	if after > e.id || (after > 0 && after < e.id && (len(e.history) == 0 || after+1 < e.history[0].ID)) {
+		held = append(held, &Event{ID: e.id, Type: EventReset, Time: time.Now().UTC()})
+	} else if after > 0 {
+		for _, evt := range e.history {
+			if evt.ID > after && filter.matches(evt) {
+				held = append(held, evt)
+			}
+		}
+	}
+
+	e.subscriptions[s] = true
+	return held, s
+}
+
+// unsubscribe removes a subscription and closes its channel
+func (e *events) unsubscribe(s *EventSubscription) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.subscriptions[s] {
+		delete(e.subscriptions, s)
+		close(s.events)
+	}
+}
+
+// watch publishes events for the changes to projects and executions. Changes missed because the
+// subscription fell behind are found by reloading the project statuses.
+func (e *events) watch(sub *persist.Subscription) {
+	var dropped uint64
+	for c := range sub.Changes {
+		// sequence numbers skip the changes of other namespaces, the dropped count tells of a gap
+		if d := sub.Dropped(); d != dropped {
+			log.Printf("[WARN] Missed %d changes to projects, reloading their statuses", d-dropped)
+			dropped = d
+			e.reload(true)
+		}
+
+		switch {
+		case c.Namespace == "":
+			e.reload(true)
+		case c.Namespace == projectNS:
+			e.projectChanged(c)
+		case c.Operation == persist.Created:
+			e.executionCreated(c.Namespace, c.GUID)
+		}
+	}
+}
+
+// projectChanged publishes a status change when a project's status differs from the last one seen
+func (e *events) projectChanged(c persist.Change) {
+	if c.Operation == persist.Deleted {
+		if c.GUID == "" {
+			e.statuses = make(map[string]model.ProjectStatus)
+		}
+		delete(e.statuses, c.GUID)
+		return
+	}
+
+	prj := &model.Project{}
+	if err := e.store.Get(projectNS, c.GUID, prj); err != nil {
+		log.Printf("[DEBUG] Project '%s' changed but could not be read: %s", c.GUID, err)
+		return
+	}
+	e.statusChanged(c.GUID, prj.Status)
+}
+
+// statusChanged records a project's status, publishing the change from the last status seen. Stored
+// projects have no guid, it is the guid they are stored by.
+func (e *events) statusChanged(guid string, status model.ProjectStatus) {
+	previous, seen := e.statuses[guid]
+	if seen && previous == status {
+		return
+	}
+	e.statuses[guid] = status
+	e.Publish(EventProjectStatusChanged, guid, &StatusChange{previous, status})
+}
+
+// executionCreated publishes the creation of an execution
+func (e *events) executionCreated(ns, guid string) {
+	r := &execute.Result{}
+	if err := e.store.Get(ns, guid, r); err != nil {
+		log.Printf("[DEBUG] Execution '%s' created but could not be read: %s", guid, err)
+		return
+	}
+	e.Publish(EventExecutionCreated, executionProject(ns), resultEvent(r))
+}
+
+// reload reads the status of every project, publishing the changes from the statuses seen when
+// publish is true
+func (e *events) reload(publish bool) {
+	guids, err := e.store.List(projectNS)
+	if err != nil {
+		if _, ok := err.(*persist.NotFoundError); !ok {
+			log.Printf("[ERROR] Error reading project statuses: %s", err)
+		}
+		e.statuses = make(map[string]model.ProjectStatus)
+		return
+	}
+
+	statuses := make(map[string]model.ProjectStatus, len(guids))
+	for _, guid := range guids {
+		prj := &model.Project{}
+		if err := e.store.Get(projectNS, guid, prj); err != nil {
+			continue
+		}
+		if publish {
+			e.statusChanged(guid, prj.Status)
+		}
+		statuses[guid] = prj.Status
+	}
+	e.statuses = statuses
+}
+
+// taskEvent returns the event data of a task, the command is the terraform subcommand
+func taskEvent(taskID string, t *execute.Task) *TaskEvent {
+	command := t.Command
+	if len(t.Args) > 0 {
+		command += " " + t.Args[0]
+	}
+	return &TaskEvent{TaskID: taskID, Command: command, Trigger: t.Trigger, Actor: t.Actor}
+}
+
+// resultEvent returns the event data of a task's result
+func resultEvent(r *execute.Result) *TaskEvent {
+	data := taskEvent(r.GUID, &r.Task)
+	data.ExitCode = &r.ExitCode
+	data.Started = &r.Started
+	data.Finished = &r.Finished
+	return data
+}
+
+// executionProject returns the guid of the project of an executions namespace, empty for any other
+// namespace
+func executionProject(ns string) string {
+	guid := strings.TrimSuffix(strings.TrimPrefix(ns, "project-"), "-executions")
+	if guid == "" || (&model.Project{GUID: guid}).ExecutionNS() != ns {
+		return ""
+	}
+	return guid
+}
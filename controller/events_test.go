@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// receive returns the next event of a subscription, failing the test when none arrives
+func receive(t *testing.T, sub *EventSubscription) *Event {
+	select {
+	case e := <-sub.Events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received")
+		return nil
+	}
+}
+
+func TestEvents_changes(t *testing.T) {
+	store := persist.NewWatchedStore(persist.NewMemoryStore())
+	events := NewEventsController(store, store)
+
+	_, sub := events.Subscribe(0, EventFilter{})
+	defer sub.Close()
+
+	prj := createProject(t, store, "foo")
+	e := receive(t, sub)
+	assert.Equal(t, EventProjectStatusChanged, e.Type)
+	assert.Equal(t, prj.GUID, e.ProjectGUID)
+	assert.Equal(t, &StatusChange{"", model.ProjectStatusNew}, e.Data)
+
+	// updates that keep the status publish nothing
+	prj.Settings = map[string]string{"a": "b"}
+	assert.Nil(t, store.Update(projectNS, prj.GUID, prj))
+	prj.Status = model.ProjectStatusPending
+	assert.Nil(t, store.Update(projectNS, prj.GUID, prj))
+
+	e = receive(t, sub)
+	assert.Equal(t, EventProjectStatusChanged, e.Type)
+	assert.Equal(t, &StatusChange{model.ProjectStatusNew, model.ProjectStatusPending}, e.Data)
+
+	ns, err := executionNamespace(store, prj)
+	assert.Nil(t, err)
+	_, err = store.Create(ns, &execute.Result{
+		GUID:     "task",
+		Task:     execute.Task{Command: "terraform", Args: []string{"plan", "-out", "plan"}, Trigger: execute.TriggerAPI},
+		ExitCode: 2,
+	})
+	assert.Nil(t, err)
+
+	e = receive(t, sub)
+	assert.Equal(t, EventExecutionCreated, e.Type)
+	assert.Equal(t, prj.GUID, e.ProjectGUID)
+	data := e.Data.(*TaskEvent)
+	assert.Equal(t, "task", data.TaskID)
+	assert.Equal(t, "terraform plan", data.Command)
+	assert.Equal(t, 2, *data.ExitCode)
+}
+
+func TestEvents_filter_and_resume(t *testing.T) {
+	store := persist.NewWatchedStore(persist.NewMemoryStore())
+	events := NewEventsController(store, store)
+
+	_, feed := events.Subscribe(0, EventFilter{})
+	events.Publish(EventPlanStarted, "1", nil)
+	events.Publish(EventApplyRequested, "1", nil)
+	events.Publish(EventPlanStarted, "2", nil)
+	feed.Close()
+
+	// ids increase with each event
+	all := []*Event{}
+	for e := range feed.Events {
+		all = append(all, e)
+	}
+	assert.Len(t, all, 3)
+	assert.Equal(t, all[0].ID+1, all[1].ID)
+	assert.Equal(t, all[1].ID+1, all[2].ID)
+
+	held, sub := events.Subscribe(all[0].ID, EventFilter{ProjectGUID: "1"})
+	defer sub.Close()
+	assert.Len(t, held, 1)
+	assert.Equal(t, EventApplyRequested, held[0].Type)
+
+	held, plans := events.Subscribe(all[0].ID, EventFilter{Types: []EventType{EventPlanStarted}})
+	defer plans.Close()
+	assert.Len(t, held, 1)
+	assert.Equal(t, "2", held[0].ProjectGUID)
+
+	events.Publish(EventPlanFinished, "2", nil)
+	events.Publish(EventPlanStarted, "1", nil)
+	assert.Equal(t, EventPlanStarted, receive(t, sub).Type)
+	assert.Equal(t, "1", receive(t, plans).ProjectGUID)
+
+	// a subscriber that falls behind receives the buffered events, then a reset, and is closed
+	_, slow := events.Subscribe(0, EventFilter{})
+	for i := 0; i < eventBuffer+3; i++ {
+		events.Publish(EventPlanStarted, "3", nil)
+	}
+	assert.Equal(t, uint64(1), slow.Dropped())
+
+	received := []*Event{}
+	for e := range slow.Events {
+		received = append(received, e)
+	}
+	assert.Len(t, received, eventBuffer+1)
+	reset := received[eventBuffer]
+	assert.Equal(t, EventReset, reset.Type)
+	assert.Equal(t, received[eventBuffer-1].ID+1, reset.ID)
+	slow.Close()
+
+	// resuming after an event that is no longer held, or was never published, is a reset
+	for i := 0; i < eventHistory; i++ {
+		events.Publish(EventPlanFinished, "3", nil)
+	}
+	for _, after := range []uint64{all[0].ID, all[0].ID + 1e9} {
+		held, resumed := events.Subscribe(after, EventFilter{ProjectGUID: "1"})
+		resumed.Close()
+		assert.Len(t, held, 1)
+		assert.Equal(t, EventReset, held[0].Type)
+	}
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"log"
+	"regexp"
+
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// Migrations of the values stored by the controllers. Add a migration with the next version whenever
+// a stored type changes in a way existing values cannot be read as, such as renaming or retyping a field.
+func init() {
+	persist.RegisterMigration(persist.Migration{
+		Version:     1,
+		Description: "Remove the executions, outputs and variables of deleted projects",
+		Migrate:     removeDeletedProjectNamespaces,
+	})
+	persist.RegisterMigration(persist.Migration{
+		Version:     2,
+		Description: "Record the current outputs of every project, so outputs are found by name",
+		Migrate:     recordCurrentOutputs,
+	})
+}
+
+// projectNamespacePattern matches the namespaces of a project's values, capturing the project guid
+var projectNamespacePattern = regexp.MustCompile(`^project-(.+)-(executions|outputs|variables)$`)
+
+// removeDeletedProjectNamespaces removes the namespaces left behind by projects deleted before their
+// namespaces were deleted with them
+func removeDeletedProjectNamespaces(store persist.Store) error {
+	if err := store.CreateNamespace(projectNS); err != nil {
+		return err
+	}
+
+	guids, err := store.List(projectNS)
+	if err != nil {
+		return err
+	}
+	projects := make(map[string]bool, len(guids))
+	for _, guid := range guids {
+		projects[guid] = true
+	}
+
+	namespaces, err := store.Namespaces()
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces {
+		match := projectNamespacePattern.FindStringSubmatch(ns)
+		if match == nil || projects[match[1]] {
+			continue
+		}
+
+		log.Printf("[INFO] Removing namespace '%s' of deleted project '%s'", ns, match[1])
+		if err := store.DeleteNamespace(ns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordCurrentOutputs records the outputs of the latest snapshot of every project as its current
+// outputs, which were only read from the snapshots before
+func recordCurrentOutputs(store persist.Store) error {
+	if err := store.CreateNamespace(projectNS); err != nil {
+		return err
+	}
+	if err := store.CreateNamespace(currentOutputsNS); err != nil {
+		return err
+	}
+	for _, index := range []persist.Index{outputNameIndex, outputProjectIndex} {
+		if err := store.CreateIndex(currentOutputsNS, index); err != nil {
+			return err
+		}
+	}
+
+	guids, err := store.List(projectNS)
+	if err != nil {
+		return err
+	}
+
+	o := &outputs{store: store}
+	for _, guid := range guids {
+		prj := &model.Project{}
+		if err := store.Get(projectNS, guid, prj); err != nil {
+			return err
+		}
+		prj.GUID = guid
+
+		latest, err := o.latest(prj)
+		if err != nil {
+			return err
+		}
+		if latest == nil {
+			continue
+		}
+
+		log.Printf("[INFO] Recording the current outputs of project '%s'", prj.Name)
+		if err := o.setCurrent(prj, latest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
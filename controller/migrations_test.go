@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+func TestMigrations_removeDeletedProjectNamespaces(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	prj := createProject(t, store, "applied")
+	deleted := &model.Project{GUID: "deleted"}
+	for _, ns := range []string{prj.ExecutionNS(), prj.OutputNS(), deleted.ExecutionNS(), deleted.VariablesNS(), "variables"} {
+		assert.NoError(t, store.CreateNamespace(ns))
+	}
+
+	assert.NoError(t, removeDeletedProjectNamespaces(store))
+
+	namespaces, err := store.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{prj.ExecutionNS(), prj.OutputNS(), "projects", "variables"}, namespaces)
+}
+
+func TestMigrations_recordCurrentOutputs(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	prj := createProject(t, store, "applied")
+	createProject(t, store, "never-applied")
+	state, err := fixtureState{}.Get(prj)
+	assert.NoError(t, err)
+	assert.NoError(t, store.CreateNamespace(prj.OutputNS()))
+	_, err = store.Create(prj.OutputNS(), model.NewOutputSnapshot(state))
+	assert.NoError(t, err)
+
+	assert.NoError(t, recordCurrentOutputs(store))
+
+	found, err := NewOutputsController(store, fixtureState{}).Find("vpc_id")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "applied", found[0].ProjectName)
+	assert.Equal(t, "vpc-0a1b2c3d", found[0].Value)
+}
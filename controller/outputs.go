@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// currentOutputsNS holds the current value of every output of every project, a value per output, so
+// outputs are found by name without reading the snapshots of every project
+const currentOutputsNS = "outputs"
+
+// outputNameIndex finds the current outputs by name
+var outputNameIndex = persist.Index{
+	Name: "name",
+	Key: func(value interface{}) string {
+		if o, ok := value.(*model.ProjectOutput); ok {
+			return o.Name
+		}
+		return ""
+	},
+	New: func() interface{} { return &model.ProjectOutput{} },
+}
+
+// outputProjectIndex finds the current outputs of a project
+var outputProjectIndex = persist.Index{
+	Name: "project",
+	Key: func(value interface{}) string {
+		if o, ok := value.(*model.ProjectOutput); ok {
+			return o.ProjectGUID
+		}
+		return ""
+	},
+	New: func() interface{} { return &model.ProjectOutput{} },
+}
+
+// Outputs records the history of project outputs
+type Outputs interface {
+	Snapshot(prj *model.Project) error
+	History(prj *model.Project, name string) ([]*model.OutputHistoryEntry, error)
+	Find(name string) ([]*model.ProjectOutput, error)
+	Delete(prj *model.Project) error
+}
+
+type outputs struct {
+	store persist.Store
+	state State
+
+	// currentLock is held while the current outputs of a project are replaced
+	currentLock sync.Mutex
+}
+
+// NewOutputsController creates a controller for recording and querying project outputs
+func NewOutputsController(store persist.Store, state State) Outputs {
+	store.CreateNamespace(currentOutputsNS)
+	for _, index := range []persist.Index{outputNameIndex, outputProjectIndex} {
+		if err := store.CreateIndex(currentOutputsNS, index); err != nil {
+			log.Printf("[ERROR] Error indexing outputs: %s", err)
+		}
+	}
+
+	return &outputs{
+		store: store,
+		state: state,
+	}
+}
+
+// Snapshot records the current outputs of the project, a snapshot is only stored when a value
+// has changed since the last one
+func (o *outputs) Snapshot(prj *model.Project) error {
+	state, err := o.state.Get(prj)
+	if _, ok := err.(*model.StateNotFoundError); ok {
+		log.Printf("[DEBUG] Project '%s' has no state, no outputs to snapshot", prj.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = o.store.CreateNamespace(prj.OutputNS())
+	if err != nil {
+		return err
+	}
+
+	snapshot := model.NewOutputSnapshot(state)
+
+	latest, err := o.latest(prj)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.SameValues(latest) {
+		log.Printf("[DEBUG] Outputs of project '%s' unchanged", prj.Name)
+		return nil
+	}
+
+	log.Printf("[INFO] Recording outputs of project '%s' at serial %d", prj.Name, snapshot.Serial)
+	_, err = o.store.Create(prj.OutputNS(), snapshot)
+	if err != nil {
+		return err
+	}
+	return o.setCurrent(prj, snapshot)
+}
+
+// History returns the values the named output of the project has held
+func (o *outputs) History(prj *model.Project, name string) ([]*model.OutputHistoryEntry, error) {
+	snapshots, err := o.snapshots(prj)
+	if err != nil {
+		return nil, err
+	}
+	return model.OutputHistory(snapshots, name), nil
+}
+
+// Find returns the current value of the named output in every project exporting it. When name is
+// empty, every output of every project is returned. Project names are read when the outputs are found,
+// so renamed projects have their current name.
+func (o *outputs) Find(name string) ([]*model.ProjectOutput, error) {
+	q := persist.Query{}
+	if name != "" {
+		q = persist.Exact(name)
+	}
+
+	found := []*model.ProjectOutput{}
+	err := o.store.Range(currentOutputsNS, outputNameIndex.Name, q, func(key, guid string, decode func(interface{}) error) (bool, error) {
+		output := &model.ProjectOutput{}
+		if err := decode(output); err != nil {
+			return false, err
+		}
+		found = append(found, output)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	current := found[:0]
+	for _, output := range found {
+		if _, ok := names[output.ProjectGUID]; !ok {
+			var prj model.Project
+			err := o.store.Get(projectNS, output.ProjectGUID, &prj)
+			if _, ok := err.(*persist.NotFoundError); ok {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			names[output.ProjectGUID] = prj.Name
+		}
+		output.ProjectName = names[output.ProjectGUID]
+		current = append(current, output)
+	}
+	found = current
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Name != found[j].Name {
+			return found[i].Name < found[j].Name
+		}
+		return found[i].ProjectName < found[j].ProjectName
+	})
+
+	return found, nil
+}
+
+// Delete removes the current outputs of a project, its snapshots are removed with its namespaces
+func (o *outputs) Delete(prj *model.Project) error {
+	o.currentLock.Lock()
+	defer o.currentLock.Unlock()
+
+	return o.deleteCurrent(prj)
+}
+
+// setCurrent replaces the current outputs of a project with the outputs of a snapshot
+func (o *outputs) setCurrent(prj *model.Project, snapshot *model.OutputSnapshot) error {
+	o.currentLock.Lock()
+	defer o.currentLock.Unlock()
+
+	if err := o.deleteCurrent(prj); err != nil {
+		return err
+	}
+
+	for _, output := range snapshot.Outputs {
+		_, err := o.store.Create(currentOutputsNS, &model.ProjectOutput{
+			ProjectGUID: prj.GUID,
+			ProjectName: prj.Name,
+			Name:        output.Name,
+			Type:        output.Type,
+			Sensitive:   output.Sensitive,
+			Value:       output.Value,
+			Updated:     snapshot.Taken,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCurrent removes the current outputs of a project
+func (o *outputs) deleteCurrent(prj *model.Project) error {
+	guids, err := o.store.Query(currentOutputsNS, outputProjectIndex.Name, persist.Exact(prj.GUID))
+	if err != nil {
+		return err
+	}
+
+	for _, guid := range guids {
+		if err := o.store.Delete(currentOutputsNS, guid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latest returns the most recent snapshot of the project, nil if none have been taken
+func (o *outputs) latest(prj *model.Project) (*model.OutputSnapshot, error) {
+	snapshots, err := o.snapshots(prj)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return snapshots[len(snapshots)-1], nil
+}
+
+// snapshots returns the snapshots of the project, oldest first
+func (o *outputs) snapshots(prj *model.Project) ([]*model.OutputSnapshot, error) {
+	err := o.store.CreateNamespace(prj.OutputNS())
+	if err != nil {
+		return nil, err
+	}
+
+	guids, err := o.store.List(prj.OutputNS())
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*model.OutputSnapshot, len(guids))
+	for i, guid := range guids {
+		err = o.store.Get(prj.OutputNS(), guid, &snapshots[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	model.SortSnapshots(snapshots)
+	return snapshots, nil
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+	"github.com/webdevwilson/tfwatch/test"
+)
+
+// fixtureState reads state from the terraform_applied fixture regardless of the project
+type fixtureState struct{}
+
+func (fixtureState) Get(prj *model.Project) (*model.State, error) {
+	f, err := os.Open("../fixtures/terraform_applied/terraform.tfstate")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return model.ReadState(f)
+}
+
+func TestMain(m *testing.M) {
+	test.SuppressLogs()
+	os.Exit(m.Run())
+}
+
+func createStore(t *testing.T) (persist.Store, func()) {
+	dir, err := ioutil.TempDir("", "tfwatch-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := persist.NewLocalFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func createProject(t *testing.T, store persist.Store, name string) *model.Project {
+	store.CreateNamespace(projectNS)
+
+	prj := model.NewProject(name, "../fixtures/terraform_applied")
+	guid, err := store.Create(projectNS, prj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prj.GUID = guid
+	return prj
+}
+
+func TestOutputs_snapshot_and_history(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	outputs := NewOutputsController(store, fixtureState{})
+	prj := createProject(t, store, "applied")
+
+	assert.NoError(t, outputs.Snapshot(prj))
+	assert.NoError(t, outputs.Snapshot(prj))
+
+	// unchanged outputs are only recorded once
+	guids, err := store.List(prj.OutputNS())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(guids))
+
+	history, err := outputs.History(prj, "vpc_id")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, "vpc-0a1b2c3d", history[0].Value)
+
+	history, err = outputs.History(prj, "db_password")
+	assert.NoError(t, err)
+	assert.Equal(t, model.SensitiveMask, history[0].Value)
+}
+
+func TestOutputs_find(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	outputs := NewOutputsController(store, fixtureState{})
+	a := createProject(t, store, "a")
+	b := createProject(t, store, "b")
+	createProject(t, store, "never-applied")
+
+	assert.NoError(t, outputs.Snapshot(b))
+	assert.NoError(t, outputs.Snapshot(a))
+
+	found, err := outputs.Find("vpc_id")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, "a", found[0].ProjectName)
+	assert.Equal(t, "b", found[1].ProjectName)
+	assert.Equal(t, "vpc-0a1b2c3d", found[0].Value)
+
+	found, err = outputs.Find("")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(found))
+
+	// outputs are found by name without reading snapshots, and have the current name of the project
+	assert.NoError(t, store.DeleteNamespace(a.OutputNS()))
+	a.Name = "renamed"
+	assert.NoError(t, store.Update(projectNS, a.GUID, a))
+	found, err = outputs.Find("vpc_id")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, "b", found[0].ProjectName)
+	assert.Equal(t, "renamed", found[1].ProjectName)
+}
@@ -5,16 +5,54 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-
+	"strings"
 	"time"
 
 	"github.com/webdevwilson/tfwatch/execute"
 	"github.com/webdevwilson/tfwatch/model"
 	"github.com/webdevwilson/tfwatch/persist"
+	"github.com/webdevwilson/tfwatch/secure"
 )
 
 const projectNS = "projects"
 
+// projectNameIndex indexes projects by their unique name
+var projectNameIndex = persist.Index{
+	Name:   "name",
+	Unique: true,
+	Key: func(value interface{}) string {
+		if prj, ok := value.(*model.Project); ok {
+			return prj.Name
+		}
+		return ""
+	},
+	New: func() interface{} { return &model.Project{} },
+}
+
+// projectPathIndex finds projects by their directory, which names may no longer match
+var projectPathIndex = persist.Index{
+	Name: "path",
+	Key: func(value interface{}) string {
+		if prj, ok := value.(*model.Project); ok {
+			return filepath.Clean(prj.LocalPath)
+		}
+		return ""
+	},
+	New: func() interface{} { return &model.Project{} },
+}
+
+// executionStartedIndex orders the executions of a project by the time they started
+var executionStartedIndex = persist.Index{
+	Name: "started",
+	Key: func(value interface{}) string {
+		if r, ok := value.(*execute.Result); ok {
+			return persist.TimeKey(r.Started)
+		}
+		return ""
+	},
+	New: func() interface{} { return &execute.Result{} },
+}
+
 var bootstrapIgnores = []string{".git", ".tfwatch", "node_modules"}
 
 // Projects hosts the business logic for projects
@@ -23,28 +61,47 @@ type Projects interface {
 	Get(guid string) (*model.Project, error)
 	GetByName(name string) (*model.Project, error)
 	Create(prj *model.Project) (err error)
+	CreateFromInput(in *model.ProjectInput) (*model.Project, error)
 	Update(prj *model.Project) error
+	UpdateFromInput(guid string, in *model.ProjectInput) (*model.Project, error)
+	Patch(guid string, revision uint64, patch []byte) (*model.Project, error)
 	Delete(guid string) error
-	ExecutePlan(prj *model.Project) (taskID string, err error)
-	GetExecutions(prj *model.Project) (results []*execute.Result, err error)
+	Plan(prj *model.Project, overrides map[string]string, actor string) (taskID string, err error)
+	ExecutePlan(prj *model.Project, actor string) (taskID string, err error)
+	GetExecutions(prj *model.Project, filter ExecutionFilter) (*ExecutionPage, error)
 }
 
 type projects struct {
+	dir          string
 	store        persist.Store
 	executor     execute.Executor
+	outputs      Outputs
+	variables    Variables
+	credentials  Credentials
+	events       Publisher
 	planInterval time.Duration
 	runPlans     bool
 }
 
-// NewProjectsController creates a new controller
-func NewProjectsController(dir string, store persist.Store, executor execute.Executor,
-	interval time.Duration, runPlans bool) Projects {
+// NewProjectsController creates a new controller, plans and applies are published to events
+func NewProjectsController(dir string, store persist.Store, executor execute.Executor, outputs Outputs,
+	variables Variables, credentials Credentials, events Publisher, interval time.Duration, runPlans bool) Projects {
 
 	store.CreateNamespace(projectNS)
+	for _, index := range []persist.Index{projectNameIndex, projectPathIndex} {
+		if err := store.CreateIndex(projectNS, index); err != nil {
+			log.Printf("[ERROR] Error indexing projects: %s", err)
+		}
+	}
 
 	p := &projects{
+		dir:          dir,
 		store:        store,
 		executor:     executor,
+		outputs:      outputs,
+		variables:    variables,
+		credentials:  credentials,
+		events:       events,
 		planInterval: interval,
 		runPlans:     runPlans,
 	}
@@ -112,17 +169,19 @@ func (p *projects) bootstrap(dir string) {
 			continue
 		}
 
-		name := path.Base(dir)
-		prj, err := p.GetByName(name)
-
+		// projects are matched by directory, they may have been renamed or created with another name
+		prj, err := p.getByPath(dir)
 		if err != nil {
 			log.Printf("[ERROR] Error bootstrapping projects: %s", err)
 			continue
 		}
 
 		if prj == nil {
+			name := path.Base(dir)
 			log.Printf("[INFO] Creating project '%s' found at '%s'", name, dir)
-			p.Create(model.NewProject(name, dir))
+			if err := p.Create(model.NewProject(name, dir)); err != nil {
+				log.Printf("[ERROR] Error creating project '%s': %s", name, err)
+			}
 		}
 	}
 }
@@ -137,8 +196,7 @@ func (p *projects) List() (projects []*model.Project, err error) {
 
 	projects = make([]*model.Project, len(guids))
 	for i, guid := range guids {
-		err = p.store.Get(projectNS, guid, &projects[i])
-		projects[i].GUID = guid
+		projects[i], err = p.Get(guid)
 		if err != nil {
 			return
 		}
@@ -150,36 +208,41 @@ func (p *projects) List() (projects []*model.Project, err error) {
 // GetProject fetches a project by guid
 func (p *projects) Get(guid string) (*model.Project, error) {
 	var prj model.Project
-	err := p.store.Get(projectNS, guid, &prj)
+	rev, err := p.store.GetRevision(projectNS, guid, &prj)
 
 	if err != nil {
 		return nil, err
 	}
 
 	prj.GUID = guid
+	prj.Revision = rev
+	summarize(&prj)
 	return &prj, err
 }
 
-// GetProjectByName returns the named project
-func (p *projects) GetByName(name string) (*model.Project, error) {
+// summarize fills in the summary of projects planned before summaries were recorded
+func summarize(prj *model.Project) {
+	if prj.Summary == nil {
+		prj.Summary = model.Summarize(prj.PendingChanges, nil)
+	}
+}
 
-	projects, err := p.store.List(projectNS)
-	if err != nil {
+// GetProjectByName returns the named project, nil if there is none
+func (p *projects) GetByName(name string) (*model.Project, error) {
+	guids, err := p.store.Query(projectNS, projectNameIndex.Name, persist.Exact(name))
+	if err != nil || len(guids) == 0 {
 		return nil, err
 	}
+	return p.Get(guids[0])
+}
 
-	for _, guid := range projects {
-		prj, err := p.Get(guid)
-
-		if err != nil {
-			return nil, err
-		}
-
-		if prj.Name == name {
-			return prj, nil
-		}
+// getByPath returns the project in a directory, nil if there is none
+func (p *projects) getByPath(dir string) (*model.Project, error) {
+	guids, err := p.store.Query(projectNS, projectPathIndex.Name, persist.Exact(filepath.Clean(dir)))
+	if err != nil || len(guids) == 0 {
+		return nil, err
 	}
-	return nil, nil
+	return p.Get(guids[0])
 }
 
 // CreateProject creates a new project
@@ -192,9 +255,10 @@ func (p *projects) Create(prj *model.Project) (err error) {
 	}
 
 	prj.GUID = guid
+	prj.Revision = 1
 
 	// create namespace to store executions for the project
-	err = p.store.CreateNamespace(prj.ExecutionNS())
+	_, err = p.executionNS(prj)
 	if err != nil {
 		return
 	}
@@ -205,49 +269,161 @@ func (p *projects) Create(prj *model.Project) (err error) {
 	return
 }
 
-// UpdateProject
+// Update stores the project. Projects with a revision are only stored if they have not been modified
+// since that revision, a *persist.ConflictError is returned otherwise.
 func (p *projects) Update(prj *model.Project) error {
-	return p.store.Update(projectNS, prj.GUID, prj)
+	if prj.Revision == 0 {
+		return p.store.Update(projectNS, prj.GUID, prj)
+	}
+
+	rev, err := p.store.CompareAndUpdate(projectNS, prj.GUID, prj.Revision, prj)
+	if err != nil {
+		return err
+	}
+	prj.Revision = rev
+	return nil
 }
 
-// DeleteProject
+// maxModifyAttempts limits the retries of a modification conflicting with concurrent updates
+const maxModifyAttempts = 5
+
+// modify applies a change to the latest revision of a project and stores it, retrying when the project
+// is updated concurrently. The modified project is returned.
+func (p *projects) modify(guid string, change func(prj *model.Project)) (*model.Project, error) {
+	for attempt := 1; ; attempt++ {
+		prj, err := p.Get(guid)
+		if err != nil {
+			return nil, err
+		}
+
+		change(prj)
+
+		rev, err := p.store.CompareAndUpdate(projectNS, guid, prj.Revision, prj)
+		if _, ok := err.(*persist.ConflictError); ok && attempt < maxModifyAttempts {
+			log.Printf("[DEBUG] Project '%s' modified concurrently, retrying: %s", guid, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		prj.Revision = rev
+		return prj, nil
+	}
+}
+
+// Delete removes a project with its executions, outputs and variables. Log files of the executions
+// are removed by the pruner.
 func (p *projects) Delete(guid string) error {
-	return p.store.Delete(projectNS, guid)
+	err := p.store.Delete(projectNS, guid)
+	if err != nil {
+		return err
+	}
+
+	prj := &model.Project{GUID: guid}
+	for _, ns := range []string{prj.ExecutionNS(), prj.OutputNS(), prj.VariablesNS()} {
+		if err := p.store.DeleteNamespace(ns); err != nil {
+			return err
+		}
+	}
+	return p.outputs.Delete(prj)
 }
 
-// ExecutePlan
-func (p *projects) ExecutePlan(prj *model.Project) (string, error) {
-	taskID, _, err := p.executeInProject(prj, &execute.Task{
-		Command: "terraform",
-		Args: []string{
-			"apply",
-			"-outfile",
-			"terraform.tfplan",
-		},
-	})
+// Plan runs a plan in the project now, overrides are variable values used for this plan only. The
+// actor requesting the plan is recorded on its execution. Overrides cannot reference secrets, only
+// stored values are trusted to.
+func (p *projects) Plan(prj *model.Project, overrides map[string]string, actor string) (string, error) {
+	for name, value := range overrides {
+		if secure.IsSecretRef(value) {
+			return "", Invalid("The override of variable '%s' references a secret, overrides cannot reference secrets", name)
+		}
+	}
 
-	return taskID, err
+	taskID, _ := p.runPlan(prj, overrides, execute.TriggerAPI, actor)
+	if taskID == "" {
+		if prj.Status == model.ProjectStatusMisconfigured {
+			return "", Conflict("Project '%s' has no value for variables %s", prj.Name, strings.Join(prj.MissingVariables, ", "))
+		}
+		return "", Unavailable("Plan of project '%s' could not be scheduled", prj.Name)
+	}
+	return taskID, nil
 }
 
-// GetExecutions returns the executions that have occurred in a project
-func (p *projects) GetExecutions(prj *model.Project) (r []*execute.Result, err error) {
-	guids, err := p.store.List(prj.ExecutionNS())
+// ExecutePlan applies the project's plan, outputs are recorded when the apply succeeds. The actor
+// approving the apply is recorded on its execution.
+func (p *projects) ExecutePlan(prj *model.Project, actor string) (string, error) {
+	task, err := terraformTask(p.credentials, prj, "apply", model.PlanFile)
 	if err != nil {
-		return
+		return "", err
 	}
-	r = make([]*execute.Result, len(guids))
+	task.Trigger = execute.TriggerAPI
+	task.Actor = actor
 
-	for i, guid := range guids {
-		p.store.Get(prj.ExecutionNS(), guid, &r[i])
+	taskID, ch, err := p.executeInProject(prj, task)
+	if err != nil {
+		return taskID, err
 	}
+	p.publish(EventApplyRequested, prj, taskEvent(taskID, task))
 
-	return
+	go func() {
+		if r := <-ch; r.ExitCode == 0 {
+			p.snapshotOutputs(prj)
+		}
+	}()
+
+	return taskID, err
+}
+
+// publish publishes an event of the project
+func (p *projects) publish(t EventType, prj *model.Project, data interface{}) {
+	if p.events != nil {
+		p.events.Publish(t, prj.GUID, data)
+	}
+}
+
+// snapshotOutputs records the outputs of the project
+func (p *projects) snapshotOutputs(prj *model.Project) {
+	if err := p.outputs.Snapshot(prj); err != nil {
+		log.Printf("[ERROR] Error recording outputs of project '%s': %s", prj.Name, err)
+	}
+}
+
+// executionNS returns the namespace of the project's executions, ensuring it exists and is indexed
+func (p *projects) executionNS(prj *model.Project) (string, error) {
+	return executionNamespace(p.store, prj)
+}
+
+// executionNamespace returns the namespace of a project's executions, ensuring it exists and is indexed
+func executionNamespace(store persist.Store, prj *model.Project) (string, error) {
+	ns := prj.ExecutionNS()
+	if err := store.CreateNamespace(ns); err != nil {
+		return "", err
+	}
+	return ns, store.CreateIndex(ns, executionStartedIndex)
+}
+
+// terraformTask returns a task running terraform with arguments in the project's directory, passed
+// the credential profiles assigned to the project. Every terraform command run in a project needs
+// them, remote state and providers are read with them.
+func terraformTask(credentials Credentials, prj *model.Project, args ...string) (*execute.Task, error) {
+	task := &execute.Task{
+		Command:          "terraform",
+		Args:             args,
+		WorkingDirectory: prj.LocalPath,
+	}
+	if err := credentials.Inject(prj, task); err != nil {
+		return nil, err
+	}
+	return task, nil
 }
 
 // executeInProject
 func (p *projects) executeInProject(prj *model.Project, t *execute.Task) (taskID string, ch <-chan *execute.Result, err error) {
 	t.WorkingDirectory = prj.LocalPath
 	st, err := p.executor.Schedule(t)
+	if err != nil {
+		return
+	}
 	taskID = st.GUID
 	writeCh := make(chan *execute.Result, 1)
 	ch = writeCh
@@ -255,7 +431,10 @@ func (p *projects) executeInProject(prj *model.Project, t *execute.Task) (taskID
 	// persist execution
 	go func() {
 		r := <-st.Channel
-		_, err := p.store.Create(prj.ExecutionNS(), r)
+		ns, err := p.executionNS(prj)
+		if err == nil {
+			_, err = p.store.Create(ns, r)
+		}
 		if err != nil {
 			log.Printf("[ERROR] Error persisting execution in project '%s': %s", prj.GUID, err)
 		}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// Limits on the number of executions returned in a page
+const (
+	DefaultExecutionLimit = 50
+	MaxExecutionLimit     = 500
+)
+
+// ExecutionFilter selects the executions of a project, zero values match every execution
+type ExecutionFilter struct {
+	ExitCode *int
+	Type     string // the terraform command, plan or apply
+	Trigger  string
+	Actor    string
+	Since    time.Time
+	Until    time.Time
+	Cursor   string
+	Limit    int
+}
+
+// ExecutionPage is a page of executions, newest first. NextCursor is passed in the filter to read
+// the following page, it is empty on the last page.
+type ExecutionPage struct {
+	Executions []*execute.Result `json:"executions"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// matches returns true if the execution is selected by the filter. Time bounds are applied by the index.
+func (f *ExecutionFilter) matches(r *execute.Result) bool {
+	if f.ExitCode != nil && r.ExitCode != *f.ExitCode {
+		return false
+	}
+	if f.Type != "" && (len(r.Args) == 0 || r.Args[0] != f.Type) {
+		return false
+	}
+	if f.Trigger != "" && r.Trigger != f.Trigger {
+		return false
+	}
+	return f.Actor == "" || r.Actor == f.Actor
+}
+
+// GetExecutions returns a page of the executions that have occurred in a project, newest first
+func (p *projects) GetExecutions(prj *model.Project, filter ExecutionFilter) (*ExecutionPage, error) {
+	ns, err := p.executionNS(prj)
+	if err != nil {
+		return nil, err
+	}
+
+	q := persist.Query{Reverse: true}
+	if !filter.Since.IsZero() {
+		q.Start = persist.TimeKey(filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		q.End = persist.TimeKey(filter.Until)
+	}
+	if filter.Cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(filter.Cursor)
+		if err != nil {
+			return nil, Invalid("Invalid cursor '%s'", filter.Cursor)
+		}
+		q.After = string(after)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultExecutionLimit
+	} else if limit > MaxExecutionLimit {
+		limit = MaxExecutionLimit
+	}
+
+	// filters are applied while visiting, so only the executions in the page are read from the
+	// store and one more to learn whether there is a following page
+	page := &ExecutionPage{Executions: []*execute.Result{}}
+	var last string
+	err = p.store.Range(ns, executionStartedIndex.Name, q, func(key, guid string, decode func(interface{}) error) (bool, error) {
+		var r execute.Result
+		if err := decode(&r); err != nil {
+			return false, err
+		}
+		if !filter.matches(&r) {
+			return true, nil
+		}
+
+		if len(page.Executions) == limit {
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(last))
+			return false, nil
+		}
+
+		page.Executions = append(page.Executions, &r)
+		last = persist.Cursor(key, guid)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
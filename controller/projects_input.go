@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// CreateFromInput creates the project described by an input, in a directory of the checkout directory
+// containing .tf files
+func (p *projects) CreateFromInput(in *model.ProjectInput) (*model.Project, error) {
+	if err := p.validate(in); err != nil {
+		return nil, err
+	}
+
+	dir, err := p.projectDir(in)
+	if err != nil {
+		return nil, err
+	}
+
+	prj := model.NewProject(in.Name, dir)
+	in.Apply(prj)
+	if err := p.Create(prj); err != nil {
+		return nil, err
+	}
+	return prj, nil
+}
+
+// UpdateFromInput replaces the editable fields of a project with those of the input. The update is
+// applied to the latest revision of the project, unless the input has a revision, which must be current.
+func (p *projects) UpdateFromInput(guid string, in *model.ProjectInput) (*model.Project, error) {
+	return p.edit(guid, in.Revision, func(prj *model.Project) (*model.ProjectInput, error) {
+		return in, nil
+	})
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to the editable fields of a project. The patch is applied
+// to the latest revision of the project, unless a revision is given, in the patch or as an argument,
+// which must be current.
+func (p *projects) Patch(guid string, revision uint64, patch []byte) (*model.Project, error) {
+	var doc interface{}
+	if err := json.Unmarshal(patch, &doc); err != nil {
+		return nil, Invalid("Invalid JSON merge patch: %s", err)
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return nil, Invalid("A JSON merge patch of a project must be an object")
+	}
+
+	if revision == 0 {
+		var r struct {
+			Revision uint64 `json:"revision"`
+		}
+		if err := json.Unmarshal(patch, &r); err != nil {
+			return nil, Invalid("Invalid revision: %s", err)
+		}
+		revision = r.Revision
+	}
+
+	return p.edit(guid, revision, func(prj *model.Project) (*model.ProjectInput, error) {
+		current := prj.Input()
+		current.Revision = 0
+		data, err := json.Marshal(current)
+		if err != nil {
+			return nil, err
+		}
+
+		var target interface{}
+		if err := json.Unmarshal(data, &target); err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(mergePatch(target, doc))
+		if err != nil {
+			return nil, err
+		}
+
+		in := &model.ProjectInput{}
+		if err := json.Unmarshal(data, in); err != nil {
+			return nil, Invalid("Invalid JSON merge patch: %s", err)
+		}
+		return in, nil
+	})
+}
+
+// edit stores the input returned by change for the latest revision of a project, retrying when the
+// project is updated concurrently. With a revision, the project must not have been modified since.
+func (p *projects) edit(guid string, revision uint64, change func(prj *model.Project) (*model.ProjectInput, error)) (*model.Project, error) {
+	for attempt := 1; ; attempt++ {
+		prj, err := p.Get(guid)
+		if err != nil {
+			return nil, err
+		}
+		if revision != 0 && revision != prj.Revision {
+			return nil, &persist.ConflictError{Namespace: projectNS, GUID: guid, Revision: revision, Current: prj.Revision}
+		}
+
+		in, err := change(prj)
+		if err != nil {
+			return nil, err
+		}
+		if in.Path != "" {
+			return nil, Invalid("The path of project '%s' cannot be changed", prj.Name)
+		}
+		if err := p.validate(in); err != nil {
+			return nil, err
+		}
+		in.Apply(prj)
+
+		rev, err := p.store.CompareAndUpdate(projectNS, guid, prj.Revision, prj)
+		if _, ok := err.(*persist.ConflictError); ok && revision == 0 && attempt < maxModifyAttempts {
+			log.Printf("[DEBUG] Project '%s' modified concurrently, retrying: %s", guid, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		prj.Revision = rev
+		return prj, nil
+	}
+}
+
+// validate checks an input, and that the variable sets and credential profiles it references exist
+func (p *projects) validate(in *model.ProjectInput) error {
+	if err := in.Validate(); err != nil {
+		return &ValidationError{err}
+	}
+
+	for _, guid := range in.VariableSets {
+		if _, err := p.variables.GetSet(guid); err != nil {
+			return referenceError("Variable set", guid, err)
+		}
+	}
+	for _, guid := range in.Credentials {
+		if _, err := p.credentials.Get(guid); err != nil {
+			return referenceError("Credential profile", guid, err)
+		}
+	}
+	return nil
+}
+
+// referenceError returns the error of reading an entity referenced by a project, a missing entity
+// makes the input invalid
+func referenceError(kind, guid string, err error) error {
+	if _, ok := err.(*persist.NotFoundError); ok {
+		return Invalid("%s '%s' does not exist", kind, guid)
+	}
+	return err
+}
+
+// projectDir returns the directory of a new project, its path in the checkout directory. The directory
+// must contain .tf files.
+func (p *projects) projectDir(in *model.ProjectInput) (string, error) {
+	rel := in.Path
+	if rel == "" {
+		rel = in.Name
+	}
+	if filepath.IsAbs(rel) {
+		return "", Invalid("The path '%s' must be relative to the checkout directory", rel)
+	}
+
+	root := filepath.Clean(p.dir)
+	dir := filepath.Join(root, rel)
+	if !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return "", Invalid("The path '%s' is outside the checkout directory", rel)
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return "", Invalid("Directory '%s' does not exist in the checkout directory", rel)
+	}
+
+	tf, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+	if len(tf) == 0 {
+		return "", Invalid("Directory '%s' contains no .tf files", rel)
+	}
+	return dir, nil
+}
+
+// mergePatch applies a JSON merge patch to a decoded JSON document. Members of a patch object replace
+// those of the target, null members remove them, and any other patch replaces the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = mergePatch(t[name], value)
+	}
+	return t
+}
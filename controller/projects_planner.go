@@ -1,11 +1,17 @@
 package controller
 
 import (
+	"bytes"
+	"io/ioutil"
 	"log"
+	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/webdevwilson/tfwatch/execute"
 	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
 )
 
 // schedulePlan schedules a plan to run in a project, then waits and schedules it again
@@ -18,74 +24,214 @@ func (p *projects) schedulePlan(interval time.Duration, prj *model.Project) {
 	}
 
 	go func() {
-		p.runPlan(prj)
+		// plan the latest revision, the project may have been updated since the last plan
+		current, err := p.Get(prj.GUID)
+		if _, ok := err.(*persist.NotFoundError); ok {
+			log.Printf("[INFO] Project '%s' no longer exists, stopping plans", prj.Name)
+			return
+		}
+
+		if err != nil {
+			log.Printf("[ERROR] Error reading project '%s', skipping plan: %s", prj.Name, err)
+		} else {
+			_, done := p.runPlan(current, nil, execute.TriggerSchedule, "")
+			<-done
+		}
+
 		time.AfterFunc(interval, func() {
-			<-p.runPlan(prj)
 			p.schedulePlan(interval, prj)
 		})
 	}()
 }
 
-func (p *projects) runPlan(prj *model.Project) (done <-chan bool) {
+// runPlan runs a plan in the project, overrides take precedence over every stored variable. Trigger
+// records what caused the plan, and actor who requested it. The returned channel is closed once the project is updated, the task id is empty when no plan was run.
+func (p *projects) runPlan(prj *model.Project, overrides map[string]string, trigger, actor string) (string, <-chan bool) {
+	done := make(chan bool, 1)
+
+	if !p.preflight(prj, overrides) {
+		close(done)
+		return "", done
+	}
+
 	log.Printf("[INFO] Running plan for project '%s'", prj.GUID)
-	task := &execute.Task{
-		Command: "terraform",
-		Args: []string{
-			"plan",
-			"-detailed-exitcode",
-			"-out",
-			"terraform.tfplan",
-		},
-	}
-	_, ch, err := p.executeInProject(prj, task)
+	task, err := terraformTask(p.credentials, prj, "plan", "-detailed-exitcode", "-out", model.PlanFile)
+	if err != nil {
+		log.Printf("[ERROR] Error passing credentials to plan of project '%s': %s", prj.Name, err)
+		close(done)
+		return "", done
+	}
+	task.Trigger = trigger
+	task.Actor = actor
+
+	err = p.variables.Inject(prj, overrides, task)
+	if err != nil {
+		log.Printf("[ERROR] Error passing variables to plan of project '%s': %s", prj.Name, err)
+		close(done)
+		return "", done
+	}
+
+	taskID, ch, err := p.executeInProject(prj, task)
 	if err != nil {
 		log.Printf("[ERROR] Error scheduling plan run: %s", err)
-		return
+		close(done)
+		return "", done
 	}
+	p.publish(EventPlanStarted, prj, taskEvent(taskID, task))
 
 	// when task is complete, update the project
-	done = make(chan bool, 1)
 	go p.planComplete(prj, ch, done)
 
-	return
+	return taskID, done
+}
+
+// preflight checks that every required variable of the project has a value before a plan is scheduled.
+// A project missing values is marked misconfigured, and false is returned so the plan is skipped.
+func (p *projects) preflight(prj *model.Project, overrides map[string]string) bool {
+	cfg, err := prj.Config()
+	if err != nil {
+		// terraform reports configuration errors in the plan output
+		log.Printf("[WARN] Skipping pre-flight checks of project '%s': %s", prj.Name, err)
+		return true
+	}
+
+	provided, err := prj.ProvidedVariables(os.Environ())
+	if err != nil {
+		log.Printf("[WARN] Skipping variable checks of project '%s': %s", prj.Name, err)
+		return true
+	}
+
+	resolved, err := p.variables.Resolve(prj, overrides)
+	if err != nil {
+		log.Printf("[WARN] Skipping variable checks of project '%s': %s", prj.Name, err)
+		return true
+	}
+	for _, v := range resolved {
+		provided[v.Name] = v.Source
+	}
+
+	missing := cfg.MissingVariables(provided)
+	if len(missing) == 0 {
+		prj.MissingVariables = nil
+		return true
+	}
+
+	if prj.Status != model.ProjectStatusMisconfigured {
+		log.Printf("[WARN] Project '%s' has no value for variables %s, skipping plans until they are set",
+			prj.Name, strings.Join(missing, ", "))
+	}
+
+	prj.Status = model.ProjectStatusMisconfigured
+	prj.MissingVariables = missing
+	_, err = p.modify(prj.GUID, func(latest *model.Project) {
+		latest.Status = model.ProjectStatusMisconfigured
+		latest.MissingVariables = missing
+	})
+	if err != nil {
+		log.Printf("[ERROR] Error updating project status: %s", err)
+	}
+
+	return false
 }
 
-func (p *projects) planComplete(prj *model.Project, ch <-chan *execute.Result, doneCh <-chan bool) {
+// planComplete records the result of a plan in the project. Results are applied to the latest revision
+// of the project, so updates made while the plan ran are kept.
+func (p *projects) planComplete(prj *model.Project, ch <-chan *execute.Result, done chan<- bool) {
+	defer close(done)
 
 	// wait for result
 	r := <-ch
 
-	// update project
-	prj.PlanUpdated = time.Now()
+	var status model.ProjectStatus
 	switch r.ExitCode {
 	case 0:
-		prj.Status = model.ProjectStatusOK
+		status = model.ProjectStatusOK
 	case 2:
-		prj.Status = model.ProjectStatusPending
+		status = model.ProjectStatusPending
 	default:
-		prj.Status = model.ProjectStatusError
-		log.Printf("[WARN] Plan failed on %s: %s", prj.Name, r.Output)
+		// only log the output when the project starts failing, not on every plan
+		if prj.Status != model.ProjectStatusError {
+			log.Printf("[WARN] Plan failed on %s: %s", prj.Name, r.Output)
+		} else {
+			log.Printf("[DEBUG] Plan failed on %s: %s", prj.Name, r.Output)
+		}
+		status = model.ProjectStatusError
 	}
-	log.Printf("[INFO] Project '%s' plan complete, updating status to '%s'", prj.GUID, prj.Status)
+	log.Printf("[INFO] Project '%s' plan complete, updating status to '%s'", prj.GUID, status)
 
-	// read the plan and add changes to project
-	if prj.Status == model.ProjectStatusPending {
-		plan, err := prj.Plan()
+	// an up-to-date project has nothing pending, otherwise read the plan for its changes
+	var changes []model.ResourceChange
+	switch status {
+	case model.ProjectStatusOK:
+		changes = []model.ResourceChange{}
+	case model.ProjectStatusPending:
+		p.renderJSONPlan(prj)
 
+		plan, err := prj.Plan()
 		if err != nil {
 			log.Printf("[ERROR] Error reading plan: %s", err)
+		} else {
+			planned := plan.ResourceChanges()
+			changes = make([]model.ResourceChange, len(planned))
+			for i, v := range planned {
+				changes[i] = *v
+			}
 		}
+	}
 
-		changes := plan.ResourceChanges()
-		prj.PendingChanges = make([]model.ResourceChange, len(changes))
-		for i, v := range changes {
-			prj.PendingChanges[i] = *v
+	// commit updates to the project, the summary of the last plan is used to compute the trend
+	updated, err := p.modify(prj.GUID, func(latest *model.Project) {
+		latest.PlanUpdated = time.Now()
+		latest.Status = status
+		latest.MissingVariables = nil
+		if changes != nil {
+			latest.PendingChanges = changes
+		}
+		if status != model.ProjectStatusError {
+			latest.Summary = model.Summarize(latest.PendingChanges, latest.Summary)
 		}
+	})
+	p.publish(EventPlanFinished, prj, resultEvent(r))
+	if err != nil {
+		log.Printf("[ERROR] Error updating project status: %s", err)
+		return
+	}
+
+	// outputs are recorded on the plan schedule, in addition to after each apply
+	if status != model.ProjectStatusError {
+		p.snapshotOutputs(updated)
+	}
+}
+
+// renderJSONPlan writes the `terraform show -json` rendering of the plan next to the plan file. Versions
+// of terraform that cannot render JSON fail the show, in which case the binary plan is read instead.
+func (p *projects) renderJSONPlan(prj *model.Project) {
+	jsonPlan := path.Join(prj.LocalPath, model.JSONPlanFile)
+
+	// never leave a rendering of a previous plan behind
+	if err := os.Remove(jsonPlan); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] Error removing stale JSON plan '%s': %s", jsonPlan, err)
 	}
 
-	// commit updates to the project
-	err := p.store.Update(projectNS, prj.GUID, prj)
+	task, err := terraformTask(p.credentials, prj, "show", "-json", model.PlanFile)
 	if err != nil {
-		log.Printf("[ERROR] Error updating project status: %s", err)
+		log.Printf("[ERROR] Error passing credentials to JSON plan rendering of project '%s': %s", prj.Name, err)
+		return
+	}
+
+	st, err := p.executor.Schedule(task)
+	if err != nil {
+		log.Printf("[ERROR] Error scheduling JSON plan rendering: %s", err)
+		return
+	}
+
+	r := <-st.Channel
+	if r.ExitCode != 0 || !bytes.HasPrefix(bytes.TrimSpace(r.Output), []byte("{")) {
+		log.Printf("[DEBUG] Terraform cannot render '%s' as JSON, using binary plan", prj.Name)
+		return
+	}
+
+	if err := ioutil.WriteFile(jsonPlan, r.Output, 0644); err != nil {
+		log.Printf("[WARN] Error writing JSON plan '%s': %s", jsonPlan, err)
 	}
 }
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+func TestProjects_planComplete_keeps_concurrent_updates(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	p := &projects{store: store, outputs: NewOutputsController(store, fixtureState{})}
+	created := createProject(t, store, "applied")
+
+	// the plan starts with the project as it is now
+	prj, err := p.Get(created.GUID)
+	assert.NoError(t, err)
+
+	// and the project is updated through the API while it runs
+	updated, err := p.Get(created.GUID)
+	assert.NoError(t, err)
+	updated.Settings = map[string]string{"region": "us-east-1"}
+	assert.NoError(t, p.Update(updated))
+
+	// updates based on the old revision conflict
+	stale := *prj
+	stale.Settings = map[string]string{"region": "us-west-2"}
+	_, ok := p.Update(&stale).(*persist.ConflictError)
+	assert.True(t, ok)
+
+	ch := make(chan *execute.Result, 1)
+	ch <- &execute.Result{ExitCode: 0}
+	done := make(chan bool)
+	go p.planComplete(prj, ch, done)
+	<-done
+
+	latest, err := p.Get(created.GUID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ProjectStatusOK, latest.Status)
+	assert.Equal(t, "us-east-1", latest.Settings["region"])
+	assert.Equal(t, uint64(3), latest.Revision)
+}
+
+func TestProjects_GetByName(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "tfwatch-checkout")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := NewProjectsController(dir, store, nil, nil, nil, nil, nil, time.Minute, false)
+	assert.NoError(t, p.Create(model.NewProject("network", "../fixtures/terraform_applied")))
+	assert.NoError(t, p.Create(model.NewProject("database", "../fixtures/terraform_applied")))
+
+	prj, err := p.GetByName("network")
+	assert.NoError(t, err)
+	assert.Equal(t, "network", prj.Name)
+
+	prj, err = p.GetByName("missing")
+	assert.NoError(t, err)
+	assert.Nil(t, prj)
+
+	// names are unique
+	_, ok := p.Create(model.NewProject("network", "../fixtures/terraform_applied")).(*persist.DuplicateError)
+	assert.True(t, ok)
+}
+
+func TestProjects_bootstrap_matches_directories(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "tfwatch-checkout")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"network", "database"} {
+		assert.NoError(t, os.Mkdir(path.Join(dir, name), 0700))
+		assert.NoError(t, ioutil.WriteFile(path.Join(dir, name, "main.tf"), nil, 0600))
+	}
+
+	// a renamed project keeps its directory
+	store.CreateNamespace(projectNS)
+	_, err = store.Create(projectNS, model.NewProject("renamed", path.Join(dir, "network")))
+	assert.NoError(t, err)
+
+	p := NewProjectsController(dir, store, nil, nil, nil, nil, nil, time.Minute, false).(*projects)
+	p.bootstrap(dir)
+
+	list, err := p.List()
+	assert.NoError(t, err)
+	names := []string{}
+	for _, prj := range list {
+		names = append(names, prj.Name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"database", "renamed"}, names)
+}
+
+func TestProjects_GetExecutions(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	p := &projects{store: store}
+	prj := createProject(t, store, "applied")
+	ns, err := p.executionNS(prj)
+	assert.NoError(t, err)
+
+	// five plans, one failed, then an apply, a minute apart
+	started := time.Date(2017, 5, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		r := &execute.Result{
+			Task:     execute.Task{Args: []string{"plan"}, Trigger: execute.TriggerSchedule},
+			ExitCode: 0,
+			Started:  started.Add(time.Duration(i) * time.Minute),
+		}
+		if i == 2 {
+			r.ExitCode = 1
+		}
+		if i == 5 {
+			r.Args, r.Trigger, r.Actor = []string{"apply"}, execute.TriggerAPI, "alice"
+		}
+		_, err := store.Create(ns, r)
+		assert.NoError(t, err)
+	}
+
+	// pages are newest first and continue from the cursor
+	page, err := p.GetExecutions(prj, ExecutionFilter{Limit: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(page.Executions))
+	assert.Equal(t, "apply", page.Executions[0].Args[0])
+	assert.NotEmpty(t, page.NextCursor)
+
+	page, err = p.GetExecutions(prj, ExecutionFilter{Limit: 4, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page.Executions))
+	assert.Equal(t, started, page.Executions[1].Started.UTC())
+	assert.Empty(t, page.NextCursor)
+
+	// filters
+	failed := 1
+	page, err = p.GetExecutions(prj, ExecutionFilter{ExitCode: &failed})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(page.Executions))
+
+	page, err = p.GetExecutions(prj, ExecutionFilter{Type: "plan", Trigger: execute.TriggerSchedule})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(page.Executions))
+
+	page, err = p.GetExecutions(prj, ExecutionFilter{Actor: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(page.Executions))
+	assert.Equal(t, "apply", page.Executions[0].Args[0])
+
+	page, err = p.GetExecutions(prj, ExecutionFilter{Since: started.Add(time.Minute), Until: started.Add(3 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page.Executions))
+
+	_, err = p.GetExecutions(prj, ExecutionFilter{Cursor: "%"})
+	assert.Error(t, err)
+}
+
+func TestProjects_Plan_rejects_secret_overrides(t *testing.T) {
+	p := &projects{}
+	_, err := p.Plan(&model.Project{Name: "network"}, map[string]string{"region": "secret:env:TFWATCH_MASTER_KEY"}, "")
+	assert.IsType(t, &ValidationError{}, err)
+}
+
+func TestProjects_Delete_removes_namespaces(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	outputs := NewOutputsController(store, fixtureState{})
+	p := &projects{store: store, outputs: outputs}
+	prj := createProject(t, store, "applied")
+	ns, err := p.executionNS(prj)
+	assert.NoError(t, err)
+	_, err = store.Create(ns, &execute.Result{GUID: "plan"})
+	assert.NoError(t, err)
+	assert.NoError(t, outputs.Snapshot(prj))
+
+	assert.NoError(t, p.Delete(prj.GUID))
+
+	// the namespace no longer exists, nor do the project's current outputs
+	_, err = store.List(ns)
+	assert.Error(t, err)
+	found, err := outputs.Find("")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func TestProjects_mergePatch(t *testing.T) {
+	decode := func(s string) interface{} {
+		var v interface{}
+		assert.NoError(t, json.Unmarshal([]byte(s), &v))
+		return v
+	}
+
+	// the examples of RFC 7396
+	for _, c := range []struct{ target, patch, result string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		assert.Equal(t, decode(c.result), mergePatch(decode(c.target), decode(c.patch)), c.patch)
+	}
+}
+
+func TestProjects_CreateFromInput(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	p := NewProjectsController("../fixtures", store, nil, nil, nil, nil, nil, time.Minute, false)
+
+	prj, err := p.CreateFromInput(&model.ProjectInput{Name: "applied", Path: "terraform_applied", Settings: map[string]string{"a": "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "../fixtures/terraform_applied", prj.LocalPath)
+	assert.Equal(t, model.ProjectStatusNew, prj.Status)
+
+	for _, in := range []*model.ProjectInput{
+		{Name: " applied"},
+		{Name: "missing"},
+		{Name: "outside", Path: "../model"},
+		{Name: "absolute", Path: "/tmp"},
+	} {
+		_, err := p.CreateFromInput(in)
+		assert.IsType(t, &ValidationError{}, err, in.Name)
+	}
+
+	// updates keep the directory and the fields managed by tfwatch
+	updated, err := p.UpdateFromInput(prj.GUID, &model.ProjectInput{Name: "renamed"})
+	assert.NoError(t, err)
+	assert.Equal(t, "../fixtures/terraform_applied", updated.LocalPath)
+	assert.Equal(t, model.ProjectStatusNew, updated.Status)
+	assert.Nil(t, updated.Settings)
+
+	_, err = p.UpdateFromInput(prj.GUID, &model.ProjectInput{Name: "moved", Path: "terraform_planned"})
+	assert.IsType(t, &ValidationError{}, err)
+
+	_, err = p.Patch(prj.GUID, 0, []byte(`{"name": `))
+	assert.IsType(t, &ValidationError{}, err)
+}
@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// orphanLogAge is how old a log file without an execution must be before it is removed. Logs of
+// tasks that are not recorded, and of deleted projects, have no execution, and a running task's
+// log is written before its execution is stored.
+const orphanLogAge = time.Hour
+
+// Retention is how long executions of a kind are kept. An execution is pruned once Count newer
+// executions of its kind exist, or once it started more than Age ago. Zero values keep executions.
+// Executions stored before their start was recorded are of unknown age, and are not pruned by Age.
+type Retention struct {
+	Count int
+	Age   time.Duration
+}
+
+// RetentionPolicy is the retention of each kind of execution. Failed executions are those that exit
+// with an error, plans exiting 2 have changes and have not failed. Applies and failures are usually
+// kept longer than plans, as they explain how infrastructure got to where it is.
+type RetentionPolicy struct {
+	Executions Retention
+	Failed     Retention
+	Applies    Retention
+}
+
+// retention returns the retention of the kind of the execution
+func (rp *RetentionPolicy) retention(r *execute.Result) (kind string, retention Retention) {
+	command := ""
+	if len(r.Args) > 0 {
+		command = r.Args[0]
+	}
+
+	switch {
+	case command == "apply":
+		return "apply", rp.Applies
+	case r.ExitCode != 0 && !(command == "plan" && r.ExitCode == 2):
+		return "failed", rp.Failed
+	default:
+		return "execution", rp.Executions
+	}
+}
+
+// PrunedExecution is an execution removed by the pruner
+type PrunedExecution struct {
+	ProjectGUID string    `json:"project_guid"`
+	GUID        string    `json:"guid"`
+	Command     string    `json:"command"`
+	ExitCode    int       `json:"exit_code"`
+	Started     time.Time `json:"started"`
+}
+
+// PruneReport lists what a prune removed, or would remove when it is a dry run
+type PruneReport struct {
+	DryRun     bool               `json:"dry_run"`
+	Executions []*PrunedExecution `json:"executions"`
+	LogFiles   []string           `json:"log_files"`
+}
+
+// Pruner removes executions and their log files once they are no longer retained
+type Pruner interface {
+	Prune(dryRun bool) (*PruneReport, error)
+}
+
+type pruner struct {
+	store    persist.Store
+	projects Projects
+	logDir   string
+	policy   RetentionPolicy
+	lock     sync.Mutex
+}
+
+// NewPrunerController creates a pruner of the executions logged to logDir. Executions are pruned
+// every interval in the background, unless interval is 0.
+func NewPrunerController(store persist.Store, projects Projects, logDir string, policy RetentionPolicy, interval time.Duration) Pruner {
+	p := &pruner{
+		store:    store,
+		projects: projects,
+		logDir:   logDir,
+		policy:   policy,
+	}
+
+	if interval > 0 {
+		go p.schedulePrune(interval)
+	}
+
+	return p
+}
+
+// schedulePrune prunes every interval
+func (p *pruner) schedulePrune(interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := p.Prune(false)
+		if err != nil {
+			log.Printf("[ERROR] Error pruning executions: %s", err)
+			continue
+		}
+		log.Printf("[INFO] Pruned %d executions and %d log files", len(report.Executions), len(report.LogFiles))
+	}
+}
+
+// Prune removes the executions that are no longer retained and their log files, along with log files
+// that belong to no execution. The store is compacted when anything was removed. A dry run reports
+// what would be removed without removing it.
+func (p *pruner) Prune(dryRun bool) (*PruneReport, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	report := &PruneReport{
+		DryRun:     dryRun,
+		Executions: []*PrunedExecution{},
+		LogFiles:   []string{},
+	}
+
+	projects, err := p.projects.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// logs of retained executions are kept
+	retained := make(map[string]bool)
+	for _, prj := range projects {
+		err := p.pruneProject(prj, dryRun, retained, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = p.pruneLogs(dryRun, retained, report)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := persist.Unwrap(p.store).(persist.Compactor); ok && !dryRun && len(report.Executions) > 0 {
+		if err := c.Compact(); err != nil {
+			log.Printf("[ERROR] Error compacting store: %s", err)
+		}
+	}
+
+	return report, nil
+}
+
+// pruneProject prunes the executions of a project, recording the task guids of retained executions
+func (p *pruner) pruneProject(prj *model.Project, dryRun bool, retained map[string]bool, report *PruneReport) error {
+	ns, err := executionNamespace(p.store, prj)
+	if err != nil {
+		return err
+	}
+
+	// executions are visited newest first, counting the executions of each kind
+	now := time.Now()
+	counts := make(map[string]int)
+	var pruned []string
+	err = p.store.Range(ns, executionStartedIndex.Name, persist.Query{Reverse: true}, func(key, guid string, decode func(interface{}) error) (bool, error) {
+		var r execute.Result
+		if err := decode(&r); err != nil {
+			return false, err
+		}
+
+		kind, retention := p.policy.retention(&r)
+		counts[kind]++
+		if (retention.Count == 0 || counts[kind] <= retention.Count) &&
+			(retention.Age == 0 || r.Started.IsZero() || now.Sub(r.Started) <= retention.Age) {
+			retained[r.GUID] = true
+			return true, nil
+		}
+
+		pruned = append(pruned, guid)
+		execution := &PrunedExecution{
+			ProjectGUID: prj.GUID,
+			GUID:        r.GUID,
+			ExitCode:    r.ExitCode,
+			Started:     r.Started,
+		}
+		if len(r.Args) > 0 {
+			execution.Command = r.Args[0]
+		}
+		report.Executions = append(report.Executions, execution)
+		return true, nil
+	})
+	if err != nil || dryRun {
+		return err
+	}
+
+	for _, guid := range pruned {
+		if err := p.store.Delete(ns, guid); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[DEBUG] Pruned %d executions of project '%s'", len(pruned), prj.Name)
+	return nil
+}
+
+// pruneLogs removes the log files of pruned executions, and log files of no execution once they are old
+func (p *pruner) pruneLogs(dryRun bool, retained map[string]bool, report *PruneReport) error {
+	files, err := ioutil.ReadDir(p.logDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pruned := make(map[string]bool, len(report.Executions))
+	for _, execution := range report.Executions {
+		pruned[execution.GUID] = true
+	}
+
+	cutoff := time.Now().Add(-orphanLogAge)
+	for _, f := range files {
+		if f.IsDir() || retained[f.Name()] || (!pruned[f.Name()] && f.ModTime().After(cutoff)) {
+			continue
+		}
+
+		report.LogFiles = append(report.LogFiles, f.Name())
+		if dryRun {
+			continue
+		}
+
+		err := os.Remove(path.Join(p.logDir, f.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
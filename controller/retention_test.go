@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/execute"
+)
+
+func TestPruner_Prune(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	logDir, err := ioutil.TempDir("", "tfwatch-logs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logDir)
+
+	p := &projects{store: store}
+	prj := createProject(t, store, "applied")
+	ns, err := p.executionNS(prj)
+	assert.NoError(t, err)
+
+	// an hour of plans, one failed, an apply and a plan with changes from last month
+	now := time.Now()
+	execution := func(guid string, started time.Time, command string, exitCode int) {
+		_, err := store.Create(ns, &execute.Result{
+			GUID:     guid,
+			Task:     execute.Task{Args: []string{command}},
+			ExitCode: exitCode,
+			Started:  started,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(path.Join(logDir, guid), []byte(guid), 0600))
+	}
+	for i, guid := range []string{"plan-1", "plan-2", "plan-3", "plan-4"} {
+		execution(guid, now.Add(-time.Duration(i)*time.Minute), "plan", 0)
+	}
+	execution("failed", now.Add(-time.Hour), "plan", 1)
+	execution("apply", now.Add(-40*24*time.Hour), "apply", 0)
+	execution("changes", now.Add(-40*24*time.Hour), "plan", 2)
+
+	// executions stored before their start was recorded are of unknown age
+	execution("unknown", time.Time{}, "apply", 0)
+
+	// a log of no execution, the log of a running task is kept
+	orphan := path.Join(logDir, "orphan")
+	assert.NoError(t, ioutil.WriteFile(orphan, nil, 0600))
+	assert.NoError(t, os.Chtimes(orphan, now.Add(-2*orphanLogAge), now.Add(-2*orphanLogAge)))
+	assert.NoError(t, ioutil.WriteFile(path.Join(logDir, "running"), nil, 0600))
+
+	policy := RetentionPolicy{
+		Executions: Retention{Count: 2, Age: 30 * 24 * time.Hour},
+		Failed:     Retention{Count: 2},
+		Applies:    Retention{Age: 365 * 24 * time.Hour},
+	}
+	pruner := NewPrunerController(store, NewProjectsController("", store, nil, nil, nil, nil, nil, time.Minute, false), logDir, policy, 0)
+
+	// a dry run removes nothing
+	report, err := pruner.Prune(true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(report.Executions))
+	assert.Equal(t, 4, len(report.LogFiles))
+	guids, err := store.List(ns)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, len(guids))
+
+	report, err = pruner.Prune(false)
+	assert.NoError(t, err)
+	pruned := []string{}
+	for _, e := range report.Executions {
+		pruned = append(pruned, e.GUID)
+	}
+	assert.Equal(t, []string{"plan-3", "plan-4", "changes"}, pruned)
+	assert.Equal(t, []string{"changes", "orphan", "plan-3", "plan-4"}, report.LogFiles)
+
+	guids, err = store.List(ns)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(guids))
+	files, err := ioutil.ReadDir(logDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(files))
+}
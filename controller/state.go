@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+// State reads the terraform state of projects
+type State interface {
+	Get(prj *model.Project) (*model.State, error)
+}
+
+type state struct {
+	executor    execute.Executor
+	credentials Credentials
+}
+
+// NewStateController creates a controller for inspecting project state, state is pulled with the
+// project's credential profiles
+func NewStateController(executor execute.Executor, credentials Credentials) State {
+	return &state{
+		executor:    executor,
+		credentials: credentials,
+	}
+}
+
+// Get reads the state from the project's terraform.tfstate when the local backend is in use,
+// otherwise the state is pulled from the configured backend
+func (s *state) Get(prj *model.Project) (*model.State, error) {
+	stateFile := path.Join(prj.LocalPath, model.StateFile)
+
+	f, err := os.Open(stateFile)
+	if os.IsNotExist(err) {
+		return s.pull(prj)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	log.Printf("[DEBUG] Reading state from file '%s'", stateFile)
+	return readState(prj, f)
+}
+
+// pull runs `terraform state pull` in the project
+func (s *state) pull(prj *model.Project) (*model.State, error) {
+	log.Printf("[DEBUG] Pulling state for project '%s'", prj.GUID)
+
+	task, err := terraformTask(s.credentials, prj, "state", "pull")
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := s.executor.Schedule(task)
+	if err != nil {
+		return nil, err
+	}
+
+	r := <-st.Channel
+	if r.ExitCode != 0 {
+		return nil, fmt.Errorf("Error pulling state for project '%s': %s", prj.Name, r.Output)
+	}
+
+	return readState(prj, bytes.NewReader(r.Output))
+}
+
+// readState reads a state, translating an empty state into a StateNotFoundError
+func readState(prj *model.Project, r io.Reader) (*model.State, error) {
+	st, err := model.ReadState(r)
+	if err == terraform.ErrNoState {
+		return nil, &model.StateNotFoundError{Project: prj.Name}
+	}
+	return st, err
+}
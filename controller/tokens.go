@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+const tokensNS = "tokens"
+
+// tokenPrefix begins every token secret, so tokens are recognized in configuration and logs
+const tokenPrefix = "tfw_"
+
+// tokenHashIndex finds tokens by the hash of their secret
+var tokenHashIndex = persist.Index{
+	Name:   "hash",
+	Unique: true,
+	Key: func(value interface{}) string {
+		if t, ok := value.(*storedToken); ok {
+			return t.Hash
+		}
+		return ""
+	},
+	New: func() interface{} { return &storedToken{} },
+}
+
+// Tokens stores the API tokens, and authenticates the bearers of token secrets
+type Tokens interface {
+	List() ([]*model.Token, error)
+	Create(t *model.Token) error
+	Revoke(guid string) error
+	Authenticate(secret string) (*model.Token, error)
+}
+
+// storedToken is the stored form of a token, the hash is the SHA-256 of its secret. Secrets are random,
+// so a fast hash is enough to keep them from being read from the store.
+type storedToken struct {
+	Name    string
+	Scopes  []model.TokenScope
+	Created time.Time
+	Expires time.Time
+	Hash    string
+}
+
+type tokens struct {
+	store persist.Store
+}
+
+// NewTokensController creates a controller for API tokens
+func NewTokensController(store persist.Store) Tokens {
+	store.CreateNamespace(tokensNS)
+	if err := store.CreateIndex(tokensNS, tokenHashIndex); err != nil {
+		log.Printf("[ERROR] Error indexing tokens: %s", err)
+	}
+
+	return &tokens{store}
+}
+
+// List returns the tokens ordered by name, without their secrets
+func (c *tokens) List() ([]*model.Token, error) {
+	guids, err := c.store.List(tokensNS)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*model.Token, len(guids))
+	for i, guid := range guids {
+		var stored storedToken
+		if err := c.store.Get(tokensNS, guid, &stored); err != nil {
+			return nil, err
+		}
+		list[i] = stored.token(guid)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Create stores a token with a new secret, the secret is set on the token and cannot be read again
+func (c *tokens) Create(t *model.Token) error {
+	if err := t.Validate(); err != nil {
+		return &ValidationError{err}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t.Created = time.Now().UTC()
+	guid, err := c.store.Create(tokensNS, &storedToken{t.Name, t.Scopes, t.Created, t.Expires, hashToken(secret)})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Created token '%s' with scopes %s", t.Name, t.Scopes)
+	t.GUID = guid
+	t.Secret = secret
+	return nil
+}
+
+// Revoke removes a token, its secret is no longer accepted
+func (c *tokens) Revoke(guid string) error {
+	if err := c.store.Delete(tokensNS, guid); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Revoked token '%s'", guid)
+	return nil
+}
+
+// Authenticate returns the token of a secret, an *UnauthorizedError when no token has the secret or
+// it has expired
+func (c *tokens) Authenticate(secret string) (*model.Token, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, Unauthorized("Invalid token")
+	}
+
+	var t *model.Token
+	err := c.store.Range(tokensNS, tokenHashIndex.Name, persist.Exact(hashToken(secret)), func(key, guid string, decode func(interface{}) error) (bool, error) {
+		var stored storedToken
+		if err := decode(&stored); err != nil {
+			return false, err
+		}
+		t = stored.token(guid)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if t == nil {
+		return nil, Unauthorized("Invalid token")
+	}
+	if t.Expired(time.Now()) {
+		return nil, Unauthorized("Token '%s' has expired", t.Name)
+	}
+	return t, nil
+}
+
+// token returns the token of the stored form
+func (s *storedToken) token(guid string) *model.Token {
+	return &model.Token{GUID: guid, Name: s.Name, Scopes: s.Scopes, Created: s.Created, Expires: s.Expires}
+}
+
+// hashToken returns the hex SHA-256 of a token secret
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+func TestTokens(t *testing.T) {
+	store := persist.NewMemoryStore()
+	tokens := NewTokensController(store)
+
+	deploy := &model.Token{Name: "deploy", Scopes: []model.TokenScope{model.ScopeApply}}
+	assert.NoError(t, tokens.Create(deploy))
+	assert.True(t, strings.HasPrefix(deploy.Secret, tokenPrefix))
+	assert.NotEmpty(t, deploy.GUID)
+
+	ci := &model.Token{Name: "ci", Scopes: []model.TokenScope{model.ScopeRead}, Expires: time.Now().Add(time.Hour)}
+	assert.NoError(t, tokens.Create(ci))
+
+	// secrets are not stored, nor listed
+	var stored storedToken
+	assert.NoError(t, store.Get(tokensNS, deploy.GUID, &stored))
+	assert.NotContains(t, stored.Hash, deploy.Secret)
+
+	list, err := tokens.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "ci", list[0].Name)
+	assert.Empty(t, list[0].Secret)
+
+	authenticated, err := tokens.Authenticate(deploy.Secret)
+	assert.NoError(t, err)
+	assert.Equal(t, deploy.GUID, authenticated.GUID)
+	assert.True(t, authenticated.Allows(model.ScopePlan))
+	assert.False(t, authenticated.Allows(model.ScopeAdmin))
+
+	_, err = tokens.Authenticate(deploy.Secret + "x")
+	assert.IsType(t, &UnauthorizedError{}, err)
+
+	// invalid tokens are not created
+	assert.IsType(t, &ValidationError{}, tokens.Create(&model.Token{Name: "none"}))
+	assert.IsType(t, &ValidationError{}, tokens.Create(&model.Token{Name: "unknown", Scopes: []model.TokenScope{"root"}}))
+
+	// expired and revoked tokens are refused
+	expired := &model.Token{Name: "expired", Scopes: []model.TokenScope{model.ScopeRead}, Expires: time.Now().Add(-time.Second)}
+	assert.NoError(t, tokens.Create(expired))
+	_, err = tokens.Authenticate(expired.Secret)
+	assert.IsType(t, &UnauthorizedError{}, err)
+
+	assert.NoError(t, tokens.Revoke(deploy.GUID))
+	_, err = tokens.Authenticate(deploy.Secret)
+	assert.IsType(t, &UnauthorizedError{}, err)
+	assert.IsType(t, &persist.NotFoundError{}, tokens.Revoke(deploy.GUID))
+}
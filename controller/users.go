@@ -0,0 +1,520 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const usersNS = "users"
+
+const sessionsNS = "sessions"
+
+// SessionTTL is how long a login session lasts
+const SessionTTL = 12 * time.Hour
+
+// loginAttempts is how many times a user's password can be wrong before logins are refused for a while
+const loginAttempts = 5
+
+// maxLoginBackoff is the longest logins of a user are refused for, the wait doubles with each failure
+const maxLoginBackoff = 15 * time.Minute
+
+// BootstrapUsername is the name of the admin created when tfwatch starts without users
+const BootstrapUsername = "admin"
+
+// usernameIndex finds users by their username
+var usernameIndex = persist.Index{
+	Name:   "username",
+	Unique: true,
+	Key: func(value interface{}) string {
+		if u, ok := value.(*storedUser); ok {
+			return u.Username
+		}
+		return ""
+	},
+	New: func() interface{} { return &storedUser{} },
+}
+
+// sessionHashIndex finds sessions by the hash of their id
+var sessionHashIndex = persist.Index{
+	Name:   "hash",
+	Unique: true,
+	Key: func(value interface{}) string {
+		if s, ok := value.(*storedSession); ok {
+			return s.Hash
+		}
+		return ""
+	},
+	New: func() interface{} { return &storedSession{} },
+}
+
+// Users stores the local user accounts and their login sessions
+type Users interface {
+	List() ([]*model.User, error)
+	Lookup(username string) (*model.User, error)
+	Create(u *model.User) error
+	Delete(guid string) error
+	SetPassword(guid, password, keepSession string) error
+	ResetPassword(username string) (string, error)
+	Bootstrap(password string) (string, error)
+	Authenticate(username, password string) (*model.User, error)
+	Login(username, password string) (*model.Session, error)
+	CreateSession(username string) (*model.Session, error)
+	Session(id string) (*model.Session, error)
+	Logout(id string) error
+}
+
+// storedUser is the stored form of a user, with the bcrypt hash of its password
+type storedUser struct {
+	Username string
+	Role     model.TokenScope
+	Created  time.Time
+	Hash     []byte
+}
+
+// storedSession is the stored form of a session, the hash is the SHA-256 of its id
+type storedSession struct {
+	Username  string
+	CSRFToken string
+	Expires   time.Time
+	Hash      string
+}
+
+type users struct {
+	store persist.Store
+	cost  int
+
+	// missing is compared with the passwords of unknown users, so logins take as long whether or not
+	// the user exists
+	missing []byte
+
+	// failures are the failed logins of each username since its last login, unknown usernames included
+	failures     map[string]*loginFailures
+	failuresLock sync.Mutex
+}
+
+// loginFailures counts the failed logins of a username, logins are refused until the time given
+type loginFailures struct {
+	count int
+	until time.Time
+}
+
+// NewUsersController creates a controller for user accounts
+func NewUsersController(store persist.Store) Users {
+	return newUsersController(store, bcrypt.DefaultCost)
+}
+
+// newUsersController creates a controller hashing passwords with a bcrypt cost
+func newUsersController(store persist.Store, cost int) *users {
+	store.CreateNamespace(usersNS)
+	if err := store.CreateIndex(usersNS, usernameIndex); err != nil {
+		log.Printf("[ERROR] Error indexing users: %s", err)
+	}
+	store.CreateNamespace(sessionsNS)
+	if err := store.CreateIndex(sessionsNS, sessionHashIndex); err != nil {
+		log.Printf("[ERROR] Error indexing sessions: %s", err)
+	}
+
+	missing, err := bcrypt.GenerateFromPassword([]byte("missing user"), cost)
+	if err != nil {
+		log.Printf("[ERROR] Error hashing password: %s", err)
+	}
+	return &users{store: store, cost: cost, missing: missing, failures: make(map[string]*loginFailures)}
+}
+
+// List returns the users ordered by username, without their passwords
+func (c *users) List() ([]*model.User, error) {
+	guids, err := c.store.List(usersNS)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*model.User, len(guids))
+	for i, guid := range guids {
+		var stored storedUser
+		if err := c.store.Get(usersNS, guid, &stored); err != nil {
+			return nil, err
+		}
+		list[i] = stored.user(guid)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list, nil
+}
+
+// Lookup returns the user with a username
+func (c *users) Lookup(username string) (*model.User, error) {
+	u, _, err := c.lookup(username)
+	return u, err
+}
+
+// Create stores a user with the bcrypt hash of its password, the password is cleared from the user
+func (c *users) Create(u *model.User) error {
+	if err := u.Validate(); err != nil {
+		return &ValidationError{err}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), c.cost)
+	if err != nil {
+		return err
+	}
+
+	u.Created = time.Now().UTC()
+	guid, err := c.store.Create(usersNS, &storedUser{u.Username, u.Role, u.Created, hash})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Created user '%s' with role %s", u.Username, u.Role)
+	u.GUID = guid
+	u.Password = ""
+	return nil
+}
+
+// Delete removes a user and ends its sessions. The last admin cannot be deleted, nobody could manage
+// users or tokens without one.
+func (c *users) Delete(guid string) error {
+	var stored storedUser
+	if err := c.store.Get(usersNS, guid, &stored); err != nil {
+		return err
+	}
+
+	if stored.Role == model.ScopeAdmin {
+		admins, err := c.countAdmins()
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return Conflict("User '%s' is the last admin, create another admin before deleting it", stored.Username)
+		}
+	}
+
+	if err := c.store.Delete(usersNS, guid); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Deleted user '%s'", stored.Username)
+	return c.endSessions(stored.Username, "")
+}
+
+// SetPassword changes the password of a user and ends the user's sessions, except the session with
+// the id keepSession, so a user changing their own password stays logged in
+func (c *users) SetPassword(guid, password, keepSession string) error {
+	if err := model.ValidPassword(password); err != nil {
+		return &ValidationError{err}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), c.cost)
+	if err != nil {
+		return err
+	}
+
+	var stored storedUser
+	rev, err := c.store.GetRevision(usersNS, guid, &stored)
+	if err != nil {
+		return err
+	}
+	stored.Hash = hash
+	if _, err := c.store.CompareAndUpdate(usersNS, guid, rev, &stored); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Changed the password of user '%s'", stored.Username)
+	return c.endSessions(stored.Username, keepSession)
+}
+
+// ResetPassword gives a user a random password, which is returned, ending all of the user's sessions
+func (c *users) ResetPassword(username string) (string, error) {
+	u, err := c.Lookup(username)
+	if err != nil {
+		return "", err
+	}
+
+	password, err := randomString(18)
+	if err != nil {
+		return "", err
+	}
+	return password, c.SetPassword(u.GUID, password, "")
+}
+
+// Bootstrap creates an admin when there are no users, with the password given or a random password,
+// which is returned. Nothing is created, and the password returned is empty, when users exist.
+func (c *users) Bootstrap(password string) (string, error) {
+	guids, err := c.store.List(usersNS)
+	if err != nil || len(guids) > 0 {
+		return "", err
+	}
+
+	if password == "" {
+		if password, err = randomString(18); err != nil {
+			return "", err
+		}
+	}
+
+	admin := &model.User{Username: BootstrapUsername, Role: model.ScopeAdmin, Password: password}
+	if err := c.Create(admin); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// Authenticate checks a user's password, an *UnauthorizedError is returned when the user does not
+// exist or the password is wrong
+func (c *users) Authenticate(username, password string) (*model.User, error) {
+	if wait := c.backoff(username); wait > 0 {
+		log.Printf("[WARN] Refused login of user '%s' after too many failed logins", username)
+		return nil, Unauthorized("Too many failed logins, try again in %s", wait)
+	}
+
+	u, hash, err := c.lookup(username)
+	if _, ok := err.(*NotFoundError); ok {
+		bcrypt.CompareHashAndPassword(c.missing, []byte(password))
+		log.Printf("[WARN] Failed login of unknown user '%s'", username)
+		c.failed(username)
+		return nil, Unauthorized("Invalid username or password")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		log.Printf("[WARN] Failed login of user '%s'", username)
+		c.failed(username)
+		return nil, Unauthorized("Invalid username or password")
+	}
+
+	c.failuresLock.Lock()
+	delete(c.failures, username)
+	c.failuresLock.Unlock()
+	return u, nil
+}
+
+// backoff returns how long logins of a username are refused for, 0 when they are allowed
+func (c *users) backoff(username string) time.Duration {
+	c.failuresLock.Lock()
+	defer c.failuresLock.Unlock()
+
+	if f, ok := c.failures[username]; ok {
+		if wait := time.Until(f.until); wait > 0 {
+			return wait.Round(time.Second)
+		}
+	}
+	return 0
+}
+
+// failed records a failed login of a username. Once its password has been wrong loginAttempts times,
+// logins are refused for a second, doubling with each further failure up to maxLoginBackoff.
+func (c *users) failed(username string) {
+	c.failuresLock.Lock()
+	defer c.failuresLock.Unlock()
+
+	now := time.Now()
+	f, ok := c.failures[username]
+	if !ok {
+		// failures are forgotten once logins have been allowed again for maxLoginBackoff
+		for name, other := range c.failures {
+			if now.Sub(other.until) > maxLoginBackoff {
+				delete(c.failures, name)
+			}
+		}
+		f = &loginFailures{}
+		c.failures[username] = f
+	}
+
+	f.count++
+	if f.count >= loginAttempts {
+		wait := maxLoginBackoff
+		if shift := uint(f.count - loginAttempts); shift < 10 {
+			if w := time.Second << shift; w < wait {
+				wait = w
+			}
+		}
+		f.until = now.Add(wait)
+	} else {
+		f.until = now
+	}
+}
+
+// Login checks a user's password and starts a session
+func (c *users) Login(username, password string) (*model.Session, error) {
+	u, err := c.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] User '%s' logged in", u.Username)
+	return c.CreateSession(u.Username)
+}
+
+// CreateSession starts a session of a user, expired sessions are removed
+func (c *users) CreateSession(username string) (*model.Session, error) {
+	id, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	c.removeExpiredSessions()
+
+	s := &model.Session{ID: id, Username: username, CSRFToken: csrf, Expires: time.Now().Add(SessionTTL).UTC()}
+	if _, err := c.store.Create(sessionsNS, &storedSession{s.Username, s.CSRFToken, s.Expires, hashToken(id)}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Session returns the session with an id, an *UnauthorizedError when no session has the id or it
+// has expired
+func (c *users) Session(id string) (*model.Session, error) {
+	guid, stored, err := c.session(id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, Unauthorized("Invalid session, log in again")
+	}
+
+	s := &model.Session{ID: id, Username: stored.Username, CSRFToken: stored.CSRFToken, Expires: stored.Expires}
+	if s.Expired(time.Now()) {
+		c.store.Delete(sessionsNS, guid)
+		return nil, Unauthorized("Session has expired, log in again")
+	}
+	return s, nil
+}
+
+// Logout ends a session, ending a session that does not exist is not an error
+func (c *users) Logout(id string) error {
+	guid, stored, err := c.session(id)
+	if err != nil || stored == nil {
+		return err
+	}
+
+	log.Printf("[INFO] User '%s' logged out", stored.Username)
+	return c.store.Delete(sessionsNS, guid)
+}
+
+// lookup returns the user with a username and the hash of its password, a *NotFoundError when no
+// user has the username
+func (c *users) lookup(username string) (*model.User, []byte, error) {
+	var u *model.User
+	var hash []byte
+	err := c.store.Range(usersNS, usernameIndex.Name, persist.Exact(username), func(key, guid string, decode func(interface{}) error) (bool, error) {
+		var stored storedUser
+		if err := decode(&stored); err != nil {
+			return false, err
+		}
+		u, hash = stored.user(guid), stored.Hash
+		return false, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if u == nil {
+		return nil, nil, NotFound("User '%s' does not exist", username)
+	}
+	return u, hash, nil
+}
+
+// session returns the guid and stored form of the session with an id, nil when there is none
+func (c *users) session(id string) (string, *storedSession, error) {
+	var guid string
+	var s *storedSession
+	err := c.store.Range(sessionsNS, sessionHashIndex.Name, persist.Exact(hashToken(id)), func(key, g string, decode func(interface{}) error) (bool, error) {
+		var stored storedSession
+		if err := decode(&stored); err != nil {
+			return false, err
+		}
+		guid, s = g, &stored
+		return false, nil
+	})
+	return guid, s, err
+}
+
+// countAdmins returns the number of users with the admin role
+func (c *users) countAdmins() (int, error) {
+	list, err := c.List()
+	if err != nil {
+		return 0, err
+	}
+
+	admins := 0
+	for _, u := range list {
+		if u.Role == model.ScopeAdmin {
+			admins++
+		}
+	}
+	return admins, nil
+}
+
+// endSessions removes the sessions of a user, except the session with the id keep
+func (c *users) endSessions(username, keep string) error {
+	guids, err := c.store.List(sessionsNS)
+	if err != nil {
+		return err
+	}
+
+	ended := 0
+	for _, guid := range guids {
+		var stored storedSession
+		if err := c.store.Get(sessionsNS, guid, &stored); err != nil {
+			return err
+		}
+		if stored.Username != username || (keep != "" && stored.Hash == hashToken(keep)) {
+			continue
+		}
+		if err := c.store.Delete(sessionsNS, guid); err != nil {
+			return err
+		}
+		ended++
+	}
+
+	log.Printf("[INFO] Ended %d sessions of user '%s'", ended, username)
+	return nil
+}
+
+// removeExpiredSessions removes the sessions that have expired
+func (c *users) removeExpiredSessions() {
+	guids, err := c.store.List(sessionsNS)
+	if err != nil {
+		log.Printf("[ERROR] Error listing sessions: %s", err)
+		return
+	}
+
+	now := time.Now()
+	for _, guid := range guids {
+		var stored storedSession
+		if err := c.store.Get(sessionsNS, guid, &stored); err != nil {
+			continue
+		}
+		if !now.Before(stored.Expires) {
+			if err := c.store.Delete(sessionsNS, guid); err != nil {
+				log.Printf("[ERROR] Error removing expired session: %s", err)
+			}
+		}
+	}
+}
+
+// user returns the user of the stored form
+func (s *storedUser) user(guid string) *model.User {
+	return &model.User{GUID: guid, Username: s.Username, Role: s.Role, Created: s.Created}
+}
+
+// randomString returns n random bytes, base64 URL encoded
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
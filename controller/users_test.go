@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUsers(t *testing.T) {
+	store := persist.NewMemoryStore()
+	users := newUsersController(store, bcrypt.MinCost)
+
+	// the admin is created when there are no users
+	password, err := users.Bootstrap("")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, password)
+	again, err := users.Bootstrap("")
+	assert.NoError(t, err)
+	assert.Empty(t, again)
+
+	alice := &model.User{Username: "alice", Role: model.ScopePlan, Password: "correct horse"}
+	assert.NoError(t, users.Create(alice))
+	assert.Empty(t, alice.Password)
+	assert.IsType(t, &persist.DuplicateError{}, users.Create(&model.User{Username: "alice", Role: model.ScopeRead, Password: "battery staple"}))
+	assert.IsType(t, &ValidationError{}, users.Create(&model.User{Username: "bob", Role: model.ScopeRead, Password: "short"}))
+
+	list, err := users.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, BootstrapUsername, list[0].Username)
+	assert.Equal(t, model.ScopeAdmin, list[0].Role)
+
+	// logins need the password
+	_, err = users.Login("admin", password)
+	assert.NoError(t, err)
+	_, err = users.Login("alice", "battery staple")
+	assert.IsType(t, &UnauthorizedError{}, err)
+	_, err = users.Login("carol", "correct horse")
+	assert.IsType(t, &UnauthorizedError{}, err)
+
+	session, err := users.Login("alice", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", session.Username)
+	assert.NotEmpty(t, session.CSRFToken)
+
+	found, err := users.Session(session.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, session.CSRFToken, found.CSRFToken)
+	_, err = users.Session("unknown")
+	assert.IsType(t, &UnauthorizedError{}, err)
+
+	// changing the password ends the other sessions of the user
+	other, err := users.Login("alice", "correct horse")
+	assert.NoError(t, err)
+	admin, err := users.Login("admin", password)
+	assert.NoError(t, err)
+	assert.NoError(t, users.SetPassword(alice.GUID, "battery staple", session.ID))
+	_, err = users.Session(other.ID)
+	assert.IsType(t, &UnauthorizedError{}, err)
+	_, err = users.Session(session.ID)
+	assert.NoError(t, err)
+	_, err = users.Session(admin.ID)
+	assert.NoError(t, err)
+	_, err = users.Login("alice", "correct horse")
+	assert.IsType(t, &UnauthorizedError{}, err)
+	_, err = users.Login("alice", "battery staple")
+	assert.NoError(t, err)
+	assert.IsType(t, &ValidationError{}, users.SetPassword(alice.GUID, "short", ""))
+
+	// resetting the password ends every session of the user
+	reset, err := users.ResetPassword("alice")
+	assert.NoError(t, err)
+	_, err = users.Authenticate("alice", reset)
+	assert.NoError(t, err)
+	_, err = users.Session(session.ID)
+	assert.IsType(t, &UnauthorizedError{}, err)
+	_, err = users.ResetPassword("carol")
+	assert.IsType(t, &NotFoundError{}, err)
+
+	session, err = users.Login("alice", reset)
+	assert.NoError(t, err)
+	assert.NoError(t, users.Logout(session.ID))
+	_, err = users.Session(session.ID)
+	assert.IsType(t, &UnauthorizedError{}, err)
+	assert.NoError(t, users.Logout(session.ID))
+
+	// expired sessions are refused
+	expired := &storedSession{"alice", "csrf", time.Now().Add(-time.Second), hashToken("expired")}
+	_, err = store.Create(sessionsNS, expired)
+	assert.NoError(t, err)
+	_, err = users.Session("expired")
+	assert.IsType(t, &UnauthorizedError{}, err)
+
+	assert.NoError(t, users.Delete(alice.GUID))
+	_, err = users.Lookup("alice")
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+func TestUsers_login_backoff(t *testing.T) {
+	users := newUsersController(persist.NewMemoryStore(), bcrypt.MinCost)
+	assert.NoError(t, users.Create(&model.User{Username: "alice", Role: model.ScopeRead, Password: "correct horse"}))
+
+	// logins are refused for a while once the password has been wrong too often
+	for i := 0; i < loginAttempts; i++ {
+		_, err := users.Login("alice", "battery staple")
+		assert.IsType(t, &UnauthorizedError{}, err)
+	}
+	_, err := users.Login("alice", "correct horse")
+	if assert.IsType(t, &UnauthorizedError{}, err) {
+		assert.Contains(t, err.Error(), "Too many failed logins")
+	}
+
+	// unknown users are refused the same way
+	for i := 0; i < loginAttempts; i++ {
+		users.Login("carol", "correct horse")
+	}
+	assert.True(t, users.backoff("carol") > 0)
+
+	// once the wait is over the right password logs in, and the failures are forgotten
+	users.failures["alice"].until = time.Now().Add(-time.Second)
+	_, err = users.Login("alice", "correct horse")
+	assert.NoError(t, err)
+	assert.NotContains(t, users.failures, "alice")
+}
+
+func TestUsers_Delete_last_admin(t *testing.T) {
+	users := newUsersController(persist.NewMemoryStore(), bcrypt.MinCost)
+	_, err := users.Bootstrap("correct horse")
+	assert.NoError(t, err)
+	admin, err := users.Lookup(BootstrapUsername)
+	assert.NoError(t, err)
+
+	assert.IsType(t, &ConflictError{}, users.Delete(admin.GUID))
+
+	// an admin can be deleted while another admin remains, its sessions end
+	other := &model.User{Username: "root", Role: model.ScopeAdmin, Password: "battery staple"}
+	assert.NoError(t, users.Create(other))
+	session, err := users.Login("root", "battery staple")
+	assert.NoError(t, err)
+	assert.NoError(t, users.Delete(other.GUID))
+	_, err = users.Session(session.ID)
+	assert.IsType(t, &UnauthorizedError{}, err)
+	assert.IsType(t, &ConflictError{}, users.Delete(admin.GUID))
+}
@@ -0,0 +1,440 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+	"github.com/webdevwilson/tfwatch/secure"
+)
+
+const variableSetNS = "variable-sets"
+
+// Variables stores the values of terraform variables, encrypted at rest. Variables are stored globally,
+// in variable sets shared by many projects, or in a project.
+type Variables interface {
+	List(scope model.VariableScope) ([]*model.Variable, error)
+	Get(scope model.VariableScope, guid string) (*model.Variable, error)
+	Create(v *model.Variable) error
+	Update(v *model.Variable) error
+	Delete(scope model.VariableScope, guid string) error
+	ListSets() ([]*model.VariableSet, error)
+	GetSet(guid string) (*model.VariableSet, error)
+	CreateSet(set *model.VariableSet) error
+	UpdateSet(set *model.VariableSet) error
+	DeleteSet(guid string) error
+	Resolve(prj *model.Project, overrides map[string]string) ([]*model.ResolvedVariable, error)
+	Inject(prj *model.Project, overrides map[string]string, t *execute.Task) error
+}
+
+// storedVariable is the encrypted form of a variable
+type storedVariable struct {
+	Name      string
+	Value     []byte
+	Sensitive bool
+	HCL       bool
+}
+
+type variables struct {
+	store  persist.Store
+	cipher *secure.Cipher
+}
+
+// NewVariablesController creates a controller for variables encrypted with the cipher
+func NewVariablesController(store persist.Store, cipher *secure.Cipher) Variables {
+	store.CreateNamespace(model.VariableScope{}.Namespace())
+	store.CreateNamespace(variableSetNS)
+
+	return &variables{
+		store:  store,
+		cipher: cipher,
+	}
+}
+
+// List returns the variables in a scope. Sensitive values are masked.
+func (v *variables) List(scope model.VariableScope) ([]*model.Variable, error) {
+	vars, err := v.list(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, variable := range vars {
+		vars[i] = variable.Masked()
+	}
+	return vars, nil
+}
+
+// Get returns a variable, a sensitive value is masked
+func (v *variables) Get(scope model.VariableScope, guid string) (*model.Variable, error) {
+	variable, err := v.get(scope, guid)
+	if err != nil {
+		return nil, err
+	}
+	return variable.Masked(), nil
+}
+
+// Create stores a new variable, names are unique within a scope
+func (v *variables) Create(variable *model.Variable) error {
+	if err := variable.Validate(); err != nil {
+		return &ValidationError{err}
+	}
+
+	if err := v.unique(variable); err != nil {
+		return err
+	}
+
+	stored, err := v.encrypt(variable)
+	if err != nil {
+		return err
+	}
+
+	guid, err := v.store.Create(v.namespace(variable.Scope()), stored)
+	if err != nil {
+		return err
+	}
+
+	variable.GUID = guid
+	*variable = *variable.Masked()
+	return nil
+}
+
+// Update replaces a stored variable. A sensitive variable updated without a value keeps its
+// stored value, since the value is never returned to clients. A new value is required to make a
+// sensitive variable not sensitive, so the stored value is never returned unmasked.
+func (v *variables) Update(variable *model.Variable) error {
+	existing, err := v.get(variable.Scope(), variable.GUID)
+	if err != nil {
+		return err
+	}
+
+	if existing.Name != variable.Name {
+		if err := v.unique(variable); err != nil {
+			return err
+		}
+	}
+
+	if variable.Value == "" && existing.Sensitive {
+		if !variable.Sensitive {
+			return Invalid("Variable '%s' is sensitive, a new value is required to make it not sensitive", variable.Name)
+		}
+		variable.Value = existing.Value
+	}
+
+	if err := variable.Validate(); err != nil {
+		return &ValidationError{err}
+	}
+
+	stored, err := v.encrypt(variable)
+	if err != nil {
+		return err
+	}
+
+	err = v.store.Update(v.namespace(variable.Scope()), variable.GUID, stored)
+	if err != nil {
+		return err
+	}
+
+	*variable = *variable.Masked()
+	return nil
+}
+
+// Delete removes a variable
+func (v *variables) Delete(scope model.VariableScope, guid string) error {
+	return v.store.Delete(v.namespace(scope), guid)
+}
+
+// ListSets returns the variable sets, ordered by name
+func (v *variables) ListSets() ([]*model.VariableSet, error) {
+	guids, err := v.store.List(variableSetNS)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]*model.VariableSet, len(guids))
+	for i, guid := range guids {
+		sets[i], err = v.GetSet(guid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Name < sets[j].Name
+	})
+
+	return sets, nil
+}
+
+// GetSet returns a variable set
+func (v *variables) GetSet(guid string) (*model.VariableSet, error) {
+	var set model.VariableSet
+	err := v.store.Get(variableSetNS, guid, &set)
+	if err != nil {
+		return nil, err
+	}
+	set.GUID = guid
+	return &set, nil
+}
+
+// CreateSet stores a new variable set, names are unique
+func (v *variables) CreateSet(set *model.VariableSet) error {
+	if err := v.uniqueSet(set); err != nil {
+		return err
+	}
+
+	guid, err := v.store.Create(variableSetNS, set)
+	if err != nil {
+		return err
+	}
+
+	set.GUID = guid
+	return nil
+}
+
+// UpdateSet renames or describes a variable set
+func (v *variables) UpdateSet(set *model.VariableSet) error {
+	if _, err := v.GetSet(set.GUID); err != nil {
+		return err
+	}
+
+	if err := v.uniqueSet(set); err != nil {
+		return err
+	}
+
+	return v.store.Update(variableSetNS, set.GUID, set)
+}
+
+// DeleteSet removes a variable set and its variables. Sets attached to a project cannot be deleted.
+func (v *variables) DeleteSet(guid string) error {
+	set, err := v.GetSet(guid)
+	if err != nil {
+		return err
+	}
+
+	guids, err := v.store.List(projectNS)
+	if err != nil {
+		return err
+	}
+	for _, prjGUID := range guids {
+		var prj model.Project
+		if err := v.store.Get(projectNS, prjGUID, &prj); err != nil {
+			return err
+		}
+		for _, attached := range prj.VariableSets {
+			if attached == guid {
+				return Conflict("Variable set '%s' is attached to project '%s'", set.Name, prj.Name)
+			}
+		}
+	}
+
+	scope := model.VariableScope{SetGUID: guid}
+	vars, err := v.store.List(v.namespace(scope))
+	if err != nil {
+		return err
+	}
+	for _, varGUID := range vars {
+		if err := v.store.Delete(v.namespace(scope), varGUID); err != nil {
+			return err
+		}
+	}
+
+	return v.store.Delete(variableSetNS, guid)
+}
+
+// Resolve returns the decrypted variables passed to terraform in a project, ordered by name. Values
+// are layered, from lowest to highest precedence: global variables, the project's variable sets in
+// the order they are attached, project settings that are declared variables, project variables and
+// the overrides given for a single run.
+func (v *variables) Resolve(prj *model.Project, overrides map[string]string) ([]*model.ResolvedVariable, error) {
+	global, err := v.list(model.VariableScope{})
+	if err != nil {
+		return nil, err
+	}
+	layers := []*model.VariableLayer{{Source: model.VariableSourceGlobal, Variables: global}}
+
+	for _, guid := range prj.VariableSets {
+		set, err := v.GetSet(guid)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading variable set '%s' of project '%s': %s", guid, prj.Name, err)
+		}
+		vars, err := v.list(model.VariableScope{SetGUID: guid})
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, &model.VariableLayer{
+			Source:    model.VariableSourceSet,
+			SetName:   set.Name,
+			Variables: vars,
+		})
+	}
+
+	// settings are free-form, only those naming a declared variable are passed to terraform
+	if len(prj.Settings) > 0 {
+		cfg, err := prj.Config()
+		if err != nil {
+			log.Printf("[WARN] Not passing settings of project '%s' as variables: %s", prj.Name, err)
+		} else {
+			layers = append(layers, model.SettingsLayer(model.VariableSourceSettings, cfg.DeclaredSettings(prj.Settings)))
+		}
+	}
+
+	project, err := v.list(model.VariableScope{ProjectGUID: prj.GUID})
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers,
+		&model.VariableLayer{Source: model.VariableSourceProject, Variables: project},
+		model.SettingsLayer(model.VariableSourceOverride, overrides))
+
+	return model.ResolveVariables(layers...), nil
+}
+
+// Inject passes the project's variables to a task. Values are set as TF_VAR_ environment variables,
+// except HCL values, which are written to a temporary tfvars file that only exists while the task runs.
+func (v *variables) Inject(prj *model.Project, overrides map[string]string, t *execute.Task) error {
+	resolved, err := v.Resolve(prj, overrides)
+	if err != nil {
+		return err
+	}
+
+	if t.Environment == nil {
+		t.Environment = make(map[string]string)
+	}
+
+	var tfvars []string
+	for _, variable := range resolved {
+		if variable.HCL {
+			tfvars = append(tfvars, fmt.Sprintf("%s = %s", variable.Name, variable.Value))
+			continue
+		}
+		t.Environment[model.VariableEnvironmentName(variable.Name)] = variable.Value
+	}
+
+	if len(tfvars) == 0 {
+		return nil
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(os.TempDir(), fmt.Sprintf("tfwatch-%s.tfvars", id))
+	if t.Files == nil {
+		t.Files = make(map[string][]byte)
+	}
+	t.Files[file] = []byte(strings.Join(tfvars, "\n") + "\n")
+	t.Args = append(t.Args, "-var-file="+file)
+
+	log.Printf("[DEBUG] Passing %d HCL variables to project '%s' in '%s'", len(tfvars), prj.Name, file)
+	return nil
+}
+
+// unique checks that no other variable in the scope of the variable has its name
+func (v *variables) unique(variable *model.Variable) error {
+	existing, err := v.list(variable.Scope())
+	if err != nil {
+		return err
+	}
+	for _, e := range existing {
+		if e.Name == variable.Name && e.GUID != variable.GUID {
+			return Conflict("Variable '%s' already exists", variable.Name)
+		}
+	}
+	return nil
+}
+
+// uniqueSet checks that no other variable set has the name of the set
+func (v *variables) uniqueSet(set *model.VariableSet) error {
+	if err := set.Validate(); err != nil {
+		return &ValidationError{err}
+	}
+
+	existing, err := v.ListSets()
+	if err != nil {
+		return err
+	}
+	for _, e := range existing {
+		if e.Name == set.Name && e.GUID != set.GUID {
+			return Conflict("Variable set '%s' already exists", set.Name)
+		}
+	}
+	return nil
+}
+
+// namespace returns the namespace variables in the scope are stored in, ensuring it exists
+func (v *variables) namespace(scope model.VariableScope) string {
+	ns := scope.Namespace()
+	if err := v.store.CreateNamespace(ns); err != nil {
+		log.Printf("[ERROR] Error creating namespace '%s': %s", ns, err)
+	}
+	return ns
+}
+
+// list returns the decrypted variables in a scope
+func (v *variables) list(scope model.VariableScope) ([]*model.Variable, error) {
+	guids, err := v.store.List(v.namespace(scope))
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make([]*model.Variable, len(guids))
+	for i, guid := range guids {
+		vars[i], err = v.get(scope, guid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+
+	return vars, nil
+}
+
+// get returns a decrypted variable
+func (v *variables) get(scope model.VariableScope, guid string) (*model.Variable, error) {
+	var stored storedVariable
+	err := v.store.Get(v.namespace(scope), guid, &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := v.cipher.Decrypt(stored.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting variable '%s', has the master key changed? %s", stored.Name, err)
+	}
+
+	return &model.Variable{
+		GUID:        guid,
+		ProjectGUID: scope.ProjectGUID,
+		SetGUID:     scope.SetGUID,
+		Name:        stored.Name,
+		Value:       string(value),
+		Sensitive:   stored.Sensitive,
+		HCL:         stored.HCL,
+	}, nil
+}
+
+// encrypt creates the stored form of a variable
+func (v *variables) encrypt(variable *model.Variable) (*storedVariable, error) {
+	value, err := v.cipher.Encrypt([]byte(variable.Value))
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedVariable{
+		Name:      variable.Name,
+		Value:     value,
+		Sensitive: variable.Sensitive,
+		HCL:       variable.HCL,
+	}, nil
+}
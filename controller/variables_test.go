@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/execute"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+	"github.com/webdevwilson/tfwatch/secure"
+)
+
+func createVariables(t *testing.T, store persist.Store) Variables {
+	cipher, err := secure.NewCipher(bytes.Repeat([]byte{7}, secure.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewVariablesController(store, cipher)
+}
+
+func TestVariables_sensitive_values_masked_and_encrypted(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	vars := createVariables(t, store)
+	v := &model.Variable{Name: "db_password", Value: "hunter2", Sensitive: true}
+	assert.NoError(t, vars.Create(v))
+	assert.Equal(t, "", v.Value)
+
+	got, err := vars.Get(model.VariableScope{}, v.GUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "", got.Value)
+
+	// the value is not stored in the clear
+	var stored storedVariable
+	assert.NoError(t, store.Get(model.VariableScope{}.Namespace(), v.GUID, &stored))
+	assert.False(t, bytes.Contains(stored.Value, []byte("hunter2")))
+
+	// updating without a value keeps the stored value
+	assert.NoError(t, vars.Update(&model.Variable{GUID: v.GUID, Name: "db_password", Sensitive: true}))
+	resolved, err := vars.Resolve(&model.Project{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", resolved[0].Value)
+
+	// removing the flag without a new value does not reveal the stored value
+	err = vars.Update(&model.Variable{GUID: v.GUID, Name: "db_password"})
+	assert.IsType(t, &ValidationError{}, err)
+	got, err = vars.Get(model.VariableScope{}, v.GUID)
+	assert.NoError(t, err)
+	assert.True(t, got.Sensitive)
+	assert.Equal(t, "", got.Value)
+
+	// with a new value, the variable is no longer sensitive
+	assert.NoError(t, vars.Update(&model.Variable{GUID: v.GUID, Name: "db_password", Value: "public"}))
+	got, err = vars.Get(model.VariableScope{}, v.GUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "public", got.Value)
+}
+
+func TestVariables_unique_names(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	vars := createVariables(t, store)
+	assert.NoError(t, vars.Create(&model.Variable{Name: "region", Value: "us-east-1"}))
+	assert.Error(t, vars.Create(&model.Variable{Name: "region", Value: "us-west-2"}))
+	assert.Error(t, vars.Create(&model.Variable{Name: "not valid"}))
+}
+
+func TestVariables_inject(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	vars := createVariables(t, store)
+	prj := createProject(t, store, "applied")
+
+	assert.NoError(t, vars.Create(&model.Variable{Name: "region", Value: "us-east-1"}))
+	assert.NoError(t, vars.Create(&model.Variable{Name: "env", Value: "prod"}))
+	assert.NoError(t, vars.Create(&model.Variable{ProjectGUID: prj.GUID, Name: "env", Value: "staging"}))
+	assert.NoError(t, vars.Create(&model.Variable{ProjectGUID: prj.GUID, Name: "zones", Value: `["a", "b"]`, HCL: true}))
+
+	task := &execute.Task{Command: "terraform", Args: []string{"plan"}}
+	assert.NoError(t, vars.Inject(prj, nil, task))
+
+	assert.Equal(t, "us-east-1", task.Environment["TF_VAR_region"])
+	assert.Equal(t, "staging", task.Environment["TF_VAR_env"])
+	assert.NotContains(t, task.Environment, "TF_VAR_zones")
+
+	assert.Equal(t, 2, len(task.Args))
+	assert.True(t, strings.HasPrefix(task.Args[1], "-var-file="))
+	file := strings.TrimPrefix(task.Args[1], "-var-file=")
+	assert.Equal(t, "zones = [\"a\", \"b\"]\n", string(task.Files[file]))
+
+	// the file is only written by the executor
+	_, err := ioutil.ReadFile(file)
+	assert.Error(t, err)
+}
+
+func TestVariables_resolve_layers(t *testing.T) {
+	store, cleanup := createStore(t)
+	defer cleanup()
+
+	vars := createVariables(t, store)
+	prj := createProject(t, store, "applied")
+
+	tags := &model.VariableSet{Name: "tags"}
+	assert.NoError(t, vars.CreateSet(tags))
+	account := &model.VariableSet{Name: "account"}
+	assert.NoError(t, vars.CreateSet(account))
+	assert.Error(t, vars.CreateSet(&model.VariableSet{Name: "tags"}))
+
+	assert.NoError(t, vars.Create(&model.Variable{Name: "region", Value: "us-east-1"}))
+	assert.NoError(t, vars.Create(&model.Variable{Name: "owner", Value: "global"}))
+	assert.NoError(t, vars.Create(&model.Variable{SetGUID: tags.GUID, Name: "owner", Value: "tags"}))
+	assert.NoError(t, vars.Create(&model.Variable{SetGUID: account.GUID, Name: "owner", Value: "account"}))
+	assert.NoError(t, vars.Create(&model.Variable{SetGUID: account.GUID, Name: "account_id", Value: "123"}))
+	assert.NoError(t, vars.Create(&model.Variable{ProjectGUID: prj.GUID, Name: "account_id", Value: "456"}))
+
+	// later sets take precedence
+	prj.VariableSets = []string{tags.GUID, account.GUID}
+	resolved, err := vars.Resolve(prj, map[string]string{"region": "eu-west-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(resolved))
+
+	assert.Equal(t, "account_id", resolved[0].Name)
+	assert.Equal(t, "456", resolved[0].Value)
+	assert.Equal(t, model.VariableSourceProject, resolved[0].Source)
+	assert.Equal(t, []model.VariableSource{model.VariableSourceSet}, resolved[0].Shadowed)
+
+	assert.Equal(t, "owner", resolved[1].Name)
+	assert.Equal(t, "account", resolved[1].Value)
+	assert.Equal(t, model.VariableSourceSet, resolved[1].Source)
+	assert.Equal(t, "account", resolved[1].SetName)
+
+	assert.Equal(t, "region", resolved[2].Name)
+	assert.Equal(t, "eu-west-1", resolved[2].Value)
+	assert.Equal(t, model.VariableSourceOverride, resolved[2].Source)
+
+	// attached sets cannot be deleted
+	assert.NoError(t, store.Update(projectNS, prj.GUID, prj))
+	assert.Error(t, vars.DeleteSet(tags.GUID))
+
+	prj.VariableSets = []string{account.GUID}
+	assert.NoError(t, store.Update(projectNS, prj.GUID, prj))
+	assert.NoError(t, vars.DeleteSet(tags.GUID))
+	_, err = vars.GetSet(tags.GUID)
+	assert.Error(t, err)
+}
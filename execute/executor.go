@@ -8,6 +8,7 @@ import (
 	"os/exec"
 
 	"syscall"
+	"time"
 
 	"runtime/debug"
 
@@ -26,14 +27,22 @@ type Executor interface {
 	Schedule(*Task) (*ScheduledTask, error)
 }
 
+// Resolver resolves secret references in environment values. References are resolved just before a
+// task runs, so secrets are never held in tasks, results or the store.
+type Resolver interface {
+	Resolve(value string) (string, error)
+}
+
 // Executor is used to schedule tasks to run
 type executor struct {
-	logDir string
-	taskCh chan *ScheduledTask
+	logDir   string
+	resolver Resolver
+	taskCh   chan *ScheduledTask
 }
 
-// NewExecutor creates returns a pointer to
-func NewExecutor(store persist.Store, logDir string) Executor {
+// NewExecutor creates returns a pointer to an executor, environment values are resolved with the
+// resolver when it is not nil
+func NewExecutor(store persist.Store, logDir string, resolver Resolver) Executor {
 
 	log.Printf("[INFO] Executor log directory: %s", logDir)
 
@@ -43,8 +52,9 @@ func NewExecutor(store persist.Store, logDir string) Executor {
 	}
 
 	exe := &executor{
-		logDir: logDir,
-		taskCh: make(chan *ScheduledTask, 50),
+		logDir:   logDir,
+		resolver: resolver,
+		taskCh:   make(chan *ScheduledTask, 50),
 	}
 
 	go exe.runTasks()
@@ -65,6 +75,7 @@ func (exe *executor) runTasks() {
 
 	for t := range exe.taskCh {
 		log.Printf("[INFO] Executing %s in directory '%s'", t.String(), t.WorkingDirectory)
+		started := time.Now()
 		cmd := exec.Command(t.Command, t.Args...)
 
 		// Set working directory
@@ -73,15 +84,14 @@ func (exe *executor) runTasks() {
 		}
 
 		// Configure environment variables
-		env := os.Environ()
-		for k, v := range t.Environment {
-			env = append(env, fmt.Sprintf("%s=%s", k, v))
+		var output []byte
+		env, err := exe.environment(t)
+		if err == nil {
+			cmd.Env = env
+			output, err = exe.run(t, cmd)
 		}
-		cmd.Env = env
-
-		output, err := cmd.CombinedOutput()
 
-		// in cases where the command was not executed (not found on path)
+		// in cases where the command was not executed (not found on path, secret not resolved)
 		// exit code is -1 and output is the error message
 		var statusCode int
 		if err != nil {
@@ -89,7 +99,6 @@ func (exe *executor) runTasks() {
 				log.Printf("[ERROR] Error executing %s: %s", t.String(), err)
 				statusCode = -1
 				output = []byte(err.Error())
-				return
 			}
 		}
 
@@ -112,11 +121,46 @@ func (exe *executor) runTasks() {
 		}
 
 		// create result, and send across channel
-		result := t.Result(statusCode, output)
+		result := t.Result(statusCode, output, started)
 		t.writeChannel <- result
 	}
 }
 
+// environment returns the environment of the task's process, resolving secret references
+func (exe *executor) environment(t *ScheduledTask) ([]string, error) {
+	env := os.Environ()
+	for k, v := range t.Environment {
+		if exe.resolver != nil {
+			resolved, err := exe.resolver.Resolve(v)
+			if err != nil {
+				return nil, fmt.Errorf("Error resolving environment variable '%s': %s", k, err)
+			}
+			v = resolved
+		}
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return env, nil
+}
+
+// run writes the task's files, runs the command and removes the files
+func (exe *executor) run(t *ScheduledTask, cmd *exec.Cmd) ([]byte, error) {
+	defer func() {
+		for name := range t.Files {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				log.Printf("[ERROR] Error removing file '%s' of %s: %s", name, t.String(), err)
+			}
+		}
+	}()
+
+	for name, content := range t.Files {
+		if err := ioutil.WriteFile(name, content, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return cmd.CombinedOutput()
+}
+
 // Schedule schedules a job to be run
 func (exe *executor) Schedule(task *Task) (st *ScheduledTask, err error) {
 
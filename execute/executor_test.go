@@ -0,0 +1,40 @@
+package execute
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// result waits for the result of a scheduled task
+func result(t *testing.T, st *ScheduledTask) *Result {
+	select {
+	case r := <-st.Channel:
+		return r
+	case <-time.After(10 * time.Second):
+		t.Fatalf("No result for %s, the executor has stopped", st)
+		return nil
+	}
+}
+
+func TestExecutor_command_not_started(t *testing.T) {
+	logDir, err := ioutil.TempDir("", "tfwatch-executor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logDir)
+
+	exe := NewExecutor(nil, logDir, nil)
+
+	// a command that cannot be started fails, and the executor runs the next task
+	st, err := exe.Schedule(&Task{Command: "tfwatch-missing-command"})
+	assert.NoError(t, err)
+	r := result(t, st)
+	assert.Equal(t, -1, r.ExitCode)
+	assert.Contains(t, string(r.Output), "tfwatch-missing-command")
+
+	st, err = exe.Schedule(&Task{Command: "true"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result(t, st).ExitCode)
+}
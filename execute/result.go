@@ -1,9 +1,13 @@
 package execute
 
-// Result contains the results of a task
+import "time"
+
+// Result contains the results of a task, and when it started and finished running
 type Result struct {
 	GUID string
 	Task
 	ExitCode int
 	Output   []byte
+	Started  time.Time
+	Finished time.Time
 }
@@ -3,6 +3,7 @@ package execute
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // ScheduledTask defines a task that has been scheduled to be executed on the system
@@ -18,22 +19,34 @@ func (t ScheduledTask) String() string {
 	return fmt.Sprintf("Task %s: '%s %s'", t.GUID, t.Command, strings.Join(t.Args, " "))
 }
 
-// GetTask returns a task from this
+// MaskedValue replaces environment values in results
+const MaskedValue = "<masked>"
+
+// GetTask returns a task from this, safe to persist in a result
 func (t ScheduledTask) task() Task {
+	env := make(map[string]string, len(t.Environment))
+	for k := range t.Environment {
+		env[k] = MaskedValue
+	}
+
 	return Task{
 		Command:          t.Command,
 		Args:             t.Args,
 		WorkingDirectory: t.WorkingDirectory,
-		Environment:      t.Environment,
+		Environment:      env,
+		Trigger:          t.Trigger,
+		Actor:            t.Actor,
 	}
 }
 
 // Result creates a result from this task
-func (t ScheduledTask) Result(exitCode int, output []byte) *Result {
+func (t ScheduledTask) Result(exitCode int, output []byte, started time.Time) *Result {
 	return &Result{
 		t.GUID,
 		t.task(),
 		exitCode,
 		output,
+		started,
+		time.Now(),
 	}
 }
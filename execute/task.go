@@ -1,9 +1,21 @@
 package execute
 
-// Task
+// Triggers record what caused a task to run
+const (
+	TriggerSchedule = "schedule"
+	TriggerAPI      = "api"
+)
+
+// Task. Files are written, readable only by tfwatch, before the command runs and removed once it
+// exits. Neither file contents nor environment values are recorded in results, since they may
+// contain secrets. Actor is the user, or token, that requested the task, empty for tasks tfwatch runs
+// on its own.
 type Task struct {
 	Command          string
 	Args             []string
 	WorkingDirectory string
 	Environment      map[string]string
+	Files            map[string][]byte
+	Trigger          string
+	Actor            string
 }
@@ -9,8 +9,11 @@ import (
 
 type Fixture string
 
+const TerraformApplied Fixture = "terraform_applied"
 const TerraformNoPlan Fixture = "terraform_noplan"
 const TerraformPlanned Fixture = "terraform_planned"
+const TerraformPlannedJSON Fixture = "terraform_planned_json"
+const TerraformPlannedJSONV0 Fixture = "terraform_planned_json_v0"
 
 // Returns a project from the filesystem
 func GetProject(name Fixture) (*model.Project, error) {
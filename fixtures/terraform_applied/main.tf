@@ -0,0 +1,22 @@
+module "network" {
+  source = "./modules/network"
+}
+
+resource "local_file" "file" {
+  count    = 2
+  filename = "foo-${count.index}"
+  content  = "bar"
+}
+
+data "template_file" "motd" {
+  template = "hello"
+}
+
+output "vpc_id" {
+  value = "${module.network.vpc_id}"
+}
+
+output "db_password" {
+  value     = "hunter2"
+  sensitive = true
+}
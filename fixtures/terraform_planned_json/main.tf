@@ -0,0 +1,27 @@
+variable "region" {
+  default = "us-east-1"
+}
+
+module "network" {
+  source = "./modules/network"
+}
+
+resource "local_file" "file" {
+  filename = "foo"
+  content  = "bar"
+}
+
+resource "local_file" "config" {
+  count    = 2
+  filename = "config-${count.index}"
+  content  = "${module.network.vpc_id}"
+}
+
+resource "local_file" "legacy" {
+  filename = "legacy"
+  content  = "baz"
+}
+
+data "template_file" "motd" {
+  template = "hello"
+}
@@ -0,0 +1,4 @@
+resource "local_file" "file" {
+  filename = "foo"
+  content  = "bar"
+}
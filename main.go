@@ -2,17 +2,39 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/hashicorp/logutils"
 	"github.com/webdevwilson/tfwatch/context"
+	"github.com/webdevwilson/tfwatch/controller"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
 	"log"
+	"net"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
 
 	cfg := ParseArgs(os.Args[1:])
+
+	switch cfg.Command {
+	case "export":
+		os.Exit(export(cfg))
+	case "import":
+		os.Exit(importArchive(cfg))
+	case "migrate":
+		os.Exit(migrate(cfg))
+	case "token":
+		os.Exit(token(cfg))
+	case "user":
+		os.Exit(user(cfg))
+	}
+
 	ctx := context.NewContext(cfg)
 
 	go ctx.Server.Start()
@@ -22,27 +44,116 @@ func main() {
 	}
 }
 
+// commands are the commands run instead of the service, the command is the first argument
+var commands = map[string]string{
+	"export":  "Write an archive of the store to --archive, and exit",
+	"import":  "Load an archive from --archive into the store using --strategy, and exit",
+	"migrate": "Run the migrations of the store that have not run, and exit",
+	"token":   "Run 'token create', 'token list' or 'token revoke' to manage API tokens, and exit",
+	"user":    "Run 'user list' to list users, or 'user reset' to give --user-name a new password, and exit",
+}
+
+// subcommands are the subcommands of commands that have them, the subcommand is the second argument
+var subcommands = map[string][]string{
+	"token": {"create", "list", "revoke"},
+	"user":  {"list", "reset"},
+}
+
 func ParseArgs(args []string) *context.Configuration {
 
-	var checkoutDir, logDir, logLevel, siteDir, stateDir string
-	var port uint
-	var clearState, help, noPlanRuns, verbose bool
+	// the service runs unless a command is given
+	var command, subcommand string
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			command, args = args[0], args[1:]
+		}
+	}
+
+	// the token and user commands have subcommands
+	if names, ok := subcommands[command]; ok {
+		for _, name := range names {
+			if len(args) > 0 && args[0] == name {
+				subcommand, args = args[0], args[1:]
+				break
+			}
+		}
+		if subcommand == "" {
+			quoted := make([]string, len(names))
+			for i, name := range names {
+				quoted[i] = "'" + command + " " + name + "'"
+			}
+			log.Printf("[ERROR] Expected %s or %s", strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1])
+			os.Exit(1)
+		}
+	}
+	var tokenCommand, userCommand string
+	if command == "token" {
+		tokenCommand = subcommand
+	} else if command == "user" {
+		userCommand = subcommand
+	}
+
+	var adminPassword, proxyHeader, secretsEnv, trustedProxies, userName, vaultPaths string
+	var archive, strategy, checkoutDir, logDir, logLevel, masterKeyFile, secretsDir, siteDir, stateDir, store, storeEncoding, vaultAddr string
+	var tokenName, tokenScopes, tokenID string
+	var tokenExpires time.Duration
+	var port, pruneInterval uint
+	var retainCount, retainDays, retainFailedCount, retainFailedDays, retainApplyCount, retainApplyDays uint
+	var clearState, dryRun, help, noAuth, noPlanRuns, verbose bool
 
 	flags := flag.NewFlagSet("tfwatch", flag.ExitOnError)
+	flags.StringVar(&adminPassword, "admin-password", envOr("ADMIN_PASSWORD", ""), "Password of the admin user created when there are no users, a random password is logged when not set")
+	flags.StringVar(&archive, "archive", "-", "With export and import, the archive file, - for standard output or input")
 	flags.BoolVar(&clearState, "clear-state", false, "Remove all state before starting")
+	flags.BoolVar(&dryRun, "dry-run", false, "With migrate, list the migrations that would run without running them")
 	flags.BoolVar(&help, "h", false, "")
 	flags.BoolVar(&help, "help", false, "Display usage information")
 	flags.StringVar(&logDir, "log-dir", "", "Directory the logs will be placed in")
 	flags.StringVar(&logLevel, "log-level", envOr("LOG_LEVEL", "INFO"), "Log level. One of DEBUG, INFO, WARN, ERROR")
+	flags.BoolVar(&noAuth, "no-auth", envBoolOr("NO_AUTH", false), "Serve the API without requiring tokens or a login, for local development only")
+	flags.StringVar(&masterKeyFile, "master-key-file", envOr("MASTER_KEY_FILE", ""), "File containing the base64 master key secrets are encrypted with, generated when it does not exist. Keep it outside the state directory")
 	flags.BoolVar(&noPlanRuns, "no-plans", false, "Prevents tfwatch from updating the plans")
 	flags.UintVar(&port, "port", 3000, "Defines port HTTP server will bind to")
+	flags.StringVar(&proxyHeader, "proxy-user-header", envOr("PROXY_USER_HEADER", ""), "Header a trusted reverse proxy sets to the username of the user making a request, such as X-Forwarded-User")
+	flags.UintVar(&pruneInterval, "prune-interval", envUintOr("PRUNE_INTERVAL", 60), "Minutes between pruning executions, 0 disables pruning")
+	flags.UintVar(&retainApplyCount, "retain-apply-executions", envUintOr("RETAIN_APPLY_EXECUTIONS", 0), "Number of applies kept per project, 0 keeps all")
+	flags.UintVar(&retainApplyDays, "retain-apply-days", envUintOr("RETAIN_APPLY_DAYS", 0), "Days applies are kept, 0 keeps them forever")
+	flags.UintVar(&retainCount, "retain-executions", envUintOr("RETAIN_EXECUTIONS", 0), "Number of executions kept per project, 0 keeps all")
+	flags.UintVar(&retainDays, "retain-days", envUintOr("RETAIN_DAYS", 0), "Days executions are kept, 0 keeps them forever")
+	flags.UintVar(&retainFailedCount, "retain-failed-executions", envUintOr("RETAIN_FAILED_EXECUTIONS", 0), "Number of failed executions kept per project, 0 keeps all")
+	flags.UintVar(&retainFailedDays, "retain-failed-days", envUintOr("RETAIN_FAILED_DAYS", 0), "Days failed executions are kept, 0 keeps them forever")
+	flags.StringVar(&secretsDir, "secrets-dir", envOr("SECRETS_DIR", ""), "Directory 'secret:file:' references are read from")
+	flags.StringVar(&secretsEnv, "secrets-env", envOr("SECRETS_ENV", ""), "Comma separated environment variables 'secret:env:' references can read, a trailing * allows a prefix")
 	flags.StringVar(&siteDir, "site-dir", envOr("SITE_DIR", "site"), "Directory site is served from")
 	flags.StringVar(&stateDir, "state-dir", envOr("STATE_DIR", ""), "Directory where state is stored")
+	flags.StringVar(&store, "store", envOr("STORE", "bolt"), "Where state is stored. One of bolt, memory, local:<dir>, sqlite:<file>, or a postgres:// URL")
+	flags.StringVar(&strategy, "strategy", string(persist.ImportSkip), "With import, what happens to stored values that are in the archive. One of skip, overwrite, replace")
+	flags.StringVar(&storeEncoding, "store-encoding", envOr("STORE_ENCODING", string(persist.GobEncoding)), "Encoding values are written to the store in. One of gob, json")
+	flags.DurationVar(&tokenExpires, "token-expires", 0, "With token create, how long the token is valid for, such as 720h, 0 never expires")
+	flags.StringVar(&tokenID, "token-id", "", "With token revoke, the guid of the token")
+	flags.StringVar(&tokenName, "token-name", "", "With token create, the name of the token")
+	flags.StringVar(&tokenScopes, "token-scopes", string(model.ScopeRead), "With token create, the scopes of the token. Any of read, plan, apply, admin")
+	flags.StringVar(&trustedProxies, "trusted-proxies", envOr("TRUSTED_PROXIES", "127.0.0.1/32,::1/128"), "Comma separated CIDRs of the proxies whose --proxy-user-header is trusted")
+	flags.StringVar(&userName, "user-name", "", "With user reset, the username of the user")
+	flags.StringVar(&vaultAddr, "vault-addr", envOr("VAULT_ADDR", ""), "Address of the Vault server 'secret:vault:' references are read from")
+	flags.StringVar(&vaultPaths, "vault-paths", envOr("VAULT_PATHS", ""), "Comma separated Vault secrets, as <mount>/<path>, 'secret:vault:' references can read, a trailing * allows a prefix")
 	flags.BoolVar(&verbose, "v", false, "")
 	flags.BoolVar(&verbose, "verbose", false, "Configure max logging")
 
-	//flag.Usage = usage
-	flags.Parse(os.Args[1:])
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: tfwatch [command] [flags] <checkout directory>\n\nCommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %s\t%s\n", name, commands[name])
+		}
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
 
 	// print helpful usage information
 	if help {
@@ -72,16 +183,131 @@ func ParseArgs(args []string) *context.Configuration {
 
 	logLevel = strings.ToUpper(logLevel)
 
+	encoding, err := persist.ParseEncoding(storeEncoding)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		os.Exit(1)
+	}
+
+	importStrategy, err := persist.ParseImportStrategy(strategy)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		os.Exit(1)
+	}
+
+	scopes, err := model.ParseScopes(tokenScopes)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		os.Exit(1)
+	}
+
+	token := model.Token{GUID: tokenID, Name: tokenName, Scopes: scopes}
+	if tokenExpires > 0 {
+		token.Expires = time.Now().Add(tokenExpires).UTC()
+	}
+
+	proxies, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		os.Exit(1)
+	}
+
 	return &context.Configuration{
-		CheckoutDir: checkoutDir,
-		ClearState:  clearState,
-		LogDir:      logDir,
-		LogLevel:    logutils.LogLevel(logLevel),
-		Port:        uint16(port),
-		RunPlan:     !noPlanRuns,
-		SiteDir:     siteDir,
-		StateDir:    stateDir,
+		AdminPassword: adminPassword,
+		Archive:       archive,
+		CheckoutDir:   checkoutDir,
+		ClearState:    clearState,
+		Command:       command,
+		DryRun:        dryRun,
+		LogDir:        logDir,
+		LogLevel:      logutils.LogLevel(logLevel),
+		MasterKey:     os.Getenv("TFWATCH_MASTER_KEY"),
+		MasterKeyFile: masterKeyFile,
+		NoAuth:        noAuth,
+		Port:          uint16(port),
+		ProxyHeader:   proxyHeader,
+		PruneInterval: time.Duration(pruneInterval) * time.Minute,
+		Retention: controller.RetentionPolicy{
+			Executions: retention(retainCount, retainDays),
+			Failed:     retention(retainFailedCount, retainFailedDays),
+			Applies:    retention(retainApplyCount, retainApplyDays),
+		},
+		RunPlan:        !noPlanRuns,
+		SecretsDir:     secretsDir,
+		SecretsEnv:     splitList(secretsEnv),
+		SiteDir:        siteDir,
+		StateDir:       stateDir,
+		Store:          store,
+		StoreEncoding:  encoding,
+		Strategy:       importStrategy,
+		Token:          token,
+		TokenCommand:   tokenCommand,
+		TrustedProxies: proxies,
+		User:           model.User{Username: userName},
+		UserCommand:    userCommand,
+		VaultAddr:      vaultAddr,
+		VaultPaths:     splitList(vaultPaths),
+		VaultToken:     os.Getenv("VAULT_TOKEN"),
+	}
+}
+
+// retention creates the retention of a number of executions or days
+func retention(count, days uint) controller.Retention {
+	return controller.Retention{
+		Count: int(count),
+		Age:   time.Duration(days) * 24 * time.Hour,
+	}
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseCIDRs parses a comma separated list of CIDRs
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, cidr := range strings.Split(list, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy '%s': %s", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// envUintOr returns the environment variable as an unsigned integer or the default value
+func envUintOr(name string, defaultVal uint) uint {
+	v, err := strconv.ParseUint(os.Getenv(name), 10, 0)
+	if err != nil {
+		return defaultVal
+	}
+	return uint(v)
+}
+
+// envBool returns true when the environment variable is a true boolean value
+func envBool(name string) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && v
+}
+
+// envBoolOr returns the boolean value of the environment variable or the default value
+func envBoolOr(name string, defaultVal bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return defaultVal
 	}
+	return v
 }
 
 // envOr returns the environment variable or the default values
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/webdevwilson/tfwatch/context"
+)
+
+// migrate runs the migrations of the store that have not run, returning the exit code
+func migrate(cfg *context.Configuration) int {
+	migrations, err := context.Migrate(cfg, cfg.DryRun)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		return 1
+	}
+
+	if len(migrations) == 0 {
+		fmt.Println("The store is up to date")
+		return 0
+	}
+
+	verb := "Ran"
+	if cfg.DryRun {
+		verb = "Would run"
+	}
+	for _, m := range migrations {
+		fmt.Printf("%s migration %d: %s\n", verb, m.Version, m.Description)
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/config"
+)
+
+// ProjectConfig describes the shape of a project's configuration, read from its .tf files
+// without running terraform
+type ProjectConfig struct {
+	RequiredVersion string            `json:"required_version,omitempty"`
+	Backend         string            `json:"backend,omitempty"`
+	Variables       []*ConfigVariable `json:"variables"`
+	Providers       []*ConfigProvider `json:"providers"`
+	Modules         []*ConfigModule   `json:"modules"`
+	Resources       []*ConfigResource `json:"resources"`
+	DataSources     []*ConfigResource `json:"data_sources"`
+	Outputs         []*ConfigOutput   `json:"outputs"`
+}
+
+// ConfigVariable is a declared variable, variables without a default must be given a value
+type ConfigVariable struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required"`
+}
+
+// ConfigProvider is a provider used by the configuration. Providers used by resources without
+// a provider block are implied.
+type ConfigProvider struct {
+	Name    string `json:"name"`
+	Alias   string `json:"alias,omitempty"`
+	Version string `json:"version,omitempty"`
+	Implied bool   `json:"implied"`
+}
+
+// ConfigModule is a module call
+type ConfigModule struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// ConfigResource is a resource or data source
+type ConfigResource struct {
+	Address  string `json:"address"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Count    string `json:"count,omitempty"`
+}
+
+// ConfigOutput is a declared output
+type ConfigOutput struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Sensitive   bool   `json:"sensitive"`
+}
+
+// Config loads the project's configuration
+func (prj *Project) Config() (*ProjectConfig, error) {
+	cfg, err := config.LoadDir(prj.LocalPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load configuration of project '%s': %s", prj.Name, err)
+	}
+	return NewProjectConfig(cfg), nil
+}
+
+// NewProjectConfig describes a configuration loaded by the terraform config package
+func NewProjectConfig(cfg *config.Config) *ProjectConfig {
+	pc := &ProjectConfig{
+		Variables:   []*ConfigVariable{},
+		Providers:   []*ConfigProvider{},
+		Modules:     []*ConfigModule{},
+		Resources:   []*ConfigResource{},
+		DataSources: []*ConfigResource{},
+		Outputs:     []*ConfigOutput{},
+	}
+
+	if cfg.Terraform != nil {
+		pc.RequiredVersion = cfg.Terraform.RequiredVersion
+		if cfg.Terraform.Backend != nil {
+			pc.Backend = cfg.Terraform.Backend.Type
+		}
+	}
+
+	for _, v := range cfg.Variables {
+		pc.Variables = append(pc.Variables, &ConfigVariable{
+			Name:        v.Name,
+			Type:        v.Type().Printable(),
+			Default:     v.Default,
+			Description: v.Description,
+			Required:    v.Required(),
+		})
+	}
+
+	declared := make(map[string]bool)
+	for _, p := range cfg.ProviderConfigs {
+		declared[p.Name] = true
+		pc.Providers = append(pc.Providers, &ConfigProvider{
+			Name:    p.Name,
+			Alias:   p.Alias,
+			Version: rawString(p.RawConfig, "version"),
+		})
+	}
+
+	for _, m := range cfg.Modules {
+		pc.Modules = append(pc.Modules, &ConfigModule{
+			Name:   m.Name,
+			Source: m.Source,
+		})
+	}
+
+	for _, r := range cfg.Resources {
+		provider := resourceProvider(r.Type, r.Provider)
+		resource := &ConfigResource{
+			Address:  r.Id(),
+			Type:     r.Type,
+			Name:     r.Name,
+			Provider: provider,
+			Count:    rawString(r.RawCount, "count"),
+		}
+		if resource.Count == "1" {
+			resource.Count = ""
+		}
+
+		if r.Mode == config.DataResourceMode {
+			pc.DataSources = append(pc.DataSources, resource)
+		} else {
+			pc.Resources = append(pc.Resources, resource)
+		}
+
+		// providers used without a block are implied, aliases always have a block
+		name := strings.SplitN(provider, ".", 2)[0]
+		if !declared[name] {
+			declared[name] = true
+			pc.Providers = append(pc.Providers, &ConfigProvider{
+				Name:    name,
+				Implied: true,
+			})
+		}
+	}
+
+	// sensitive is only set on outputs when the configuration is validated, so read it from the raw config
+	for _, o := range cfg.Outputs {
+		pc.Outputs = append(pc.Outputs, &ConfigOutput{
+			Name:        o.Name,
+			Description: o.Description,
+			Sensitive:   o.Sensitive || rawString(o.RawConfig, "sensitive") == "true",
+		})
+	}
+
+	sort.Slice(pc.Providers, func(i, j int) bool {
+		return pc.Providers[i].Name+"."+pc.Providers[i].Alias < pc.Providers[j].Name+"."+pc.Providers[j].Alias
+	})
+
+	return pc
+}
+
+// rawString returns the uninterpolated value of a key in a raw configuration as a string
+func rawString(raw *config.RawConfig, key string) string {
+	if raw == nil {
+		return ""
+	}
+	v, ok := raw.Raw[key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_required_variable(t *testing.T) {
+	cfg, err := data[1].Config()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cfg.Variables))
+	assert.Equal(t, ConfigVariable{Name: "contents", Type: "string", Required: true}, *cfg.Variables[0])
+}
+
+func TestConfig(t *testing.T) {
+	cfg, err := jsonPlanned.Config()
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, ConfigVariable{Name: "region", Type: "string", Default: "us-east-1"}, *cfg.Variables[0])
+	assert.Equal(t, []*ConfigModule{{"network", "./modules/network"}}, cfg.Modules)
+
+	assert.Equal(t, 3, len(cfg.Resources))
+	assert.Equal(t, ConfigResource{"local_file.config", "local_file", "config", "local", "2"}, *cfg.Resources[1])
+
+	assert.Equal(t, 1, len(cfg.DataSources))
+	assert.Equal(t, "data.template_file.motd", cfg.DataSources[0].Address)
+
+	assert.Equal(t, []*ConfigProvider{
+		{Name: "local", Implied: true},
+		{Name: "template", Implied: true},
+	}, cfg.Providers)
+}
+
+func TestConfig_outputs(t *testing.T) {
+	cfg, err := (&Project{LocalPath: "../fixtures/terraform_applied"}).Config()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*ConfigOutput{
+		{Name: "vpc_id"},
+		{Name: "db_password", Sensitive: true},
+	}, cfg.Outputs)
+}
+
+func TestConfig_missing_directory(t *testing.T) {
+	_, err := (&Project{LocalPath: "../fixtures/missing"}).Config()
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CredentialType is the kind of credentials a profile holds
+type CredentialType string
+
+// Types of credential profiles
+const (
+	CredentialTypeAWS CredentialType = "aws"
+	CredentialTypeEnv CredentialType = "env"
+)
+
+// CredentialProfile is a named set of cloud credentials that can be assigned to many projects, so that
+// rotating a key is a single update. Secrets are never returned once they are stored.
+type CredentialProfile struct {
+	GUID string            `json:"guid,omitempty"`
+	Name string            `json:"name"`
+	Type CredentialType    `json:"type"`
+	AWS  *AWSCredentials   `json:"aws,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+}
+
+// AWSCredentials are an access key, and the role assumed with it. Credentials are passed to terraform
+// in the environment, or in a temporary shared credentials file when a role is assumed or SharedFile is set.
+type AWSCredentials struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+	RoleARN         string `json:"role_arn,omitempty"`
+	Region          string `json:"region,omitempty"`
+	SharedFile      bool   `json:"shared_file"`
+}
+
+// Validate checks the profile holds the credentials of its type
+func (c *CredentialProfile) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("Credential profile name is required")
+	}
+
+	switch c.Type {
+	case CredentialTypeAWS:
+		if c.AWS == nil || c.AWS.AccessKeyID == "" {
+			return fmt.Errorf("Credential profile '%s' has no AWS access key", c.Name)
+		}
+	case CredentialTypeEnv:
+		if len(c.Env) == 0 {
+			return fmt.Errorf("Credential profile '%s' has no environment variables", c.Name)
+		}
+	default:
+		return fmt.Errorf("Unknown credential type '%s'", c.Type)
+	}
+	return nil
+}
+
+// Masked returns a copy of the profile safe to return from the API. Environment variable names and
+// AWS access key ids are kept, they identify the credentials without granting access.
+func (c *CredentialProfile) Masked() *CredentialProfile {
+	masked := *c
+	if c.AWS != nil {
+		aws := *c.AWS
+		aws.SecretAccessKey = ""
+		aws.SessionToken = ""
+		masked.AWS = &aws
+	}
+	if c.Env != nil {
+		masked.Env = make(map[string]string, len(c.Env))
+		for k := range c.Env {
+			masked.Env[k] = ""
+		}
+	}
+	return &masked
+}
+
+// KeepSecrets copies the secrets of a stored profile into values the update left empty
+func (c *CredentialProfile) KeepSecrets(stored *CredentialProfile) {
+	if c.AWS != nil && stored.AWS != nil && c.AWS.AccessKeyID == stored.AWS.AccessKeyID {
+		if c.AWS.SecretAccessKey == "" {
+			c.AWS.SecretAccessKey = stored.AWS.SecretAccessKey
+		}
+		if c.AWS.SessionToken == "" {
+			c.AWS.SessionToken = stored.AWS.SessionToken
+		}
+	}
+	for k, v := range c.Env {
+		if v == "" {
+			c.Env[k] = stored.Env[k]
+		}
+	}
+}
+
+// awsProfile names the profile credentials are written to in shared files
+const awsProfile = "tfwatch"
+
+// Environment returns the environment variables passing the credentials to terraform
+func (c *CredentialProfile) Environment() map[string]string {
+	env := make(map[string]string)
+	for k, v := range c.Env {
+		env[k] = v
+	}
+
+	if c.AWS == nil {
+		return env
+	}
+
+	env["AWS_ACCESS_KEY_ID"] = c.AWS.AccessKeyID
+	env["AWS_SECRET_ACCESS_KEY"] = c.AWS.SecretAccessKey
+	if c.AWS.SessionToken != "" {
+		env["AWS_SESSION_TOKEN"] = c.AWS.SessionToken
+	}
+	if c.AWS.Region != "" {
+		env["AWS_REGION"] = c.AWS.Region
+		env["AWS_DEFAULT_REGION"] = c.AWS.Region
+	}
+	return env
+}
+
+// SharedFiles returns the contents of the AWS shared credentials and config files, and the
+// environment pointing terraform at them. Roles are assumed through a profile sourcing the key.
+func (c *CredentialProfile) SharedFiles(credentialsFile, configFile string) (credentials, config []byte, env map[string]string) {
+	credentials = []byte(fmt.Sprintf("[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\n",
+		awsProfile, c.AWS.AccessKeyID, c.AWS.SecretAccessKey))
+	if c.AWS.SessionToken != "" {
+		credentials = append(credentials, fmt.Sprintf("aws_session_token = %s\n", c.AWS.SessionToken)...)
+	}
+
+	profile := awsProfile
+	config = []byte(fmt.Sprintf("[profile %s]\n", awsProfile))
+	if c.AWS.Region != "" {
+		config = append(config, fmt.Sprintf("region = %s\n", c.AWS.Region)...)
+	}
+	if c.AWS.RoleARN != "" {
+		profile = awsProfile + "-role"
+		config = append(config, fmt.Sprintf("\n[profile %s]\nrole_arn = %s\nsource_profile = %s\n",
+			profile, c.AWS.RoleARN, awsProfile)...)
+		if c.AWS.Region != "" {
+			config = append(config, fmt.Sprintf("region = %s\n", c.AWS.Region)...)
+		}
+	}
+
+	env = map[string]string{
+		"AWS_SHARED_CREDENTIALS_FILE": credentialsFile,
+		"AWS_CONFIG_FILE":             configFile,
+		"AWS_PROFILE":                 profile,
+		"AWS_SDK_LOAD_CONFIG":         "1",
+	}
+	if c.AWS.Region != "" {
+		env["AWS_REGION"] = c.AWS.Region
+		env["AWS_DEFAULT_REGION"] = c.AWS.Region
+	}
+	return
+}
+
+// UsesSharedFiles returns true if the credentials are passed in shared credentials files
+func (c *CredentialProfile) UsesSharedFiles() bool {
+	return c.AWS != nil && (c.AWS.SharedFile || c.AWS.RoleARN != "")
+}
+
+// SortCredentialProfiles orders profiles by name
+func SortCredentialProfiles(profiles []*CredentialProfile) {
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+}
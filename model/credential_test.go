@@ -0,0 +1,51 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialProfile_Validate(t *testing.T) {
+	assert.Error(t, (&CredentialProfile{Name: "x", Type: CredentialTypeAWS}).Validate())
+	assert.Error(t, (&CredentialProfile{Name: "x", Type: CredentialTypeEnv}).Validate())
+	assert.Error(t, (&CredentialProfile{Name: "x", Type: "gcp"}).Validate())
+	assert.NoError(t, (&CredentialProfile{Name: "x", Type: CredentialTypeEnv, Env: map[string]string{"A": "b"}}).Validate())
+}
+
+func TestCredentialProfile_Masked(t *testing.T) {
+	c := &CredentialProfile{
+		Name: "prod",
+		Type: CredentialTypeAWS,
+		AWS:  &AWSCredentials{AccessKeyID: "AKIA", SecretAccessKey: "secret", SessionToken: "token"},
+		Env:  map[string]string{"EXTRA": "value"},
+	}
+
+	masked := c.Masked()
+	assert.Equal(t, "AKIA", masked.AWS.AccessKeyID)
+	assert.Equal(t, "", masked.AWS.SecretAccessKey)
+	assert.Equal(t, "", masked.AWS.SessionToken)
+	assert.Equal(t, map[string]string{"EXTRA": ""}, masked.Env)
+
+	// the original is untouched
+	assert.Equal(t, "secret", c.AWS.SecretAccessKey)
+	assert.Equal(t, "value", c.Env["EXTRA"])
+}
+
+func TestCredentialProfile_SharedFiles(t *testing.T) {
+	c := &CredentialProfile{
+		Name: "prod",
+		Type: CredentialTypeAWS,
+		AWS:  &AWSCredentials{AccessKeyID: "AKIA", SecretAccessKey: "secret", RoleARN: "arn:aws:iam::123:role/deploy", Region: "us-east-1"},
+	}
+	assert.True(t, c.UsesSharedFiles())
+
+	credentials, config, env := c.SharedFiles("/tmp/creds", "/tmp/config")
+	assert.True(t, strings.Contains(string(credentials), "aws_secret_access_key = secret"))
+	assert.True(t, strings.Contains(string(config), "role_arn = arn:aws:iam::123:role/deploy\nsource_profile = tfwatch"))
+	assert.Equal(t, "tfwatch-role", env["AWS_PROFILE"])
+	assert.Equal(t, "/tmp/creds", env["AWS_SHARED_CREDENTIALS_FILE"])
+	assert.Equal(t, "us-east-1", env["AWS_REGION"])
+	assert.NotContains(t, env, "AWS_SECRET_ACCESS_KEY")
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/config"
+)
+
+// ModuleNode is a module in the tree of pending changes. Counts are rolled up from the module's
+// own changes and those of all of its descendants.
+type ModuleNode struct {
+	Name     string           `json:"name"`
+	Path     []string         `json:"path"`
+	Source   string           `json:"source,omitempty"`
+	Changes  []ResourceChange `json:"changes"`
+	Counts   ChangeCounts     `json:"counts"`
+	Children []*ModuleNode    `json:"children"`
+}
+
+// ModuleTree arranges changes into a tree of modules rooted at the root module. Sources are keyed
+// by module path, as returned by Project.ModuleSources.
+func ModuleTree(changes []ResourceChange, sources map[string]string) *ModuleNode {
+	root := newModuleNode([]string{"root"}, sources)
+
+	for _, change := range changes {
+		node := root
+		modulePath := change.ModulePath
+		if len(modulePath) == 0 {
+			modulePath = []string{"root"}
+		}
+
+		for i := 1; i < len(modulePath); i++ {
+			node = node.child(modulePath[:i+1], sources)
+		}
+		node.Changes = append(node.Changes, change)
+	}
+
+	root.rollup()
+	return root
+}
+
+func newModuleNode(modulePath []string, sources map[string]string) *ModuleNode {
+	return &ModuleNode{
+		Name:     modulePath[len(modulePath)-1],
+		Path:     modulePath,
+		Source:   sources[moduleKey(modulePath)],
+		Changes:  []ResourceChange{},
+		Children: []*ModuleNode{},
+	}
+}
+
+// child returns the child module with the path, creating it if it does not exist
+func (n *ModuleNode) child(modulePath []string, sources map[string]string) *ModuleNode {
+	name := modulePath[len(modulePath)-1]
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	c := newModuleNode(append([]string{}, modulePath...), sources)
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// rollup computes the counts of the node and its descendants, and sorts children by name
+func (n *ModuleNode) rollup() ChangeCounts {
+	n.Counts = ChangeCounts{}
+	for _, change := range n.Changes {
+		n.Counts.count(change.Action)
+	}
+
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+
+	for _, c := range n.Children {
+		counts := c.rollup()
+		n.Counts.Add += counts.Add
+		n.Counts.Change += counts.Change
+		n.Counts.Destroy += counts.Destroy
+		n.Counts.Replace += counts.Replace
+	}
+
+	return n.Counts
+}
+
+// moduleKey identifies a module path regardless of count or for_each keys
+func moduleKey(modulePath []string) string {
+	names := make([]string, len(modulePath))
+	for i, name := range modulePath {
+		if idx := strings.Index(name, "["); idx > 0 {
+			name = name[:idx]
+		}
+		names[i] = name
+	}
+	return strings.Join(names, ".")
+}
+
+// ModuleSources parses the project's configuration and returns the source of each module call,
+// keyed by the module path, e.g. "root.network". Local modules are followed to find the modules they
+// call, remote modules are not fetched so their calls are unknown. A local module calling one of the
+// modules that led to it is not followed again.
+func (prj *Project) ModuleSources() (map[string]string, error) {
+	sources := make(map[string]string)
+	err := moduleSources(prj.LocalPath, []string{"root"}, sources, make(map[string]bool))
+	return sources, err
+}
+
+// moduleSources records the sources of the modules called from dir. Ancestors are the absolute
+// directories of the modules leading to dir, the same module may be called from different paths.
+func moduleSources(dir string, modulePath []string, sources map[string]string, ancestors map[string]bool) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if ancestors[abs] {
+		log.Printf("[WARN] Module '%s' calls itself through '%s', not following it", moduleKey(modulePath), dir)
+		return nil
+	}
+	ancestors[abs] = true
+	defer delete(ancestors, abs)
+
+	cfg, err := config.LoadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range cfg.Modules {
+		childPath := append(append([]string{}, modulePath...), m.Name)
+		sources[moduleKey(childPath)] = m.Source
+
+		if !strings.HasPrefix(m.Source, "./") && !strings.HasPrefix(m.Source, "../") {
+			continue
+		}
+
+		if err := moduleSources(filepath.Join(dir, m.Source), childPath, sources, ancestors); err != nil {
+			log.Printf("[WARN] Error loading module '%s' from '%s': %s", m.Name, m.Source, err)
+		}
+	}
+
+	return nil
+}
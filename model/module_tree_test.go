@@ -0,0 +1,89 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleTree(t *testing.T) {
+	changes := append(summaryChanges,
+		ResourceChange{"root.network.subnets.aws_subnet.b", ActionCreate, "aws_subnet", "b", []string{"root", "network", "subnets"}},
+		ResourceChange{"root.dns.aws_route53_zone.z", ActionUpdate, "aws_route53_zone", "z", []string{"root", "dns"}},
+	)
+	sources := map[string]string{
+		"root.network":         "./modules/network",
+		"root.network.subnets": "./subnets",
+	}
+
+	tree := ModuleTree(changes, sources)
+
+	assert.Equal(t, "root", tree.Name)
+	assert.Equal(t, 3, len(tree.Changes))
+	assert.Equal(t, ChangeCounts{Add: 3, Change: 2, Destroy: 1, Replace: 1}, tree.Counts)
+	assert.Equal(t, 2, len(tree.Children))
+
+	dns := tree.Children[0]
+	assert.Equal(t, "dns", dns.Name)
+	assert.Equal(t, "", dns.Source)
+	assert.Equal(t, ChangeCounts{Change: 1}, dns.Counts)
+
+	network := tree.Children[1]
+	assert.Equal(t, []string{"root", "network"}, network.Path)
+	assert.Equal(t, "./modules/network", network.Source)
+	assert.Equal(t, 2, len(network.Changes))
+	assert.Equal(t, ChangeCounts{Add: 1, Destroy: 1, Replace: 1}, network.Counts)
+
+	subnets := network.Children[0]
+	assert.Equal(t, "./subnets", subnets.Source)
+	assert.Equal(t, 1, len(subnets.Changes))
+	assert.Equal(t, 0, len(subnets.Children))
+}
+
+func TestModuleTree_indexed_modules_share_source(t *testing.T) {
+	changes := []ResourceChange{
+		{"root.app[0].aws_instance.a", ActionCreate, "aws_instance", "a", []string{"root", "app[0]"}},
+		{"root.app[1].aws_instance.a", ActionCreate, "aws_instance", "a", []string{"root", "app[1]"}},
+	}
+
+	tree := ModuleTree(changes, map[string]string{"root.app": "./app"})
+
+	assert.Equal(t, 2, len(tree.Children))
+	assert.Equal(t, "./app", tree.Children[0].Source)
+	assert.Equal(t, "./app", tree.Children[1].Source)
+	assert.Equal(t, 2, tree.Counts.Add)
+}
+
+func TestProject_ModuleSources(t *testing.T) {
+	sources, err := jsonPlanned.ModuleSources()
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"root.network": "./modules/network"}, sources)
+}
+
+func TestProject_ModuleSources_cycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tfwatch-modules")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the project calls a twice, and a calls back to the project
+	for file, config := range map[string]string{
+		"project/main.tf": "module \"a\" {\n  source = \"../a\"\n}\nmodule \"again\" {\n  source = \"../a\"\n}\n",
+		"a/main.tf":       "module \"back\" {\n  source = \"../project\"\n}\n",
+	} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(config), 0644))
+	}
+
+	sources, err := (&Project{LocalPath: filepath.Join(dir, "project")}).ModuleSources()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"root.a":          "../a",
+		"root.a.back":     "../project",
+		"root.again":      "../a",
+		"root.again.back": "../project",
+	}, sources)
+}
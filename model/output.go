@@ -0,0 +1,107 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// OutputSnapshot records the root module outputs of a project at a point in time. Sensitive values
+// are masked before they are recorded.
+type OutputSnapshot struct {
+	Taken   time.Time      `json:"taken"`
+	Serial  int64          `json:"serial"`
+	Outputs []*StateOutput `json:"outputs"`
+}
+
+// OutputHistoryEntry is the value of an output when a snapshot was taken
+type OutputHistoryEntry struct {
+	Taken     time.Time   `json:"taken"`
+	Serial    int64       `json:"serial"`
+	Type      string      `json:"type"`
+	Sensitive bool        `json:"sensitive"`
+	Value     interface{} `json:"value"`
+}
+
+// ProjectOutput is the current value of an output exported by a project
+type ProjectOutput struct {
+	ProjectGUID string      `json:"project_guid"`
+	ProjectName string      `json:"project_name"`
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Sensitive   bool        `json:"sensitive"`
+	Value       interface{} `json:"value"`
+	Updated     time.Time   `json:"updated"`
+}
+
+// NewOutputSnapshot snapshots the outputs of a state
+func NewOutputSnapshot(state *State) *OutputSnapshot {
+	return &OutputSnapshot{
+		Taken:   time.Now(),
+		Serial:  state.Info().Serial,
+		Outputs: state.Outputs(),
+	}
+}
+
+// OutputNS returns the namespace to use for this projects output snapshots
+func (prj *Project) OutputNS() string {
+	return fmt.Sprintf("project-%s-outputs", prj.GUID)
+}
+
+// Output returns the named output, nil if the snapshot does not contain it
+func (s *OutputSnapshot) Output(name string) *StateOutput {
+	for _, o := range s.Outputs {
+		if o.Name == name {
+			return o
+		}
+	}
+	return nil
+}
+
+// SameValues returns true if both snapshots contain the same outputs with the same values
+func (s *OutputSnapshot) SameValues(other *OutputSnapshot) bool {
+	if other == nil || len(s.Outputs) != len(other.Outputs) {
+		return false
+	}
+	for _, o := range s.Outputs {
+		if !reflect.DeepEqual(o, other.Output(o.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortSnapshots orders snapshots from oldest to newest
+func SortSnapshots(snapshots []*OutputSnapshot) {
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Taken.Before(snapshots[j].Taken)
+	})
+}
+
+// OutputHistory returns the values the named output has held, oldest first. Consecutive snapshots
+// where the output was unchanged are collapsed into the first of them.
+func OutputHistory(snapshots []*OutputSnapshot, name string) []*OutputHistoryEntry {
+	SortSnapshots(snapshots)
+
+	history := []*OutputHistoryEntry{}
+	var last *StateOutput
+	for _, s := range snapshots {
+		o := s.Output(name)
+		if o == nil || reflect.DeepEqual(o, last) {
+			last = o
+			continue
+		}
+		last = o
+
+		history = append(history, &OutputHistoryEntry{
+			Taken:     s.Taken,
+			Serial:    s.Serial,
+			Type:      o.Type,
+			Sensitive: o.Sensitive,
+			Value:     o.Value,
+		})
+	}
+
+	return history
+}
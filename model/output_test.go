@@ -0,0 +1,62 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func snapshot(minutes int, serial int64, outputs ...*StateOutput) *OutputSnapshot {
+	return &OutputSnapshot{
+		Taken:   time.Date(2017, 7, 1, 0, minutes, 0, 0, time.UTC),
+		Serial:  serial,
+		Outputs: outputs,
+	}
+}
+
+func TestOutputSnapshot_from_state(t *testing.T) {
+	s := NewOutputSnapshot(readFixtureState(t))
+
+	assert.Equal(t, int64(7), s.Serial)
+	assert.Equal(t, SensitiveMask, s.Output("db_password").Value)
+	assert.Equal(t, "vpc-0a1b2c3d", s.Output("vpc_id").Value)
+	assert.Nil(t, s.Output("missing"))
+}
+
+func TestOutputSnapshot_same_values(t *testing.T) {
+	a := snapshot(0, 1, &StateOutput{"vpc_id", "string", false, "vpc-1"})
+	b := snapshot(5, 2, &StateOutput{"vpc_id", "string", false, "vpc-1"})
+	c := snapshot(5, 2, &StateOutput{"vpc_id", "string", false, "vpc-2"})
+
+	assert.True(t, a.SameValues(b))
+	assert.False(t, a.SameValues(c))
+	assert.False(t, a.SameValues(nil))
+	assert.False(t, a.SameValues(snapshot(5, 2)))
+}
+
+func TestOutputHistory(t *testing.T) {
+	vpc := func(v string) *StateOutput { return &StateOutput{"vpc_id", "string", false, v} }
+	other := &StateOutput{"other", "string", false, "x"}
+
+	// out of order, with unchanged and missing values
+	snapshots := []*OutputSnapshot{
+		snapshot(20, 4, vpc("vpc-2")),
+		snapshot(0, 1, vpc("vpc-1")),
+		snapshot(5, 2, vpc("vpc-1"), other),
+		snapshot(10, 3, vpc("vpc-2")),
+		snapshot(25, 5, other),
+		snapshot(30, 6, vpc("vpc-2")),
+	}
+
+	history := OutputHistory(snapshots, "vpc_id")
+
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, "vpc-1", history[0].Value)
+	assert.Equal(t, int64(1), history[0].Serial)
+	assert.Equal(t, "vpc-2", history[1].Value)
+	assert.Equal(t, int64(3), history[1].Serial)
+	assert.Equal(t, int64(6), history[2].Serial)
+
+	assert.Equal(t, 0, len(OutputHistory(snapshots, "missing")))
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+)
+
+const (
+	// PlanFile is the name of the plan file written by `terraform plan -out`
+	PlanFile = "terraform.tfplan"
+
+	// JSONPlanFile is the name of the file containing the `terraform show -json` output of PlanFile
+	JSONPlanFile = "terraform.tfplan.json"
+)
+
+// Actions a ResourceChange can describe
+const (
+	ActionCreate   = "Create"
+	ActionRecreate = "Recreate"
+	ActionDestroy  = "Destroy"
+	ActionUpdate   = "Update"
+)
+
+// planHeaderSize is the number of leading bytes handed to PlanReader.Detect
+const planHeaderSize = 512
+
+// PlanReader parses one on-disk plan format into a Plan
+type PlanReader interface {
+	// Detect returns true if the reader understands a plan starting with header
+	Detect(header []byte) bool
+
+	// Read parses the plan
+	Read(r io.Reader) (*Plan, error)
+}
+
+// planReaders are tried in order, the first to detect the format reads the plan
+var planReaders = []PlanReader{
+	&jsonPlanReader{},
+	&legacyPlanReader{},
+}
+
+// Plan is the normalized form of a Terraform plan, independent of the format it was read from
+type Plan struct {
+	Format           string
+	TerraformVersion string
+	changes          []*ResourceChange
+}
+
+// newPlan creates a plan with its changes in a stable order
+func newPlan(format, version string, changes []*ResourceChange) *Plan {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].ResourceID < changes[j].ResourceID
+	})
+	return &Plan{
+		Format:           format,
+		TerraformVersion: version,
+		changes:          changes,
+	}
+}
+
+// ResourceChanges returns the changes in a plan
+func (p *Plan) ResourceChanges() []*ResourceChange {
+	return p.changes
+}
+
+// ReadPlan detects the format of a plan and reads it with the matching PlanReader
+func ReadPlan(r io.Reader) (*Plan, error) {
+	buf := bufio.NewReaderSize(r, planHeaderSize)
+
+	// a short plan is not an error here, the reader will report it
+	header, err := buf.Peek(planHeaderSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	for _, reader := range planReaders {
+		if reader.Detect(header) {
+			return reader.Read(buf)
+		}
+	}
+
+	return nil, fmt.Errorf("Unrecognized plan format")
+}
+
+// Plan returns the Terraform plan for a project. The JSON rendering of the plan is preferred
+// when it exists, since it is readable regardless of the Terraform version that wrote it.
+func (prj *Project) Plan() (*Plan, error) {
+	planFile := path.Join(prj.LocalPath, JSONPlanFile)
+	if _, err := os.Stat(planFile); err != nil {
+		planFile = path.Join(prj.LocalPath, PlanFile)
+	}
+
+	// Open the path no matter if its a directory or file
+	f, err := os.Open(planFile)
+	defer func() {
+		log.Printf("[DEBUG] Closing plan file '%s'", planFile)
+		f.Close()
+	}()
+
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Failed to load Terraform configuration or plan: %s", err)
+	}
+
+	// Stat it so we can check if its a directory
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Failed to load Terraform configuration or plan: %s", err)
+	}
+
+	// If this path is a directory, then it can't be a plan. Not an error.
+	if fi.IsDir() {
+		return nil, fmt.Errorf(
+			"Failed to load plan '%s', expected plan file, found directory", planFile)
+	}
+
+	// Read the plan
+	log.Printf("[DEBUG] Reading plan from file '%s'", planFile)
+	p, err := ReadPlan(f)
+	log.Printf("[DEBUG] Plan read from file '%s'", planFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// jsonPlanReader reads the output of `terraform show -json <planfile>`
+type jsonPlanReader struct{}
+
+// jsonPlan is the subset of the JSON plan representation tfwatch relies on
+type jsonPlan struct {
+	FormatVersion    string `json:"format_version"`
+	TerraformVersion string `json:"terraform_version"`
+	ResourceChanges  []struct {
+		Address       string          `json:"address"`
+		ModuleAddress string          `json:"module_address"`
+		Mode          string          `json:"mode"`
+		Type          string          `json:"type"`
+		Name          string          `json:"name"`
+		Index         json.RawMessage `json:"index"`
+		Change        struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// moduleAddressStep matches one "module.name[key]" step of a module address
+var moduleAddressStep = regexp.MustCompile(`module\.([^.\[]+)(\[[^\]]*\])?`)
+
+// Detect checks for a JSON object
+func (j *jsonPlanReader) Detect(header []byte) bool {
+	trimmed := bytes.TrimLeft(header, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// Read decodes a plan in format_version 0.x or 1.x
+func (j *jsonPlanReader) Read(r io.Reader) (*Plan, error) {
+	var p jsonPlan
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON plan: %s", err)
+	}
+
+	if !strings.HasPrefix(p.FormatVersion, "0.") && !strings.HasPrefix(p.FormatVersion, "1.") {
+		return nil, fmt.Errorf("Unsupported JSON plan format_version '%s'", p.FormatVersion)
+	}
+
+	var changes []*ResourceChange
+	for _, rc := range p.ResourceChanges {
+		action := jsonPlanAction(rc.Change.Actions)
+		if action == "" {
+			log.Printf("[DEBUG] Skipping '%s' with actions %v", rc.Address, rc.Change.Actions)
+			continue
+		}
+
+		modulePath := jsonModulePath(rc.ModuleAddress)
+
+		id := fmt.Sprintf("%s.%s.%s", strings.Join(modulePath, "."), rc.Type, rc.Name)
+		if rc.Mode == "data" {
+			id = fmt.Sprintf("%s.data.%s.%s", strings.Join(modulePath, "."), rc.Type, rc.Name)
+		}
+		id += jsonIndexSuffix(rc.Index)
+
+		changes = append(changes, &ResourceChange{
+			ResourceID: id,
+			Action:     action,
+			Type:       rc.Type,
+			Name:       rc.Name,
+			ModulePath: modulePath,
+		})
+	}
+
+	return newPlan("json-"+p.FormatVersion, p.TerraformVersion, changes), nil
+}
+
+// jsonPlanAction maps the action list of a JSON resource change to a ResourceChange action,
+// empty is returned for changes that do not modify infrastructure
+func jsonPlanAction(actions []string) string {
+	switch strings.Join(actions, ",") {
+	case "create":
+		return ActionCreate
+	case "update":
+		return ActionUpdate
+	case "delete":
+		return ActionDestroy
+	case "delete,create", "create,delete":
+		return ActionRecreate
+	}
+	return ""
+}
+
+// jsonModulePath converts "module.a.module.b" into the legacy module path ["root", "a", "b"]
+func jsonModulePath(address string) []string {
+	modulePath := []string{"root"}
+	for _, step := range moduleAddressStep.FindAllStringSubmatch(address, -1) {
+		modulePath = append(modulePath, step[1]+step[2])
+	}
+	return modulePath
+}
+
+// jsonIndexSuffix renders a count index as ".0", matching legacy ids, and a for_each key as `["key"]`
+func jsonIndexSuffix(index json.RawMessage) string {
+	if len(index) == 0 || string(index) == "null" {
+		return ""
+	}
+
+	var n int
+	if err := json.Unmarshal(index, &n); err == nil {
+		return fmt.Sprintf(".%d", n)
+	}
+
+	return fmt.Sprintf("[%s]", index)
+}
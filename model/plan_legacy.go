@@ -0,0 +1,66 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// legacyPlanMagic prefixes the binary plans written by Terraform 0.9
+const legacyPlanMagic = "tfplan"
+
+// legacyPlanReader reads the binary plan format written by `terraform plan -out` prior to 0.12
+type legacyPlanReader struct{}
+
+// Detect checks for the binary plan magic bytes
+func (l *legacyPlanReader) Detect(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(legacyPlanMagic))
+}
+
+// Read decodes the plan using the vendored terraform package
+func (l *legacyPlanReader) Read(r io.Reader) (*Plan, error) {
+	p, err := terraform.ReadPlan(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []*ResourceChange
+	if p.Diff != nil {
+		for _, module := range p.Diff.Modules {
+			for id, res := range module.Resources {
+				change := res.ChangeType()
+				if change == terraform.DiffNone {
+					continue
+				}
+
+				var changeStr string
+				switch change {
+				case terraform.DiffCreate:
+					changeStr = ActionCreate
+				case terraform.DiffDestroyCreate:
+					changeStr = ActionRecreate
+				case terraform.DiffDestroy:
+					changeStr = ActionDestroy
+				case terraform.DiffUpdate:
+					changeStr = ActionUpdate
+				}
+
+				rc := &ResourceChange{
+					ResourceID: fmt.Sprintf("%s.%s", strings.Join(module.Path, "."), id),
+					Action:     changeStr,
+					ModulePath: module.Path,
+				}
+				if key, err := terraform.ParseResourceStateKey(id); err == nil {
+					rc.Type = key.Type
+					rc.Name = key.Name
+				}
+				changes = append(changes, rc)
+			}
+		}
+	}
+
+	return newPlan("legacy", "", changes), nil
+}
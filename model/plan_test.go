@@ -0,0 +1,83 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var jsonPlanned = Project{
+	GUID:      "2222-2222",
+	Name:      "terraform_planned_json",
+	LocalPath: "../fixtures/terraform_planned_json",
+}
+
+var jsonPlannedV0 = Project{
+	GUID:      "3333-3333",
+	Name:      "terraform_planned_json_v0",
+	LocalPath: "../fixtures/terraform_planned_json_v0",
+}
+
+func TestPlan_legacy_format(t *testing.T) {
+	plan, err := data[1].Plan()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy", plan.Format)
+
+	change := plan.ResourceChanges()[0]
+	assert.Equal(t, "local_file", change.Type)
+	assert.Equal(t, "file", change.Name)
+	assert.Equal(t, []string{"root"}, change.ModulePath)
+}
+
+func TestPlan_json(t *testing.T) {
+	plan, err := jsonPlanned.Plan()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "json-1.2", plan.Format)
+	assert.Equal(t, "1.5.7", plan.TerraformVersion)
+
+	changes := plan.ResourceChanges()
+	assert.Equal(t, 4, len(changes))
+
+	expected := []ResourceChange{
+		{"root.local_file.config.0", ActionUpdate, "local_file", "config", []string{"root"}},
+		{"root.local_file.file", ActionRecreate, "local_file", "file", []string{"root"}},
+		{"root.local_file.legacy", ActionDestroy, "local_file", "legacy", []string{"root"}},
+		{"root.network.aws_vpc.main", ActionCreate, "aws_vpc", "main", []string{"root", "network"}},
+	}
+	for i, e := range expected {
+		assert.Equal(t, e, *changes[i])
+	}
+}
+
+func TestPlan_json_v0(t *testing.T) {
+	plan, err := jsonPlannedV0.Plan()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "json-0.1", plan.Format)
+	assert.Equal(t, 1, len(plan.ResourceChanges()))
+
+	change := plan.ResourceChanges()[0]
+	assert.Equal(t, "root.local_file.file", change.ResourceID)
+	assert.Equal(t, ActionCreate, change.Action)
+}
+
+func TestReadPlan_unsupported_json_version(t *testing.T) {
+	_, err := ReadPlan(strings.NewReader(`{"format_version": "2.0", "resource_changes": []}`))
+
+	assert.Error(t, err)
+}
+
+func TestReadPlan_unrecognized(t *testing.T) {
+	_, err := ReadPlan(strings.NewReader("not a plan"))
+
+	assert.Error(t, err)
+}
+
+func TestJSONModulePath(t *testing.T) {
+	assert.Equal(t, []string{"root"}, jsonModulePath(""))
+	assert.Equal(t, []string{"root", "a", "b"}, jsonModulePath("module.a.module.b"))
+	assert.Equal(t, []string{"root", "a[0]", "b[\"x\"]"}, jsonModulePath("module.a[0].module.b[\"x\"]"))
+}
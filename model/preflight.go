@@ -0,0 +1,121 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+)
+
+// VariableSource identifies where a variable's value comes from
+type VariableSource string
+
+// Sources of variable values checked before planning
+const (
+	VariableSourceSettings    VariableSource = "settings"
+	VariableSourceTFVars      VariableSource = "tfvars"
+	VariableSourceEnvironment VariableSource = "environment"
+	VariableSourceGlobal      VariableSource = "global"
+	VariableSourceSet         VariableSource = "set"
+	VariableSourceProject     VariableSource = "project"
+	VariableSourceOverride    VariableSource = "override"
+)
+
+// envVarPrefix prefixes environment variables terraform reads variable values from
+const envVarPrefix = "TF_VAR_"
+
+// TFVarsFiles returns the tfvars files terraform loads automatically from the project directory
+func (prj *Project) TFVarsFiles() ([]string, error) {
+	var files []string
+	for _, name := range []string{"terraform.tfvars", "terraform.tfvars.json"} {
+		f := path.Join(prj.LocalPath, name)
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+
+	for _, pattern := range []string{"*.auto.tfvars", "*.auto.tfvars.json"} {
+		matches, err := filepath.Glob(path.Join(prj.LocalPath, pattern))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+// ProvidedVariables returns the variables the project provides a value for, and where the value
+// comes from. Environment is in the "key=value" form of os.Environ.
+func (prj *Project) ProvidedVariables(environ []string) (map[string]VariableSource, error) {
+	provided := make(map[string]VariableSource)
+
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envVarPrefix) {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(kv, envVarPrefix), "=", 2)[0]
+		provided[name] = VariableSourceEnvironment
+	}
+
+	files, err := prj.TFVarsFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var vars map[string]interface{}
+		if err := hcl.Unmarshal(b, &vars); err != nil {
+			return nil, fmt.Errorf("Error parsing '%s': %s", f, err)
+		}
+
+		for name := range vars {
+			provided[name] = VariableSourceTFVars
+		}
+	}
+
+	for name := range prj.Settings {
+		provided[name] = VariableSourceSettings
+	}
+
+	return provided, nil
+}
+
+// MissingVariables returns the names of required variables that are not provided
+func (pc *ProjectConfig) MissingVariables(provided map[string]VariableSource) []string {
+	missing := []string{}
+	for _, v := range pc.Variables {
+		if _, ok := provided[v.Name]; v.Required && !ok {
+			missing = append(missing, v.Name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// DeclaredSettings returns the settings of the project that are declared variables
+func (pc *ProjectConfig) DeclaredSettings(settings map[string]string) map[string]string {
+	declared := make(map[string]string)
+	for _, v := range pc.Variables {
+		if value, ok := settings[v.Name]; ok {
+			declared[v.Name] = value
+		}
+	}
+	return declared
+}
+
+// VariableEnvironmentName returns the environment variable terraform reads the named variable from
+func VariableEnvironmentName(name string) string {
+	return envVarPrefix + name
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvidedVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tfwatch-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "terraform.tfvars"), []byte(`region = "us-east-1"`), 0644)
+	ioutil.WriteFile(path.Join(dir, "tags.auto.tfvars.json"), []byte(`{"tags": {"team": "infra"}}`), 0644)
+
+	prj := &Project{
+		LocalPath: dir,
+		Settings:  map[string]string{"contents": "bar"},
+	}
+
+	provided, err := prj.ProvidedVariables([]string{"PATH=/bin", "TF_VAR_account=1234", "TF_VAR_x=a=b"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]VariableSource{
+		"account":  VariableSourceEnvironment,
+		"x":        VariableSourceEnvironment,
+		"region":   VariableSourceTFVars,
+		"tags":     VariableSourceTFVars,
+		"contents": VariableSourceSettings,
+	}, provided)
+}
+
+func TestProvidedVariables_invalid_tfvars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tfwatch-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "terraform.tfvars"), []byte(`region = "unterminated`), 0644)
+
+	_, err = (&Project{LocalPath: dir}).ProvidedVariables(nil)
+	assert.Error(t, err)
+}
+
+func TestMissingVariables(t *testing.T) {
+	cfg, err := data[1].Config()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"contents"}, cfg.MissingVariables(map[string]VariableSource{}))
+	assert.Equal(t, []string{}, cfg.MissingVariables(map[string]VariableSource{"contents": VariableSourceEnvironment}))
+}
+
+func TestDeclaredSettings(t *testing.T) {
+	cfg, err := data[1].Config()
+	assert.Nil(t, err)
+
+	declared := cfg.DeclaredSettings(map[string]string{"contents": "bar", "not_a_variable": "x"})
+	assert.Equal(t, map[string]string{"contents": "bar"}, declared)
+}
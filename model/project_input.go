@@ -0,0 +1,66 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProjectInput holds the fields of a project edited through the API, every other field is managed by
+// tfwatch. Path is the directory of a new project relative to the checkout directory, defaulting to its
+// name, the directory of a project cannot be changed. Revision is the revision of the stored project an
+// update is based on.
+type ProjectInput struct {
+	Name         string            `json:"name"`
+	Path         string            `json:"path,omitempty"`
+	Settings     map[string]string `json:"settings,omitempty"`
+	VariableSets []string          `json:"variable_sets,omitempty"`
+	Credentials  []string          `json:"credentials,omitempty"`
+	Revision     uint64            `json:"revision,omitempty"`
+}
+
+// Input returns the editable fields of the project
+func (prj *Project) Input() *ProjectInput {
+	return &ProjectInput{
+		Name:         prj.Name,
+		Settings:     prj.Settings,
+		VariableSets: prj.VariableSets,
+		Credentials:  prj.Credentials,
+		Revision:     prj.Revision,
+	}
+}
+
+// Apply sets the editable fields of a project from the input
+func (in *ProjectInput) Apply(prj *Project) {
+	prj.Name = in.Name
+	prj.Settings = in.Settings
+	prj.VariableSets = in.VariableSets
+	prj.Credentials = in.Credentials
+}
+
+// Validate checks the fields of the input, references to variable sets and credentials are checked by
+// the controller
+func (in *ProjectInput) Validate() error {
+	if strings.TrimSpace(in.Name) == "" {
+		return fmt.Errorf("A project name is required")
+	}
+	if strings.TrimSpace(in.Name) != in.Name || strings.ContainsAny(in.Name, "/\\") {
+		return fmt.Errorf("Invalid project name '%s'", in.Name)
+	}
+
+	for name := range in.Settings {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("Settings must have a name")
+		}
+	}
+
+	for field, guids := range map[string][]string{"variable set": in.VariableSets, "credential profile": in.Credentials} {
+		seen := map[string]bool{}
+		for _, guid := range guids {
+			if guid == "" || seen[guid] {
+				return fmt.Errorf("Invalid %s '%s', each must be given once", field, guid)
+			}
+			seen[guid] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// StateFile is the name of the state file written by the local backend
+const StateFile = "terraform.tfstate"
+
+// SensitiveMask replaces sensitive values returned from the API
+const SensitiveMask = "<sensitive>"
+
+// sensitiveAttributes are fragments of attribute names whose values are masked. Terraform does not
+// record the sensitivity of attributes in state, so this is a best effort.
+var sensitiveAttributes = []string{"password", "secret", "token", "private_key", "access_key"}
+
+// State wraps a terraform.State to expose it to the API
+type State struct {
+	state *terraform.State
+}
+
+// StateInfo identifies a state
+type StateInfo struct {
+	Version          int    `json:"version"`
+	TerraformVersion string `json:"terraform_version"`
+	Serial           int64  `json:"serial"`
+	Lineage          string `json:"lineage"`
+	ResourceCount    int    `json:"resource_count"`
+}
+
+// StateResource is a resource in state, all instances created with count are grouped
+type StateResource struct {
+	Address       string `json:"address"`
+	Mode          string `json:"mode"`
+	Type          string `json:"type"`
+	Name          string `json:"name"`
+	Module        string `json:"module"`
+	Provider      string `json:"provider"`
+	InstanceCount int    `json:"instance_count"`
+}
+
+// StateInstance is a single instance of a resource in state
+type StateInstance struct {
+	Index      int               `json:"index"`
+	ID         string            `json:"id"`
+	Tainted    bool              `json:"tainted"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// StateResourceDetail is a resource and the attributes of its instances
+type StateResourceDetail struct {
+	StateResource
+	DependsOn []string        `json:"depends_on"`
+	Instances []StateInstance `json:"instances"`
+}
+
+// StateOutput is an output of the root module
+type StateOutput struct {
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`
+	Sensitive bool        `json:"sensitive"`
+	Value     interface{} `json:"value"`
+}
+
+// ReadState reads a state file
+func ReadState(r io.Reader) (*State, error) {
+	s, err := terraform.ReadState(r)
+	if err != nil {
+		return nil, err
+	}
+	return &State{s}, nil
+}
+
+// Info returns the identifying information of the state
+func (s *State) Info() *StateInfo {
+	return &StateInfo{
+		Version:          s.state.Version,
+		TerraformVersion: s.state.TFVersion,
+		Serial:           s.state.Serial,
+		Lineage:          s.state.Lineage,
+		ResourceCount:    len(s.Resources()),
+	}
+}
+
+// Resources lists the resources in the state ordered by address
+func (s *State) Resources() []*StateResource {
+	details := s.resources()
+	resources := make([]*StateResource, len(details))
+	for i, d := range details {
+		resources[i] = &d.StateResource
+	}
+	return resources
+}
+
+// Resource returns the resource with the address, sensitive attributes are masked. Nil is returned
+// when no resource has the address.
+func (s *State) Resource(address string) *StateResourceDetail {
+	for _, d := range s.resources() {
+		if d.Address == address {
+			return d
+		}
+	}
+	return nil
+}
+
+// Outputs returns the outputs of the root module ordered by name, sensitive values are masked
+func (s *State) Outputs() []*StateOutput {
+	outputs := []*StateOutput{}
+
+	root := s.state.RootModule()
+	if root == nil {
+		return outputs
+	}
+
+	for name, o := range root.Outputs {
+		output := &StateOutput{
+			Name:      name,
+			Type:      o.Type,
+			Sensitive: o.Sensitive,
+			Value:     o.Value,
+		}
+		if o.Sensitive {
+			output.Value = SensitiveMask
+		}
+		outputs = append(outputs, output)
+	}
+
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].Name < outputs[j].Name
+	})
+
+	return outputs
+}
+
+// resources groups the instances of every module's resources by address
+func (s *State) resources() []*StateResourceDetail {
+	byAddress := make(map[string]*StateResourceDetail)
+
+	for _, module := range s.state.Modules {
+		for k, rs := range module.Resources {
+			key, err := terraform.ParseResourceStateKey(k)
+			if err != nil {
+				continue
+			}
+
+			address := resourceAddress(module.Path, key)
+			d, ok := byAddress[address]
+			if !ok {
+				d = &StateResourceDetail{
+					StateResource: StateResource{
+						Address:  address,
+						Mode:     "managed",
+						Type:     key.Type,
+						Name:     key.Name,
+						Module:   strings.Join(module.Path, "."),
+						Provider: resourceProvider(key.Type, rs.Provider),
+					},
+					DependsOn: rs.Dependencies,
+					Instances: []StateInstance{},
+				}
+				if key.Mode == config.DataResourceMode {
+					d.Mode = "data"
+				}
+				byAddress[address] = d
+			}
+
+			if rs.Primary != nil {
+				d.Instances = append(d.Instances, StateInstance{
+					Index:      key.Index,
+					ID:         rs.Primary.ID,
+					Tainted:    rs.Primary.Tainted,
+					Attributes: maskAttributes(rs.Primary.Attributes),
+				})
+			}
+		}
+	}
+
+	details := make([]*StateResourceDetail, 0, len(byAddress))
+	for _, d := range byAddress {
+		d.InstanceCount = len(d.Instances)
+		sort.Slice(d.Instances, func(i, j int) bool {
+			return d.Instances[i].Index < d.Instances[j].Index
+		})
+		details = append(details, d)
+	}
+
+	sort.Slice(details, func(i, j int) bool {
+		return details[i].Address < details[j].Address
+	})
+
+	return details
+}
+
+// resourceAddress renders the address of a resource as terraform does, e.g. "module.network.aws_vpc.main"
+func resourceAddress(modulePath []string, key *terraform.ResourceStateKey) string {
+	var parts []string
+	for _, name := range modulePath[1:] {
+		parts = append(parts, "module", name)
+	}
+	if key.Mode == config.DataResourceMode {
+		parts = append(parts, "data")
+	}
+	parts = append(parts, key.Type, key.Name)
+	return strings.Join(parts, ".")
+}
+
+// resourceProvider returns the provider of a resource, which is implied by the type unless aliased
+func resourceProvider(resourceType, provider string) string {
+	if provider != "" {
+		return provider
+	}
+	return strings.SplitN(resourceType, "_", 2)[0]
+}
+
+// maskAttributes copies attributes, replacing values that look sensitive
+func maskAttributes(attrs map[string]string) map[string]string {
+	masked := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		masked[k] = v
+		for _, sensitive := range sensitiveAttributes {
+			if strings.Contains(strings.ToLower(k), sensitive) {
+				masked[k] = SensitiveMask
+				break
+			}
+		}
+	}
+	return masked
+}
+
+// StateNotFoundError is returned when a project has no state
+type StateNotFoundError struct {
+	Project string
+}
+
+func (e *StateNotFoundError) Error() string {
+	return fmt.Sprintf("No state found for project '%s'", e.Project)
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readFixtureState(t *testing.T) *State {
+	f, err := os.Open("../fixtures/terraform_applied/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	state, err := ReadState(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestState_info(t *testing.T) {
+	info := readFixtureState(t).Info()
+
+	assert.Equal(t, 3, info.Version)
+	assert.Equal(t, "0.9.11", info.TerraformVersion)
+	assert.Equal(t, int64(7), info.Serial)
+	assert.Equal(t, "6b2e2f3e-4f1c-4c5a-9d3e-2f6f1b0e8a41", info.Lineage)
+	assert.Equal(t, 3, info.ResourceCount)
+}
+
+func TestState_resources(t *testing.T) {
+	resources := readFixtureState(t).Resources()
+
+	assert.Equal(t, 3, len(resources))
+	assert.Equal(t, StateResource{"data.template_file.motd", "data", "template_file", "motd", "root", "template", 1}, *resources[0])
+	assert.Equal(t, StateResource{"local_file.file", "managed", "local_file", "file", "root", "local", 2}, *resources[1])
+	assert.Equal(t, StateResource{"module.network.aws_vpc.main", "managed", "aws_vpc", "main", "root.network", "aws.west", 1}, *resources[2])
+}
+
+func TestState_resource(t *testing.T) {
+	state := readFixtureState(t)
+
+	file := state.Resource("local_file.file")
+	assert.Equal(t, 2, len(file.Instances))
+	assert.Equal(t, 0, file.Instances[0].Index)
+	assert.Equal(t, "foo-1", file.Instances[1].Attributes["filename"])
+	assert.True(t, file.Instances[1].Tainted)
+
+	vpc := state.Resource("module.network.aws_vpc.main")
+	assert.Equal(t, "10.0.0.0/16", vpc.Instances[0].Attributes["cidr_block"])
+	assert.Equal(t, SensitiveMask, vpc.Instances[0].Attributes["tags.api_token"])
+
+	assert.Nil(t, state.Resource("local_file.missing"))
+}
+
+func TestState_outputs(t *testing.T) {
+	outputs := readFixtureState(t).Outputs()
+
+	assert.Equal(t, 2, len(outputs))
+	assert.Equal(t, StateOutput{"db_password", "string", true, SensitiveMask}, *outputs[0])
+	assert.Equal(t, StateOutput{"vpc_id", "string", false, "vpc-0a1b2c3d"}, *outputs[1])
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChangeCounts tallies resource changes by action
+type ChangeCounts struct {
+	Add     int `json:"add"`
+	Change  int `json:"change"`
+	Destroy int `json:"destroy"`
+	Replace int `json:"replace"`
+}
+
+// Total returns the number of resources changed
+func (c ChangeCounts) Total() int {
+	return c.Add + c.Change + c.Destroy + c.Replace
+}
+
+// Badge renders the counts as "+3 ~1 -2". As in the terraform plan output, a replacement is
+// counted as both an add and a destroy.
+func (c ChangeCounts) Badge() string {
+	return fmt.Sprintf("+%d ~%d -%d", c.Add+c.Replace, c.Change, c.Destroy+c.Replace)
+}
+
+// count adds a single change to the tally
+func (c *ChangeCounts) count(action string) {
+	switch action {
+	case ActionCreate:
+		c.Add++
+	case ActionUpdate:
+		c.Change++
+	case ActionDestroy:
+		c.Destroy++
+	case ActionRecreate:
+		c.Replace++
+	}
+}
+
+// ChangeTrend is the difference between a plan's counts and those of the last plan with other counts.
+// Plans run on a schedule, so the plan before one usually has the same counts.
+type ChangeTrend struct {
+	Add       int    `json:"add"`
+	Change    int    `json:"change"`
+	Destroy   int    `json:"destroy"`
+	Replace   int    `json:"replace"`
+	Total     int    `json:"total"`
+	Direction string `json:"direction"`
+}
+
+// Trend directions
+const (
+	TrendUp   = "up"
+	TrendDown = "down"
+	TrendFlat = "flat"
+)
+
+// PlanSummary contains statistics computed from the pending changes of a plan
+type PlanSummary struct {
+	ChangeCounts
+	Badge    string                  `json:"badge"`
+	ByType   map[string]ChangeCounts `json:"by_type"`
+	ByModule map[string]ChangeCounts `json:"by_module"`
+	Trend    *ChangeTrend            `json:"trend,omitempty"`
+}
+
+// Summarize computes the summary of a set of changes. When the summary of the previous plan is
+// given, the trend is computed against it, unless the counts are the same, when the previous plan's
+// trend is kept.
+func Summarize(changes []ResourceChange, previous *PlanSummary) *PlanSummary {
+	s := &PlanSummary{
+		ByType:   make(map[string]ChangeCounts),
+		ByModule: make(map[string]ChangeCounts),
+	}
+
+	for _, change := range changes {
+		s.count(change.Action)
+
+		byType := s.ByType[change.Type]
+		byType.count(change.Action)
+		s.ByType[change.Type] = byType
+
+		module := change.Module()
+		byModule := s.ByModule[module]
+		byModule.count(change.Action)
+		s.ByModule[module] = byModule
+	}
+
+	s.Badge = s.ChangeCounts.Badge()
+
+	if previous != nil && previous.ChangeCounts == s.ChangeCounts {
+		s.Trend = previous.Trend
+	} else if previous != nil {
+		s.Trend = &ChangeTrend{
+			Add:     s.Add - previous.Add,
+			Change:  s.Change - previous.Change,
+			Destroy: s.Destroy - previous.Destroy,
+			Replace: s.Replace - previous.Replace,
+			Total:   s.Total() - previous.Total(),
+		}
+		switch {
+		case s.Trend.Total > 0:
+			s.Trend.Direction = TrendUp
+		case s.Trend.Total < 0:
+			s.Trend.Direction = TrendDown
+		default:
+			s.Trend.Direction = TrendFlat
+		}
+	}
+
+	return s
+}
+
+// Module returns the module path of the change as "root.module", changes recorded before module
+// paths were tracked are attributed to the root module
+func (rc ResourceChange) Module() string {
+	if len(rc.ModulePath) == 0 {
+		return "root"
+	}
+	return strings.Join(rc.ModulePath, ".")
+}
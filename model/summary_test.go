@@ -0,0 +1,61 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var summaryChanges = []ResourceChange{
+	{"root.local_file.a", ActionCreate, "local_file", "a", []string{"root"}},
+	{"root.local_file.b", ActionCreate, "local_file", "b", []string{"root"}},
+	{"root.local_file.c", ActionUpdate, "local_file", "c", []string{"root"}},
+	{"root.network.aws_vpc.main", ActionRecreate, "aws_vpc", "main", []string{"root", "network"}},
+	{"root.network.aws_subnet.a", ActionDestroy, "aws_subnet", "a", []string{"root", "network"}},
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize(summaryChanges, nil)
+
+	assert.Equal(t, ChangeCounts{Add: 2, Change: 1, Destroy: 1, Replace: 1}, s.ChangeCounts)
+	assert.Equal(t, "+3 ~1 -2", s.Badge)
+	assert.Equal(t, ChangeCounts{Add: 2, Change: 1}, s.ByType["local_file"])
+	assert.Equal(t, ChangeCounts{Replace: 1}, s.ByType["aws_vpc"])
+	assert.Equal(t, ChangeCounts{Add: 2, Change: 1}, s.ByModule["root"])
+	assert.Equal(t, ChangeCounts{Destroy: 1, Replace: 1}, s.ByModule["root.network"])
+	assert.Nil(t, s.Trend)
+}
+
+func TestSummarize_empty(t *testing.T) {
+	s := Summarize(nil, nil)
+
+	assert.Equal(t, "+0 ~0 -0", s.Badge)
+	assert.Equal(t, 0, s.Total())
+}
+
+func TestSummarize_trend(t *testing.T) {
+	previous := Summarize(summaryChanges[:2], nil)
+	s := Summarize(summaryChanges, previous)
+
+	assert.Equal(t, &ChangeTrend{Change: 1, Destroy: 1, Replace: 1, Total: 3, Direction: TrendUp}, s.Trend)
+
+	s = Summarize(summaryChanges[:1], s)
+	assert.Equal(t, TrendDown, s.Trend.Direction)
+	assert.Equal(t, -4, s.Trend.Total)
+
+	// plans with the same counts keep the trend of the last change
+	s = Summarize(summaryChanges[:1], s)
+	assert.Equal(t, TrendDown, s.Trend.Direction)
+	assert.Equal(t, -4, s.Trend.Total)
+
+	// the same total with other counts is flat
+	s = Summarize(summaryChanges[2:3], s)
+	assert.Equal(t, TrendFlat, s.Trend.Direction)
+
+	// a plan with the same counts as the first has no trend
+	assert.Nil(t, Summarize(summaryChanges[:1], Summarize(summaryChanges[:1], nil)).Trend)
+}
+
+func TestResourceChange_module_defaults_to_root(t *testing.T) {
+	assert.Equal(t, "root", ResourceChange{ResourceID: "root.local_file.a"}.Module())
+}
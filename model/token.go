@@ -0,0 +1,100 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenScope is what a token allows its bearer to do
+type TokenScope string
+
+// Scopes of tokens, each allowing what the scopes before it allow
+const (
+	ScopeRead  TokenScope = "read"
+	ScopePlan  TokenScope = "plan"
+	ScopeApply TokenScope = "apply"
+	ScopeAdmin TokenScope = "admin"
+)
+
+// tokenScopes are the scopes in the order they grant access
+var tokenScopes = []TokenScope{ScopeRead, ScopePlan, ScopeApply, ScopeAdmin}
+
+// ParseScopes parses a comma separated list of scopes
+func ParseScopes(list string) ([]TokenScope, error) {
+	scopes := []TokenScope{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			scopes = append(scopes, TokenScope(name))
+		}
+	}
+	return scopes, validScopes(scopes)
+}
+
+// validScopes checks that every scope is known
+func validScopes(scopes []TokenScope) error {
+	for _, s := range scopes {
+		if s.rank() < 0 {
+			return fmt.Errorf("Unknown scope '%s', expected one of read, plan, apply, admin", s)
+		}
+	}
+	return nil
+}
+
+// allows returns true when one of the scopes allows what the scope does
+func allows(scopes []TokenScope, scope TokenScope) bool {
+	for _, s := range scopes {
+		if s.rank() >= scope.rank() && scope.rank() >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rank returns the position of the scope in the order scopes grant access, -1 for unknown scopes
+func (s TokenScope) rank() int {
+	for i, known := range tokenScopes {
+		if s == known {
+			return i
+		}
+	}
+	return -1
+}
+
+// Token is an API token. Its secret is only known when the token is created, tfwatch stores a hash of
+// it. Scopes are cumulative: plan allows reading, apply allows planning, and admin allows everything.
+// A token without an expiry never expires.
+type Token struct {
+	GUID    string       `json:"guid,omitempty"`
+	Name    string       `json:"name"`
+	Scopes  []TokenScope `json:"scopes"`
+	Created time.Time    `json:"created"`
+	Expires time.Time    `json:"expires,omitempty"`
+	Secret  string       `json:"token,omitempty"`
+}
+
+// Validate checks the token has a name and known scopes
+func (t *Token) Validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("Token name is required")
+	}
+	if len(t.Scopes) == 0 {
+		return fmt.Errorf("Token '%s' has no scopes", t.Name)
+	}
+	return validScopes(t.Scopes)
+}
+
+// Allows returns true when one of the token's scopes allows what the scope does
+func (t *Token) Allows(scope TokenScope) bool {
+	return allows(t.Scopes, scope)
+}
+
+// Identity returns the identity of the token's bearer
+func (t *Token) Identity() *Identity {
+	return &Identity{Name: t.Name, Kind: IdentityToken, Scopes: t.Scopes}
+}
+
+// Expired returns true when the token has expired at a time
+func (t *Token) Expired(now time.Time) bool {
+	return !t.Expires.IsZero() && !now.Before(t.Expires)
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of identities
+const (
+	IdentityUser  = "user"
+	IdentityToken = "token"
+)
+
+// Identity is who makes a request, a user or the bearer of a token, and the scopes it is allowed
+type Identity struct {
+	Name   string       `json:"name"`
+	Kind   string       `json:"kind"`
+	Scopes []TokenScope `json:"scopes"`
+}
+
+// Allows returns true when one of the identity's scopes allows what the scope does
+func (i *Identity) Allows(scope TokenScope) bool {
+	return allows(i.Scopes, scope)
+}
+
+// String returns the name recorded for the identity, the name of a user, or token: and the name of a
+// token
+func (i *Identity) String() string {
+	if i.Kind == IdentityToken {
+		return IdentityToken + ":" + i.Name
+	}
+	return i.Name
+}
+
+// MinPasswordLength is the length of the shortest password accepted
+const MinPasswordLength = 8
+
+// User is a local user account. Its role is one of the token scopes, and allows what a token with the
+// scope allows. The password is only set when a user is created or its password is changed, tfwatch
+// stores a bcrypt hash of it.
+type User struct {
+	GUID     string     `json:"guid,omitempty"`
+	Username string     `json:"username"`
+	Role     TokenScope `json:"role"`
+	Created  time.Time  `json:"created"`
+	Password string     `json:"password,omitempty"`
+}
+
+// Validate checks the user has a username, a known role and a long enough password
+func (u *User) Validate() error {
+	if strings.TrimSpace(u.Username) == "" {
+		return fmt.Errorf("Username is required")
+	}
+	if strings.TrimSpace(u.Username) != u.Username || strings.ContainsAny(u.Username, ":/\\") {
+		return fmt.Errorf("Invalid username '%s', usernames cannot contain spaces at either end, ':', '/' or '\\'", u.Username)
+	}
+	if err := validScopes([]TokenScope{u.Role}); err != nil {
+		return err
+	}
+	return ValidPassword(u.Password)
+}
+
+// ValidPassword checks a password is long enough
+func ValidPassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("Passwords must be at least %d characters", MinPasswordLength)
+	}
+	return nil
+}
+
+// Identity returns the identity of the user
+func (u *User) Identity() *Identity {
+	return &Identity{Name: u.Username, Kind: IdentityUser, Scopes: []TokenScope{u.Role}}
+}
+
+// Session is a user's login session. The id is only known when the session is created, the CSRF
+// token must be sent with requests that change something.
+type Session struct {
+	ID        string    `json:"-"`
+	Username  string    `json:"username"`
+	CSRFToken string    `json:"csrf_token"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Expired returns true when the session has expired at a time
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.Expires)
+}
+
+// ValidCSRFToken returns true when a token sent with a request is the session's CSRF token
+func (s *Session) ValidCSRFToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(s.CSRFToken), []byte(token)) == 1
+}
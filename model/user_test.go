@@ -0,0 +1,28 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUser_Validate(t *testing.T) {
+	assert.NoError(t, (&User{Username: "alice", Role: ScopePlan, Password: "correct horse"}).Validate())
+	assert.Error(t, (&User{Username: "", Role: ScopePlan, Password: "correct horse"}).Validate())
+	assert.Error(t, (&User{Username: " alice", Role: ScopePlan, Password: "correct horse"}).Validate())
+	assert.Error(t, (&User{Username: "token:alice", Role: ScopePlan, Password: "correct horse"}).Validate())
+	assert.Error(t, (&User{Username: "alice", Role: "root", Password: "correct horse"}).Validate())
+	assert.Error(t, (&User{Username: "alice", Role: ScopePlan, Password: "short"}).Validate())
+}
+
+func TestIdentity(t *testing.T) {
+	user := (&User{Username: "alice", Role: ScopeApply}).Identity()
+	assert.Equal(t, "alice", user.String())
+	assert.True(t, user.Allows(ScopePlan))
+	assert.False(t, user.Allows(ScopeAdmin))
+
+	token := (&Token{Name: "deploy", Scopes: []TokenScope{ScopeRead}}).Identity()
+	assert.Equal(t, "token:deploy", token.String())
+	assert.True(t, token.Allows(ScopeRead))
+	assert.False(t, token.Allows(ScopePlan))
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/parser"
+)
+
+// Variable is a value passed to terraform for a declared variable. Variables belong to a project, to
+// a variable set shared by many projects, or are global and apply to every project. Sensitive values
+// are never returned once they are stored, and values flagged HCL are parsed by terraform as HCL,
+// allowing lists and maps.
+type Variable struct {
+	GUID        string `json:"guid,omitempty"`
+	ProjectGUID string `json:"project_guid,omitempty"`
+	SetGUID     string `json:"set_guid,omitempty"`
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	Sensitive   bool   `json:"sensitive"`
+	HCL         bool   `json:"hcl"`
+}
+
+// VariableScope identifies where variables are stored, a scope without a project or set is global
+type VariableScope struct {
+	ProjectGUID string
+	SetGUID     string
+}
+
+// variableName matches valid terraform variable names
+var variableName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+// Validate checks that the variable can be passed to terraform. HCL values are written to a tfvars
+// file as the value of the variable, so they must be a single HCL value.
+func (v *Variable) Validate() error {
+	if !variableName.MatchString(v.Name) {
+		return fmt.Errorf("Invalid variable name '%s'", v.Name)
+	}
+	if v.HCL {
+		if err := validHCLValue(v.Value); err != nil {
+			return fmt.Errorf("Invalid HCL value of variable '%s': %s", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// validHCLValue checks that value parses as the value of a single assignment
+func validHCLValue(value string) error {
+	f, err := parser.Parse([]byte("value = " + value))
+	if err != nil {
+		return err
+	}
+
+	list, ok := f.Node.(*ast.ObjectList)
+	if !ok || len(list.Items) != 1 || len(list.Items[0].Keys) != 1 {
+		return fmt.Errorf("expected a single value, such as a string, number, list or map")
+	}
+	return nil
+}
+
+// Masked returns a copy of the variable safe to return from the API
+func (v *Variable) Masked() *Variable {
+	masked := *v
+	if v.Sensitive {
+		masked.Value = ""
+	}
+	return &masked
+}
+
+// Scope returns the scope the variable is stored in
+func (v *Variable) Scope() VariableScope {
+	return VariableScope{ProjectGUID: v.ProjectGUID, SetGUID: v.SetGUID}
+}
+
+// Namespace returns the namespace variables in the scope are stored in
+func (s VariableScope) Namespace() string {
+	switch {
+	case s.ProjectGUID != "":
+		return (&Project{GUID: s.ProjectGUID}).VariablesNS()
+	case s.SetGUID != "":
+		return (&VariableSet{GUID: s.SetGUID}).VariablesNS()
+	default:
+		return "variables"
+	}
+}
+
+// VariablesNS returns the namespace to use for this projects variables
+func (prj *Project) VariablesNS() string {
+	return fmt.Sprintf("project-%s-variables", prj.GUID)
+}
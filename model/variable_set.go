@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VariableSet is a named group of variables that can be attached to many projects
+type VariableSet struct {
+	GUID        string `json:"guid,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// ResolvedVariable is the value a variable takes in a project, and the layer the value comes from.
+// SetName names the variable set for values from a set, and Shadowed lists the layers whose values
+// were overridden.
+type ResolvedVariable struct {
+	Variable
+	Source   VariableSource   `json:"source"`
+	SetName  string           `json:"set_name,omitempty"`
+	Shadowed []VariableSource `json:"shadowed,omitempty"`
+}
+
+// VariableLayer is a group of variables taking precedence over the layers before it
+type VariableLayer struct {
+	Source    VariableSource
+	SetName   string
+	Variables []*Variable
+}
+
+// Validate checks the set can be stored
+func (s *VariableSet) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("Variable set name is required")
+	}
+	return nil
+}
+
+// VariablesNS returns the namespace to use for this sets variables
+func (s *VariableSet) VariablesNS() string {
+	return fmt.Sprintf("variable-set-%s-variables", s.GUID)
+}
+
+// ResolveVariables layers variables in order of precedence, the last layer with a value for a
+// variable wins. Resolved variables are ordered by name.
+func ResolveVariables(layers ...*VariableLayer) []*ResolvedVariable {
+	byName := make(map[string]*ResolvedVariable)
+	for _, layer := range layers {
+		for _, v := range layer.Variables {
+			resolved := &ResolvedVariable{
+				Variable: *v,
+				Source:   layer.Source,
+				SetName:  layer.SetName,
+			}
+			if previous, ok := byName[v.Name]; ok {
+				resolved.Shadowed = append(previous.Shadowed, previous.Source)
+			}
+			byName[v.Name] = resolved
+		}
+	}
+
+	resolved := make([]*ResolvedVariable, 0, len(byName))
+	for _, v := range byName {
+		resolved = append(resolved, v)
+	}
+	sort.Slice(resolved, func(i, j int) bool {
+		return resolved[i].Name < resolved[j].Name
+	})
+
+	return resolved
+}
+
+// Masked returns a copy of the resolved variable safe to return from the API
+func (r *ResolvedVariable) Masked() *ResolvedVariable {
+	masked := *r
+	masked.Variable = *r.Variable.Masked()
+	return &masked
+}
+
+// SettingsLayer creates a layer from plain string values, such as project settings or overrides
+func SettingsLayer(source VariableSource, values map[string]string) *VariableLayer {
+	layer := &VariableLayer{Source: source}
+	for name, value := range values {
+		layer.Variables = append(layer.Variables, &Variable{Name: name, Value: value})
+	}
+	return layer
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveVariables(t *testing.T) {
+	resolved := ResolveVariables(
+		&VariableLayer{Source: VariableSourceGlobal, Variables: []*Variable{
+			{Name: "region", Value: "us-east-1"},
+			{Name: "password", Value: "secret", Sensitive: true},
+		}},
+		&VariableLayer{Source: VariableSourceSet, SetName: "network", Variables: []*Variable{
+			{Name: "region", Value: "us-west-2"},
+		}},
+		SettingsLayer(VariableSourceOverride, map[string]string{"region": "eu-west-1"}),
+	)
+
+	assert.Equal(t, 2, len(resolved))
+	assert.Equal(t, "password", resolved[0].Name)
+	assert.Equal(t, VariableSourceGlobal, resolved[0].Source)
+	assert.Equal(t, "", resolved[0].Masked().Value)
+	assert.Equal(t, "secret", resolved[0].Value)
+
+	assert.Equal(t, "region", resolved[1].Name)
+	assert.Equal(t, "eu-west-1", resolved[1].Value)
+	assert.Equal(t, VariableSourceOverride, resolved[1].Source)
+	assert.Equal(t, []VariableSource{VariableSourceGlobal, VariableSourceSet}, resolved[1].Shadowed)
+}
+
+func TestVariableScope_Namespace(t *testing.T) {
+	assert.Equal(t, "variables", VariableScope{}.Namespace())
+	assert.Equal(t, "project-1-variables", VariableScope{ProjectGUID: "1"}.Namespace())
+	assert.Equal(t, "variable-set-2-variables", VariableScope{SetGUID: "2"}.Namespace())
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVariable_Validate(t *testing.T) {
+	assert.NoError(t, (&Variable{Name: "region", Value: "us-east-1\nother"}).Validate())
+	assert.Error(t, (&Variable{Name: "bad name", Value: "x"}).Validate())
+
+	// HCL values are a single value
+	for _, value := range []string{`"us-east-1"`, `42`, `["a", "b"]`, `{ a = 1, b = ["c"] }`, "<<EOF\nline\nEOF\n"} {
+		assert.NoError(t, (&Variable{Name: "v", Value: value, HCL: true}).Validate(), value)
+	}
+	for _, value := range []string{"", `["a"`, "1\nother = 2", `"a" other = "b"`, "{}\nresource \"null_resource\" \"x\" {}"} {
+		assert.Error(t, (&Variable{Name: "v", Value: value, HCL: true}).Validate(), value)
+	}
+}
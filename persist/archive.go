@@ -0,0 +1,232 @@
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// ArchiveFormat is the version of the archive format, archives of other formats cannot be imported
+const ArchiveFormat = 1
+
+// Archive is a portable copy of every namespace in a store. Values are kept as they are encoded in the
+// store, so values encrypted before they were stored remain encrypted.
+type Archive struct {
+	Format        int                 `json:"format"`
+	SchemaVersion int                 `json:"schema_version"`
+	Created       time.Time           `json:"created"`
+	Namespaces    []*ArchiveNamespace `json:"namespaces"`
+}
+
+// ArchiveNamespace is a namespace of an archive
+type ArchiveNamespace struct {
+	Name   string          `json:"name"`
+	Values []*ArchiveValue `json:"values"`
+}
+
+// ArchiveValue is an encoded value of an archive
+type ArchiveValue struct {
+	GUID     string `json:"guid"`
+	Revision uint64 `json:"revision"`
+	Data     []byte `json:"data"`
+}
+
+// ImportStrategy decides what happens to values in a store that are also in an imported archive
+type ImportStrategy string
+
+// Import strategies. Skip keeps values in the store that have the guid of an archived value, and
+// overwrite replaces them. Replace removes the values in each namespace of the archive first, so
+// the namespaces match the archive.
+const (
+	ImportSkip      ImportStrategy = "skip"
+	ImportOverwrite ImportStrategy = "overwrite"
+	ImportReplace   ImportStrategy = "replace"
+)
+
+// ParseImportStrategy returns the named strategy, skip when the name is empty
+func ParseImportStrategy(name string) (ImportStrategy, error) {
+	switch ImportStrategy(name) {
+	case "", ImportSkip:
+		return ImportSkip, nil
+	case ImportOverwrite, ImportReplace:
+		return ImportStrategy(name), nil
+	}
+	return "", fmt.Errorf("Unknown import strategy '%s', expected one of %s, %s, %s", name, ImportSkip, ImportOverwrite, ImportReplace)
+}
+
+// ImportReport counts the values imported from an archive
+type ImportReport struct {
+	Strategy   ImportStrategy `json:"strategy"`
+	Namespaces int            `json:"namespaces"`
+	Imported   int            `json:"imported"`
+	Skipped    int            `json:"skipped"`
+	Removed    int            `json:"removed"`
+}
+
+// Export writes an archive of every namespace in a store to w. The archive is written a namespace at a
+// time, so the values of the whole store are not held in memory. Nothing is written when the store's
+// schema version or namespaces cannot be read.
+func Export(store Store, w io.Writer) error {
+	version, err := SchemaVersion(store)
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := store.Namespaces()
+	if err != nil {
+		return err
+	}
+
+	created, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "{\n  \"format\": %d,\n  \"schema_version\": %d,\n  \"created\": %s,\n  \"namespaces\": [",
+		ArchiveFormat, version, created)
+	if err != nil {
+		return err
+	}
+
+	for i, ns := range namespaces {
+		archived, err := exportNamespace(store, ns)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(archived, "    ", "  ")
+		if err != nil {
+			return err
+		}
+
+		separator := ",\n    "
+		if i == 0 {
+			separator = "\n    "
+		}
+		if _, err := fmt.Fprintf(w, "%s%s", separator, data); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprint(w, "\n  ]\n}\n")
+	return err
+}
+
+// exportNamespace reads the encoded values of a namespace
+func exportNamespace(store Store, ns string) (*ArchiveNamespace, error) {
+	guids, err := store.List(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	archived := &ArchiveNamespace{ns, make([]*ArchiveValue, len(guids))}
+	for i, guid := range guids {
+		data, rev, err := store.GetEncoded(ns, guid)
+		if err != nil {
+			return nil, err
+		}
+		archived.Values[i] = &ArchiveValue{guid, rev, data}
+	}
+	return archived, nil
+}
+
+// Import copies the namespaces of an archive into a store, keeping the guids and revisions of values.
+// The archive must have the schema version of the store, unless the store is empty, when the store
+// takes the schema version of the archive so migrations upgrade the imported values.
+func Import(store Store, archive *Archive, strategy ImportStrategy) (*ImportReport, error) {
+	if archive.Format != ArchiveFormat {
+		return nil, fmt.Errorf("Archive format %d is not supported, expected format %d", archive.Format, ArchiveFormat)
+	}
+
+	s, guid, err := readSchema(store)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Version != archive.SchemaVersion {
+		isEmpty, err := empty(store)
+		if err != nil {
+			return nil, err
+		}
+		if !isEmpty {
+			return nil, fmt.Errorf("Archive schema version %d is not the store's version %d, import it into an empty store",
+				archive.SchemaVersion, s.Version)
+		}
+
+		s.Version = archive.SchemaVersion
+		if guid == "" {
+			_, err = store.Create(schemaNS, &s)
+		} else {
+			err = store.Update(schemaNS, guid, &s)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ImportReport{Strategy: strategy}
+	for _, ns := range archive.Namespaces {
+		if reserved(ns.Name) {
+			continue
+		}
+
+		if err := store.CreateNamespace(ns.Name); err != nil {
+			return nil, err
+		}
+
+		existing, err := store.List(ns.Name)
+		if err != nil {
+			return nil, err
+		}
+		stored := make(map[string]bool, len(existing))
+		for _, guid := range existing {
+			stored[guid] = true
+		}
+
+		if strategy == ImportReplace {
+			for _, guid := range existing {
+				if err := store.Delete(ns.Name, guid); err != nil {
+					return nil, err
+				}
+				report.Removed++
+			}
+			stored = map[string]bool{}
+		}
+
+		for _, v := range ns.Values {
+			if stored[v.GUID] && strategy == ImportSkip {
+				report.Skipped++
+				continue
+			}
+
+			if err := store.PutEncoded(ns.Name, v.GUID, v.Data, v.Revision); err != nil {
+				return nil, fmt.Errorf("Error importing '%s' into '%s': %s", v.GUID, ns.Name, err)
+			}
+			report.Imported++
+		}
+		report.Namespaces++
+	}
+
+	log.Printf("[INFO] Imported %d values into %d namespaces, skipped %d and removed %d",
+		report.Imported, report.Namespaces, report.Skipped, report.Removed)
+	return report, nil
+}
+
+// empty returns true if no namespace of the store has a value
+func empty(store Store) (bool, error) {
+	namespaces, err := store.Namespaces()
+	if err != nil {
+		return false, err
+	}
+
+	for _, ns := range namespaces {
+		guids, err := store.List(ns)
+		if err != nil {
+			return false, err
+		}
+		if len(guids) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,130 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// export returns the archive of a store, as it is read by an import
+func export(store Store) (*Archive, error) {
+	var buf bytes.Buffer
+	if err := Export(store, &buf); err != nil {
+		return nil, err
+	}
+	archive := &Archive{}
+	err := json.Unmarshal(buf.Bytes(), archive)
+	return archive, err
+}
+
+func Test_Archive_local_file_to_bolt(t *testing.T) {
+	src, cleanup := createStore()
+	defer cleanup()
+	dst, cleanupBolt := createEncodedBoltStore(t, JSONEncoding)
+	defer cleanupBolt()
+
+	assert.Nil(t, src.CreateNamespace("fruit"))
+	apple, err := src.Create("fruit", &data{"apple", 1})
+	assert.Nil(t, err)
+	assert.Nil(t, src.Update("fruit", apple, &data{"apple", 2}))
+	_, err = src.Create("fruit", &data{"banana", 1})
+	assert.Nil(t, err)
+
+	// an empty store has an empty archive
+	archive, err := export(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(archive.Namespaces))
+
+	archive, err = export(src)
+	assert.Nil(t, err)
+	assert.Equal(t, ArchiveFormat, archive.Format)
+	assert.Equal(t, 1, len(archive.Namespaces))
+
+	// guids and revisions are kept
+	report, err := Import(dst, archive, ImportSkip)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Imported)
+
+	var d data
+	rev, err := dst.GetRevision("fruit", apple, &d)
+	assert.Nil(t, err)
+	assert.Equal(t, data{"apple", 2}, d)
+	assert.Equal(t, uint64(2), rev)
+
+	// indexes declared on the destination index imported values
+	assert.Nil(t, dst.CreateIndex("fruit", nameIndex))
+	guids, err := dst.Query("fruit", "name", Exact("apple"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{apple}, guids)
+}
+
+func Test_Archive_strategies(t *testing.T) {
+	store, cleanup := createBoltStore(t)
+	defer cleanup()
+
+	assert.Nil(t, store.CreateNamespace("fruit"))
+	apple, err := store.Create("fruit", &data{"apple", 1})
+	assert.Nil(t, err)
+	archive, err := export(store)
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.Update("fruit", apple, &data{"apple", 2}))
+	cherry, err := store.Create("fruit", &data{"cherry", 1})
+	assert.Nil(t, err)
+
+	// skip keeps stored values
+	report, err := Import(store, archive, ImportSkip)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Skipped)
+	var d data
+	assert.Nil(t, store.Get("fruit", apple, &d))
+	assert.Equal(t, 2, d.Count)
+
+	// overwrite replaces them
+	_, err = Import(store, archive, ImportOverwrite)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Get("fruit", apple, &d))
+	assert.Equal(t, 1, d.Count)
+
+	// replace removes values that are not archived
+	report, err = Import(store, archive, ImportReplace)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Removed)
+	guids, err := store.List("fruit")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{apple}, guids)
+
+	// guids of imported values are not reused
+	guid, err := store.Create("fruit", &data{"damson", 1})
+	assert.Nil(t, err)
+	assert.NotEqual(t, apple, guid)
+	assert.NotEqual(t, cherry, guid)
+}
+
+func Test_Archive_schema_version(t *testing.T) {
+	store, cleanup := createStore()
+	defer cleanup()
+
+	archive := &Archive{Format: ArchiveFormat, SchemaVersion: 2, Namespaces: []*ArchiveNamespace{}}
+
+	// an empty store takes the version of the archive
+	_, err := Import(store, archive, ImportSkip)
+	assert.Nil(t, err)
+	version, err := SchemaVersion(store)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+
+	// other stores must have its version
+	assert.Nil(t, store.CreateNamespace("fruit"))
+	_, err = store.Create("fruit", &data{"apple", 1})
+	assert.Nil(t, err)
+	archive.SchemaVersion = 1
+	_, err = Import(store, archive, ImportSkip)
+	assert.NotNil(t, err)
+
+	archive.Format = 2
+	_, err = Import(store, archive, ImportSkip)
+	assert.NotNil(t, err)
+}
@@ -2,10 +2,12 @@ package persist
 
 import (
 	"bytes"
-	"encoding/gob"
+	"encoding/binary"
 	"log"
+	"os"
 	"path"
 	"strconv"
+	"sync"
 	"time"
 
 	"fmt"
@@ -13,28 +15,49 @@ import (
 	"github.com/boltdb/bolt"
 )
 
+// revisionsBucket holds the revisions of values in every namespace
+const revisionsBucket = "__revisions"
+
 type boltStore struct {
-	db *bolt.DB
+	db        *bolt.DB
+	encoding  Encoding
+	dbLock    sync.RWMutex
+	indexes   map[string][]Index
+	indexLock sync.RWMutex
 }
 
-// NewBoltStore creates a new store using bolthold
-func NewBoltStore(dir string) (Store, error) {
+// NewBoltStore creates a new store using bolthold, values are written in the encoding. Values written
+// in either encoding are read, so the encoding of a store can be changed.
+func NewBoltStore(dir string, encoding Encoding) (Store, error) {
 	dbfile := path.Join(dir, "bolt.db")
 
 	log.Printf("[INFO] Bolt DB %s", dbfile)
 	db, err := bolt.Open(dbfile, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("Timed out opening Bolt DB %s, it is open in another process, such as the tfwatch service. Stop the service first", dbfile)
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(revisionsBucket))
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &boltStore{db}, nil
+	return &boltStore{db: db, encoding: encoding, indexes: make(map[string][]Index)}, nil
 }
 
 // List retrieves the guids in a namespace
 func (b *boltStore) List(ns string) (guids []string, err error) {
-	err = b.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte(ns))
+	err = b.view(func(tx *bolt.Tx) error {
+		bkt, err := bucket(tx, ns)
+		if err != nil {
+			return err
+		}
 
 		return bkt.ForEach(func(k, v []byte) error {
 			guids = append(guids, string(k))
@@ -46,91 +69,446 @@ func (b *boltStore) List(ns string) (guids []string, err error) {
 
 // Get retrieves a value from the Store by guid
 func (b *boltStore) Get(ns, guid string, value interface{}) error {
-	return b.db.View(func(tx *bolt.Tx) error {
-		bytes := tx.Bucket([]byte(ns)).Get([]byte(guid))
+	_, err := b.GetRevision(ns, guid, value)
+	return err
+}
+
+// GetRevision retrieves a value from the Store by guid along with its revision
+func (b *boltStore) GetRevision(ns, guid string, value interface{}) (rev uint64, err error) {
+	err = b.view(func(tx *bolt.Tx) error {
+		bytes, err := get(tx, ns, guid)
+		if err != nil {
+			return err
+		}
+		rev = revision(tx, ns, guid)
 		return decode(bytes, value)
 	})
+	return
 }
 
 // Create stores a value, and returns the guid, if any error is returned, nothing is saved
 func (b *boltStore) Create(ns string, value interface{}) (idStr string, err error) {
-	err = b.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(ns))
+	err = b.update(func(tx *bolt.Tx) error {
+		bkt, err := bucket(tx, ns)
+		if err != nil {
+			return err
+		}
 
-		id, err := bucket.NextSequence()
+		id, err := bkt.NextSequence()
 		if err != nil {
 			return err
 		}
 
-		encoded, err := encode(value)
+		encoded, err := b.encoding.encode(value)
 		if err != nil {
 			return err
 		}
 
 		idStr = strconv.FormatUint(id, 10)
-		err = tx.Bucket([]byte(ns)).Put([]byte(idStr), encoded)
-		return err
+		err = bkt.Put([]byte(idStr), encoded)
+		if err != nil {
+			return err
+		}
+
+		err = b.updateIndexes(tx, ns, idStr, value)
+		if err != nil {
+			return err
+		}
+		return setRevision(tx, ns, idStr, 1)
 	})
 	return
 }
 
 // Update updates a stored value, if value does not exist, an error is returned
 func (b *boltStore) Update(ns, guid string, value interface{}) error {
-	return b.db.Update(func(tx *bolt.Tx) error {
-		encoded, err := encode(value)
+	return b.update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, ns, guid); err != nil {
+			return err
+		}
+
+		_, err := b.put(tx, ns, guid, value)
+		return err
+	})
+}
+
+// CompareAndUpdate updates a stored value only if its revision is still revision
+func (b *boltStore) CompareAndUpdate(ns, guid string, rev uint64, value interface{}) (next uint64, err error) {
+	err = b.update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, ns, guid); err != nil {
+			return err
+		}
+
+		if current := revision(tx, ns, guid); current != rev {
+			return &ConflictError{ns, guid, rev, current}
+		}
+
+		next, err = b.put(tx, ns, guid, value)
+		return err
+	})
+	return
+}
+
+// GetEncoded retrieves a value as it is stored and its revision
+func (b *boltStore) GetEncoded(ns, guid string) (data []byte, rev uint64, err error) {
+	err = b.view(func(tx *bolt.Tx) error {
+		v, err := get(tx, ns, guid)
 		if err != nil {
 			return err
 		}
-		return tx.Bucket([]byte(ns)).Put([]byte(guid), encoded)
+
+		// values are only valid during the transaction
+		data = append([]byte(nil), v...)
+		rev = revision(tx, ns, guid)
+		return nil
+	})
+	return
+}
+
+// PutEncoded stores an encoded value by guid with a revision, decoding it for each index
+func (b *boltStore) PutEncoded(ns, guid string, data []byte, rev uint64) error {
+	return b.update(func(tx *bolt.Tx) error {
+		bkt, err := bucket(tx, ns)
+		if err != nil {
+			return err
+		}
+
+		for _, index := range b.namespaceIndexes(ns) {
+			value := index.New()
+			if err := decode(data, value); err != nil {
+				return err
+			}
+			if err := unindexValue(tx, ns, index, guid); err != nil {
+				return err
+			}
+			if err := indexValue(tx, ns, index, guid, value); err != nil {
+				return err
+			}
+		}
+
+		if err := bkt.Put([]byte(guid), data); err != nil {
+			return err
+		}
+
+		// values created later must not be given the guid of a value put
+		if seq, err := strconv.ParseUint(guid, 10, 64); err == nil && seq > bkt.Sequence() {
+			if err := bkt.SetSequence(seq); err != nil {
+				return err
+			}
+		}
+
+		return setRevision(tx, ns, guid, rev)
 	})
 }
 
 // Delete removes a value from the key-value store
 func (b *boltStore) Delete(ns, guid string) error {
-	return b.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte(ns)).Delete([]byte(guid))
+	return b.update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, ns, guid); err != nil {
+			return err
+		}
+
+		err := tx.Bucket([]byte(ns)).Delete([]byte(guid))
+		if err != nil {
+			return err
+		}
+
+		err = b.removeIndexes(tx, ns, guid)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(revisionsBucket)).Delete(revisionKey(ns, guid))
 	})
 }
 
+// Namespaces lists the buckets of the namespaces in the database
+func (b *boltStore) Namespaces() (namespaces []string, err error) {
+	namespaces = []string{}
+	err = b.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if !reserved(string(name)) {
+				namespaces = append(namespaces, string(name))
+			}
+			return nil
+		})
+	})
+	return
+}
+
 // CreateNamespace ensures that a namespace exists
 func (b *boltStore) CreateNamespace(ns string) error {
-	return b.db.Update(func(tx *bolt.Tx) error {
+	return b.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(ns))
 		return err
 	})
 }
 
-// Destroys the store, removing all persisted data
-func (b *boltStore) Destroy() error {
-	return fmt.Errorf("Destroy not implemented")
-}
+// DeleteNamespace removes the bucket of a namespace, its index buckets and the revisions of its values
+func (b *boltStore) DeleteNamespace(ns string) error {
+	b.indexLock.Lock()
+	defer b.indexLock.Unlock()
 
-func encode(value interface{}) ([]byte, error) {
-	var buff bytes.Buffer
+	err := b.update(func(tx *bolt.Tx) error {
+		// index buckets are found by name, indexes may not have been declared since the store opened
+		var buckets [][]byte
+		if tx.Bucket([]byte(ns)) != nil {
+			buckets = append(buckets, []byte(ns))
+		}
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if bytes.HasPrefix(name, indexBucket(ns, "")) || bytes.HasPrefix(name, indexKeysBucket(ns, "")) {
+				buckets = append(buckets, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 
-	en := gob.NewEncoder(&buff)
+		for _, name := range buckets {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
 
-	err := en.Encode(value)
+		// keys are collected first, deleting while iterating a cursor skips keys
+		revisions := tx.Bucket([]byte(revisionsBucket))
+		prefix := revisionKey(ns, "")
+		var keys [][]byte
+		c := revisions.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := revisions.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return buff.Bytes(), nil
+	delete(b.indexes, ns)
+	return nil
 }
 
-func decode(data []byte, value interface{}) error {
-	var buff bytes.Buffer
-	de := gob.NewDecoder(&buff)
+// Compact rewrites the database file, bolt never shrinks the file when values are deleted. The store
+// is unavailable while it is compacting.
+func (b *boltStore) Compact() error {
+	b.dbLock.Lock()
+	defer b.dbLock.Unlock()
+
+	dbfile := b.db.Path()
+	compacted := dbfile + ".compact"
+	if err := os.Remove(compacted); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := bolt.Open(compacted, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
 
-	_, err := buff.Write(data)
+	// buckets are copied a transaction each, keeping their sequences so guids are not reused
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, src *bolt.Bucket) error {
+			return dst.Update(func(dtx *bolt.Tx) error {
+				bkt, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				if err := bkt.SetSequence(src.Sequence()); err != nil {
+					return err
+				}
+				return src.ForEach(func(k, v []byte) error {
+					return bkt.Put(k, v)
+				})
+			})
+		})
+	})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(compacted)
 		return err
 	}
 
-	err = de.Decode(value)
+	// the database is reopened whatever fails once it is closed, the original file is kept when the
+	// compacted file cannot replace it
+	before, after := fileSize(dbfile), fileSize(compacted)
+	if err := b.db.Close(); err != nil {
+		os.Remove(compacted)
+		return b.reopen(dbfile, err)
+	}
+	if err := renameFile(compacted, dbfile); err != nil {
+		os.Remove(compacted)
+		return b.reopen(dbfile, err)
+	}
+	if err := b.reopen(dbfile, nil); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Compacted Bolt DB %s from %d to %d bytes", dbfile, before, after)
+	return nil
+}
+
+// renameFile replaces the database file with the compacted file
+var renameFile = os.Rename
+
+// reopen opens the database file after it was closed, returning err. The error opening the file is
+// returned when there is no err.
+func (b *boltStore) reopen(dbfile string, err error) error {
+	db, openErr := bolt.Open(dbfile, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if openErr != nil {
+		log.Printf("[ERROR] Error reopening Bolt DB %s: %s", dbfile, openErr)
+		if err == nil {
+			err = openErr
+		}
+		return err
+	}
+
+	b.db = db
+	return err
+}
+
+// Backup copies the database to a new file in dir, the store can be read while it is copied
+func (b *boltStore) Backup(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	file := path.Join(dir, fmt.Sprintf("bolt-%s.db", time.Now().UTC().Format(backupTimeFormat)))
+	return file, b.view(func(tx *bolt.Tx) error {
+		return tx.CopyFile(file, 0600)
+	})
+}
+
+// Destroy removes every bucket of the database, leaving the store empty. The database file is
+// compacted by the next Compact.
+func (b *boltStore) Destroy() error {
+	b.indexLock.Lock()
+	defer b.indexLock.Unlock()
+
+	err := b.update(func(tx *bolt.Tx) error {
+		var buckets [][]byte
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			buckets = append(buckets, append([]byte(nil), name...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range buckets {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.CreateBucket([]byte(revisionsBucket))
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
+	b.indexes = make(map[string][]Index)
 	return nil
 }
+
+// Close closes the database, releasing its file lock
+func (b *boltStore) Close() error {
+	b.dbLock.Lock()
+	defer b.dbLock.Unlock()
+	return b.db.Close()
+}
+
+// view runs a read-only transaction
+func (b *boltStore) view(fn func(*bolt.Tx) error) error {
+	b.dbLock.RLock()
+	defer b.dbLock.RUnlock()
+	return b.db.View(fn)
+}
+
+// update runs a read-write transaction
+func (b *boltStore) update(fn func(*bolt.Tx) error) error {
+	b.dbLock.RLock()
+	defer b.dbLock.RUnlock()
+	return b.db.Update(fn)
+}
+
+// bucket returns the bucket of a namespace, a *NotFoundError when the namespace does not exist
+func bucket(tx *bolt.Tx, ns string) (*bolt.Bucket, error) {
+	bkt := tx.Bucket([]byte(ns))
+	if bkt == nil {
+		return nil, namespaceNotFound(ns)
+	}
+	return bkt, nil
+}
+
+// get returns a stored value, a *NotFoundError when it or its namespace does not exist. The value is
+// only valid during the transaction.
+func get(tx *bolt.Tx, ns, guid string) ([]byte, error) {
+	bkt, err := bucket(tx, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	v := bkt.Get([]byte(guid))
+	if v == nil {
+		return nil, &NotFoundError{fmt.Errorf("'%s' not found in '%s'", guid, ns)}
+	}
+	return v, nil
+}
+
+// fileSize returns the size of a file, 0 when it cannot be read
+func fileSize(name string) int64 {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// put stores a value, updating its indexes, and increments its revision, returning the new revision
+func (b *boltStore) put(tx *bolt.Tx, ns, guid string, value interface{}) (uint64, error) {
+	encoded, err := b.encoding.encode(value)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Bucket([]byte(ns)).Put([]byte(guid), encoded)
+	if err != nil {
+		return 0, err
+	}
+
+	err = b.updateIndexes(tx, ns, guid, value)
+	if err != nil {
+		return 0, err
+	}
+
+	rev := revision(tx, ns, guid) + 1
+	return rev, setRevision(tx, ns, guid, rev)
+}
+
+// revisionKey is the key of a value's revision in the revisions bucket
+func revisionKey(ns, guid string) []byte {
+	return []byte(ns + "\x00" + guid)
+}
+
+// revision returns the revision of a value, 0 when none is recorded
+func revision(tx *bolt.Tx, ns, guid string) uint64 {
+	v := tx.Bucket([]byte(revisionsBucket)).Get(revisionKey(ns, guid))
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// setRevision records the revision of a value
+func setRevision(tx *bolt.Tx, ns, guid string, rev uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, rev)
+	return tx.Bucket([]byte(revisionsBucket)).Put(revisionKey(ns, guid), v)
+}
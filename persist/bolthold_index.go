@@ -0,0 +1,235 @@
+package persist
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// indexBucket holds the entries of an index, keyed by the index key and guid, separated by a 0 byte
+func indexBucket(ns, name string) []byte {
+	return []byte("__index\x00" + ns + "\x00" + name)
+}
+
+// indexKeysBucket holds the key each guid is indexed by, so entries are found when values change
+func indexKeysBucket(ns, name string) []byte {
+	return []byte("__index_keys\x00" + ns + "\x00" + name)
+}
+
+// entryKey is the key of an index entry
+func entryKey(key, guid string) []byte {
+	return []byte(key + "\x00" + guid)
+}
+
+// splitEntryKey returns the index key and guid of an entry
+func splitEntryKey(k []byte) (string, string) {
+	i := bytes.IndexByte(k, 0)
+	if i < 0 {
+		return string(k), ""
+	}
+	return string(k[:i]), string(k[i+1:])
+}
+
+// CreateIndex declares an index on a namespace and builds it from the stored values
+func (b *boltStore) CreateIndex(ns string, index Index) error {
+	b.indexLock.Lock()
+	defer b.indexLock.Unlock()
+
+	for _, idx := range b.indexes[ns] {
+		if idx.Name == index.Name {
+			return nil
+		}
+	}
+
+	err := b.update(func(tx *bolt.Tx) error {
+		bkt, err := bucket(tx, ns)
+		if err != nil {
+			return err
+		}
+
+		// rebuild the index, values may have been stored while it was not declared
+		for _, name := range [][]byte{indexBucket(ns, index.Name), indexKeysBucket(ns, index.Name)} {
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+
+		return bkt.ForEach(func(k, v []byte) error {
+			value := index.New()
+			if err := decode(v, value); err != nil {
+				return err
+			}
+
+			err := indexValue(tx, ns, index, string(k), value)
+			if dup, ok := err.(*DuplicateError); ok {
+				log.Printf("[WARN] Not indexing '%s', %s", k, dup)
+				return nil
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] Created index '%s' on namespace '%s'", index.Name, ns)
+	b.indexes[ns] = append(b.indexes[ns], index)
+	return nil
+}
+
+// Query returns the guids of values in an index matching the query, ordered by key
+func (b *boltStore) Query(ns, index string, q Query) (guids []string, err error) {
+	err = b.Range(ns, index, q, collect(&guids))
+	return
+}
+
+// Range visits the values in an index matching the query in a single read transaction
+func (b *boltStore) Range(ns, index string, q Query, visit Visitor) error {
+	lower, upper := q.lower(), q.upper()
+
+	return b.view(func(tx *bolt.Tx) error {
+		values, err := bucket(tx, ns)
+		if err != nil {
+			return err
+		}
+
+		bkt := tx.Bucket(indexBucket(ns, index))
+		if bkt == nil {
+			return fmt.Errorf("Index '%s' on namespace '%s' does not exist", index, ns)
+		}
+
+		// position the cursor at the first entry of the range, entries sort by key then guid
+		c := bkt.Cursor()
+		var k []byte
+		if !q.Reverse {
+			from := lower
+			if q.After > from {
+				from = q.After
+			}
+			k, _ = c.Seek([]byte(from))
+		} else {
+			to := upper
+			if q.After != "" && (to == "" || q.After < to) {
+				to = q.After
+			}
+			if to == "" {
+				k, _ = c.Last()
+			} else if k, _ = c.Seek([]byte(to)); k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		}
+
+		visited := 0
+		for ; k != nil && !q.limited(visited); k = next(c, q.Reverse) {
+			key, guid := splitEntryKey(k)
+			if !q.matches(key) || !q.follows(key, guid) {
+				// entries are ordered, so once past the range there are no more matches
+				if (!q.Reverse && upper != "" && key >= upper) || (q.Reverse && key < lower) {
+					break
+				}
+				continue
+			}
+
+			visited++
+			more, err := visit(key, guid, func(value interface{}) error {
+				v := values.Get([]byte(guid))
+				if v == nil {
+					return &NotFoundError{fmt.Errorf("'%s' not found in '%s'", guid, ns)}
+				}
+				return decode(v, value)
+			})
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// next moves the cursor forward, or backward when reversed
+func next(c *bolt.Cursor, reverse bool) []byte {
+	var k []byte
+	if reverse {
+		k, _ = c.Prev()
+	} else {
+		k, _ = c.Next()
+	}
+	return k
+}
+
+// updateIndexes indexes a value in each index on its namespace, replacing its previous entries
+func (b *boltStore) updateIndexes(tx *bolt.Tx, ns, guid string, value interface{}) error {
+	for _, index := range b.namespaceIndexes(ns) {
+		if err := unindexValue(tx, ns, index, guid); err != nil {
+			return err
+		}
+		if err := indexValue(tx, ns, index, guid, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIndexes removes a value from each index on its namespace
+func (b *boltStore) removeIndexes(tx *bolt.Tx, ns, guid string) error {
+	for _, index := range b.namespaceIndexes(ns) {
+		if err := unindexValue(tx, ns, index, guid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaceIndexes returns the indexes declared on a namespace
+func (b *boltStore) namespaceIndexes(ns string) []Index {
+	b.indexLock.RLock()
+	defer b.indexLock.RUnlock()
+	return b.indexes[ns]
+}
+
+// indexValue adds the entry of a value to an index
+func indexValue(tx *bolt.Tx, ns string, index Index, guid string, value interface{}) error {
+	key := index.Key(value)
+	if key == "" {
+		return nil
+	}
+
+	entries := tx.Bucket(indexBucket(ns, index.Name))
+	if index.Unique {
+		prefix := []byte(key + "\x00")
+		c := entries.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if _, other := splitEntryKey(k); other != guid {
+				return &DuplicateError{ns, index.Name, key, other}
+			}
+		}
+	}
+
+	if err := entries.Put(entryKey(key, guid), []byte(guid)); err != nil {
+		return err
+	}
+	return tx.Bucket(indexKeysBucket(ns, index.Name)).Put([]byte(guid), []byte(key))
+}
+
+// unindexValue removes the entry of a value from an index
+func unindexValue(tx *bolt.Tx, ns string, index Index, guid string) error {
+	keys := tx.Bucket(indexKeysBucket(ns, index.Name))
+	key := keys.Get([]byte(guid))
+	if key == nil {
+		return nil
+	}
+
+	if err := tx.Bucket(indexBucket(ns, index.Name)).Delete(entryKey(string(key), guid)); err != nil {
+		return err
+	}
+	return keys.Delete([]byte(guid))
+}
@@ -0,0 +1,97 @@
+package persist
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func createBoltStore(t *testing.T) (Store, func()) {
+	return createEncodedBoltStore(t, GobEncoding)
+}
+
+func createEncodedBoltStore(t *testing.T, encoding Encoding) (Store, func()) {
+	dir, err := ioutil.TempDir("", "tfwatch-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewBoltStore(dir, encoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, func() {
+		store.(*boltStore).db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_Bolt_Backup(t *testing.T) {
+	store, cleanup := createBoltStore(t)
+	defer cleanup()
+
+	assert.Nil(t, store.CreateNamespace("backed-up"))
+	guid, err := store.Create("backed-up", &data{"apple", 1})
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "tfwatch-backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file, err := store.(Backuper).Backup(dir)
+	assert.Nil(t, err)
+
+	db, err := bolt.Open(file, 0600, nil)
+	assert.Nil(t, err)
+	backup := &boltStore{db: db, indexes: make(map[string][]Index)}
+	defer db.Close()
+
+	var d data
+	assert.Nil(t, backup.Get("backed-up", guid, &d))
+	assert.Equal(t, "apple", d.Name)
+}
+
+func Test_Bolt_Compact(t *testing.T) {
+	store, cleanup := createBoltStore(t)
+	defer cleanup()
+
+	assert.Nil(t, store.CreateNamespace("compacted"))
+	var guids []string
+	for i := 0; i < 100; i++ {
+		guid, err := store.Create("compacted", &data{strings.Repeat("x", 1024), i})
+		assert.Nil(t, err)
+		guids = append(guids, guid)
+	}
+	for _, guid := range guids[1:] {
+		assert.Nil(t, store.Delete("compacted", guid))
+	}
+
+	dbfile := store.(*boltStore).db.Path()
+	before := fileSize(dbfile)
+	assert.Nil(t, store.(Compactor).Compact())
+	assert.True(t, fileSize(dbfile) < before)
+
+	// values, revisions and sequences survive
+	var d data
+	rev, err := store.GetRevision("compacted", guids[0], &d)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), rev)
+	guid, err := store.Create("compacted", &data{"next", 0})
+	assert.Nil(t, err)
+	assert.Equal(t, "101", guid)
+
+	// the store is reopened when the compacted file cannot replace the database
+	renameFile = func(from, to string) error { return errors.New("rename failed") }
+	defer func() { renameFile = os.Rename }()
+	assert.EqualError(t, store.(Compactor).Compact(), "rename failed")
+	_, err = os.Stat(dbfile + ".compact")
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, store.Get("compacted", guids[0], &d))
+	assert.Equal(t, 0, d.Count)
+}
@@ -0,0 +1,125 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Encoding is how a store encodes the values it writes
+type Encoding string
+
+// Encodings of stored values. Gob is compact, but a value can only be read by types with the fields it
+// was written with. JSON can be inspected, and read by types that have changed since.
+const (
+	GobEncoding  Encoding = "gob"
+	JSONEncoding Encoding = "json"
+)
+
+// ParseEncoding returns the named encoding, gob when the name is empty
+func ParseEncoding(name string) (Encoding, error) {
+	switch Encoding(name) {
+	case "", GobEncoding:
+		return GobEncoding, nil
+	case JSONEncoding:
+		return JSONEncoding, nil
+	}
+	return "", fmt.Errorf("Unknown encoding '%s', expected one of %s, %s", name, GobEncoding, JSONEncoding)
+}
+
+// encode encodes a value. Only structs are encoded as JSON, other values are always gob encoded.
+func (e Encoding) encode(value interface{}) ([]byte, error) {
+	if e == JSONEncoding && reflect.Indirect(reflect.ValueOf(value)).Kind() == reflect.Struct {
+		return encodeJSON(value)
+	}
+
+	var buff bytes.Buffer
+	err := gob.NewEncoder(&buff).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// decode decodes a value in either encoding. JSON values are objects, while gob values start with the
+// length of their first message, which can also be '{', so gob is tried when a value is not JSON.
+func decode(data []byte, value interface{}) error {
+	if len(data) > 0 && data[0] == '{' {
+		if err := decodeJSON(data, value); err == nil {
+			return nil
+		}
+	}
+
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// encodeJSON encodes a struct as JSON. Fields excluded from JSON with a `json:"-"` tag, such as those
+// hidden from API clients, are stored under their Go name.
+func encodeJSON(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(value))
+	hidden := hiddenFields(v.Type())
+	if len(hidden) == 0 {
+		return data, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, f := range hidden {
+		fields[f.Name], err = json.Marshal(v.FieldByIndex(f.Index).Interface())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// decodeJSON decodes a struct encoded by encodeJSON
+func decodeJSON(data []byte, value interface{}) error {
+	err := json.Unmarshal(data, value)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(value))
+	hidden := hiddenFields(v.Type())
+	if len(hidden) == 0 {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, f := range hidden {
+		if raw, ok := fields[f.Name]; ok {
+			if err := json.Unmarshal(raw, v.FieldByIndex(f.Index).Addr().Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hiddenFields returns the exported fields of a struct excluded from JSON
+func hiddenFields(t reflect.Type) (fields []reflect.StructField) {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath == "" && f.Tag.Get("json") == "-" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,53 @@
+package persist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type hidden struct {
+	Name string `json:"name"`
+	Path string `json:"-"`
+}
+
+func TestEncoding_JSON_stores_hidden_fields(t *testing.T) {
+	b, err := JSONEncoding.encode(&hidden{"network", "/var/lib/tfwatch/network"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Path":"/var/lib/tfwatch/network","name":"network"}`, string(b))
+
+	var h hidden
+	assert.Nil(t, decode(b, &h))
+	assert.Equal(t, hidden{"network", "/var/lib/tfwatch/network"}, h)
+}
+
+func TestEncoding_decode_either_encoding(t *testing.T) {
+	for _, e := range []Encoding{GobEncoding, JSONEncoding} {
+		b, err := e.encode(&data{"apple", 1})
+		assert.Nil(t, err)
+
+		var d data
+		assert.Nil(t, decode(b, &d))
+		assert.Equal(t, data{"apple", 1}, d)
+	}
+
+	// values that are not structs are gob encoded
+	b, err := JSONEncoding.encode("apple")
+	assert.Nil(t, err)
+	var s string
+	assert.Nil(t, decode(b, &s))
+	assert.Equal(t, "apple", s)
+}
+
+func TestParseEncoding(t *testing.T) {
+	e, err := ParseEncoding("")
+	assert.Nil(t, err)
+	assert.Equal(t, GobEncoding, e)
+
+	e, err = ParseEncoding("json")
+	assert.Nil(t, err)
+	assert.Equal(t, JSONEncoding, e)
+
+	_, err = ParseEncoding("xml")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,159 @@
+package persist
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Index is a secondary index on the values of a namespace. Indexes are declared each time the store
+// is opened, and built from the values already stored when they are declared.
+type Index struct {
+	Name string
+
+	// Unique indexes reject values whose key is already indexed by another value
+	Unique bool
+
+	// Key returns the key a value is indexed by, values with an empty key are not indexed. The value
+	// is the one passed to Create or Update, or one returned by New when the index is built.
+	Key func(value interface{}) string
+
+	// New returns a pointer stored values are decoded into when the index is built
+	New func() interface{}
+}
+
+// Query selects values from an index. Keys are compared as strings, Start is inclusive and End is
+// exclusive. A query without a prefix or range selects every indexed value. After is a Cursor, when
+// set only values after it, in the order of the query, are selected. Limit is ignored when 0.
+type Query struct {
+	Prefix  string
+	Start   string
+	End     string
+	After   string
+	Reverse bool
+	Limit   int
+}
+
+// Visitor is called with the key and guid of each value visited by Range. The value is decoded by
+// calling decode. Returning false stops the range. Visitors must not modify the store.
+type Visitor func(key, guid string, decode func(value interface{}) error) (bool, error)
+
+// Cursor returns the position of a value in an index, used to continue a query after the value
+func Cursor(key, guid string) string {
+	return key + "\x00" + guid
+}
+
+// Exact returns a query selecting values indexed by key
+func Exact(key string) Query {
+	return Query{Start: key, End: key + "\x00"}
+}
+
+// timeKeyFormat formats times as fixed width keys, which order the same as the times
+const timeKeyFormat = "2006-01-02T15:04:05.000000000Z"
+
+// TimeKey returns an index key for a time, keys of later times sort after keys of earlier times
+func TimeKey(t time.Time) string {
+	return t.UTC().Format(timeKeyFormat)
+}
+
+// DuplicateError returned when a value's key is already indexed by another value in a unique index
+type DuplicateError struct {
+	Namespace string
+	Index     string
+	Key       string
+	GUID      string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("'%s' is already indexed by '%s' in index '%s' of '%s'", e.Key, e.GUID, e.Index, e.Namespace)
+}
+
+// lower returns the lowest key a query selects
+func (q Query) lower() string {
+	if q.Prefix > q.Start {
+		return q.Prefix
+	}
+	return q.Start
+}
+
+// upper returns the key above every key a query selects, empty if there is no upper bound
+func (q Query) upper() string {
+	upper := q.End
+	if q.Prefix != "" {
+		// the prefix with its last byte incremented is above every key with the prefix
+		b := []byte(q.Prefix)
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] < 0xff {
+				b[i]++
+				if p := string(b[:i+1]); upper == "" || p < upper {
+					upper = p
+				}
+				break
+			}
+		}
+	}
+	return upper
+}
+
+// limited returns true once count values have been visited
+func (q Query) limited(count int) bool {
+	return q.Limit > 0 && count >= q.Limit
+}
+
+// matches returns true if the query selects the key
+func (q Query) matches(key string) bool {
+	if key < q.lower() || !strings.HasPrefix(key, q.Prefix) {
+		return false
+	}
+	upper := q.upper()
+	return upper == "" || key < upper
+}
+
+// follows returns true if the entry is after the query's cursor
+func (q Query) follows(key, guid string) bool {
+	if q.After == "" {
+		return true
+	}
+	if q.Reverse {
+		return Cursor(key, guid) < q.After
+	}
+	return Cursor(key, guid) > q.After
+}
+
+// indexEntry is a key and the guid of the value indexed by it
+type indexEntry struct {
+	key  string
+	guid string
+}
+
+// query selects the matching entries, in order of key then guid. Limit is left to the caller.
+func query(entries []indexEntry, q Query) []indexEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].guid < entries[j].guid
+	})
+
+	selected := []indexEntry{}
+	for i := range entries {
+		e := entries[i]
+		if q.Reverse {
+			e = entries[len(entries)-1-i]
+		}
+		if q.matches(e.key) && q.follows(e.key, e.guid) {
+			selected = append(selected, e)
+		}
+	}
+	return selected
+}
+
+// collect returns a visitor appending the guids it visits
+func collect(guids *[]string) Visitor {
+	*guids = []string{}
+	return func(key, guid string, decode func(interface{}) error) (bool, error) {
+		*guids = append(*guids, guid)
+		return true, nil
+	}
+}
@@ -0,0 +1,22 @@
+package persist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var nameIndex = Index{
+	Name:   "name",
+	Unique: true,
+	Key:    func(v interface{}) string { return v.(*data).Name },
+	New:    func() interface{} { return &data{} },
+}
+
+func TestTimeKey(t *testing.T) {
+	earlier := time.Date(2017, 5, 1, 9, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Millisecond)
+	assert.True(t, TimeKey(earlier) < TimeKey(later))
+	assert.Equal(t, len(TimeKey(earlier)), len(TimeKey(later)))
+}
@@ -2,10 +2,15 @@ package persist
 
 import (
 	"encoding/gob"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"sync"
 
@@ -14,10 +19,21 @@ import (
 	uuid "github.com/nu7hatch/gouuid"
 )
 
+// revisionsDir holds the revisions of values, a file per value under a directory per namespace
+const revisionsDir = ".revisions"
+
 type localFileStore struct {
 	path      string
 	storeLock *sync.Mutex
-	nsLocks   map[string]*sync.Mutex
+	nsLocks   map[string]*namespaceLock
+	indexes   map[string][]*mapIndex
+}
+
+// namespaceLock is held while a namespace is read or written. A deleted namespace's lock is not
+// used again, operations waiting for it when the namespace was deleted find the namespace missing.
+type namespaceLock struct {
+	sync.Mutex
+	deleted bool
 }
 
 // NewLocalFileStore creates a Store object that stores to the local file system using Glob encoding
@@ -27,8 +43,8 @@ func NewLocalFileStore(dir string) (Store, error) {
 		return nil, err
 	}
 
-	l := make(map[string]*sync.Mutex)
-	lfs := &localFileStore{dir, &sync.Mutex{}, l}
+	l := make(map[string]*namespaceLock)
+	lfs := &localFileStore{dir, &sync.Mutex{}, l, make(map[string][]*mapIndex)}
 
 	// scan for namespaces
 	dirs, err := filepath.Glob(path.Join(dir, "*"))
@@ -38,6 +54,9 @@ func NewLocalFileStore(dir string) (Store, error) {
 
 	for _, dir := range dirs {
 		ns := path.Base(dir)
+		if ns == revisionsDir {
+			continue
+		}
 		log.Printf("[DEBUG] Found namespace '%s'", ns)
 		lfs.CreateNamespace(ns)
 	}
@@ -45,27 +64,41 @@ func NewLocalFileStore(dir string) (Store, error) {
 	return lfs, err
 }
 
-// Destroy
-func (lfs *localFileStore) Destroy() (err error) {
-	l := make(map[string]*sync.Mutex)
-	err = os.RemoveAll(lfs.path)
-	lfs = &localFileStore{
-		lfs.path,
-		&sync.Mutex{},
-		l,
+// Destroy removes every file of the store, leaving it empty
+func (lfs *localFileStore) Destroy() error {
+	lfs.lockStore()
+	defer lfs.unlockStore()
+
+	// wait for operations on each namespace to finish
+	for ns, l := range lfs.nsLocks {
+		l.Lock()
+		l.deleted = true
+		l.Unlock()
+		delete(lfs.nsLocks, ns)
 	}
-	return
+	lfs.indexes = make(map[string][]*mapIndex)
+
+	err := os.RemoveAll(lfs.path)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(lfs.path, os.ModePerm)
 }
 
 // List returns the keys stored in a namespace
-func (lfs *localFileStore) List(ns string) (guids []string, err error) {
+func (lfs *localFileStore) List(ns string) ([]string, error) {
 
-	err = lfs.lock(ns)
+	l, err := lfs.lock(ns)
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer lfs.unlock(ns)
+	defer l.Unlock()
+
+	return lfs.guids(ns)
+}
 
+// guids returns the keys stored in a namespace. The namespace must be locked.
+func (lfs *localFileStore) guids(ns string) (guids []string, err error) {
 	guids, err = filepath.Glob(path.Join(lfs.path, ns, "*"))
 
 	if err != nil {
@@ -82,42 +115,98 @@ func (lfs *localFileStore) List(ns string) (guids []string, err error) {
 
 // Get returns
 func (lfs *localFileStore) Get(ns, guid string, value interface{}) error {
+	_, err := lfs.GetRevision(ns, guid, value)
+	return err
+}
+
+// GetRevision returns a value and its revision
+func (lfs *localFileStore) GetRevision(ns, guid string, value interface{}) (uint64, error) {
 
 	log.Printf("[DEBUG] Getting item from store namespace: %s guid: %s", ns, guid)
-	err := lfs.lock(ns)
+	l, err := lfs.lock(ns)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer lfs.unlock(ns)
+	defer l.Unlock()
 
-	fp := path.Join(lfs.path, ns, guid)
+	data, rev, err := lfs.getEncoded(ns, guid)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DEBUG] Decoding value for %s %s", ns, guid)
+	return rev, decode(data, value)
+}
 
-	log.Printf("[DEBUG] Opening %s for reading", fp)
-	f, err := os.Open(fp)
-	defer func() {
-		log.Printf("[DEBUG] Closing file %s", fp)
-		f.Close()
-	}()
+// GetEncoded returns the contents of a value's file and its revision
+func (lfs *localFileStore) GetEncoded(ns, guid string) ([]byte, uint64, error) {
+	l, err := lfs.lock(ns)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer l.Unlock()
+
+	return lfs.getEncoded(ns, guid)
+}
 
+// getEncoded returns the contents of a value's file and its revision. The namespace must be locked.
+func (lfs *localFileStore) getEncoded(ns, guid string) ([]byte, uint64, error) {
+	fp := path.Join(lfs.path, ns, guid)
+
+	log.Printf("[DEBUG] Reading %s", fp)
+	data, err := ioutil.ReadFile(fp)
 	if err != nil {
-		log.Printf("[WARN] Error opening file '%s': %s", fp, err)
+		log.Printf("[WARN] Error reading file '%s': %s", fp, err)
 		if os.IsNotExist(err) {
 			err = &NotFoundError{err}
 		}
+		return nil, 0, err
+	}
+
+	rev, err := lfs.revision(ns, guid)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, rev, nil
+}
+
+// PutEncoded writes the file of a value with a revision, decoding it for each index
+func (lfs *localFileStore) PutEncoded(ns, guid string, data []byte, rev uint64) error {
+	l, err := lfs.lock(ns)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	values := make([]interface{}, len(lfs.indexes[ns]))
+	for i, idx := range lfs.indexes[ns] {
+		values[i] = idx.New()
+		if err := decode(data, values[i]); err != nil {
+			return err
+		}
+		if err := idx.check(ns, guid, values[i]); err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(path.Join(lfs.path, ns, guid), data, 0666)
+	if err != nil {
 		return err
 	}
 
-	log.Printf("[DEBUG] Decoding value for %s %s", ns, guid)
-	return gob.NewDecoder(f).Decode(value)
+	for i, idx := range lfs.indexes[ns] {
+		idx.add(guid, values[i])
+	}
+	return lfs.setRevision(ns, guid, rev)
 }
 
 func (lfs *localFileStore) Create(ns string, value interface{}) (string, error) {
 
-	err := lfs.lock(ns)
+	l, err := lfs.lock(ns)
 	if err != nil {
 		return "", err
 	}
-	defer lfs.unlock(ns)
+	defer l.Unlock()
 
 	// create a guid
 	guidPtr, err := uuid.NewV4()
@@ -126,6 +215,11 @@ func (lfs *localFileStore) Create(ns string, value interface{}) (string, error)
 	}
 	guid := guidPtr.String()
 
+	err = lfs.checkIndexes(ns, guid, value)
+	if err != nil {
+		return "", err
+	}
+
 	// open file
 	p := path.Join(lfs.path, ns, guid)
 	f, err := os.Create(p)
@@ -145,41 +239,170 @@ func (lfs *localFileStore) Create(ns string, value interface{}) (string, error)
 		return "", err
 	}
 
-	return guid, nil
+	lfs.updateIndexes(ns, guid, value)
+	return guid, lfs.setRevision(ns, guid, 1)
 }
 
 func (lfs *localFileStore) Update(ns, guid string, value interface{}) error {
 
-	err := lfs.lock(ns)
+	l, err := lfs.lock(ns)
 	if err != nil {
 		return err
 	}
-	defer lfs.unlock(ns)
+	defer l.Unlock()
+
+	_, err = lfs.put(ns, guid, value)
+	return err
+}
+
+// CompareAndUpdate updates a value only if its revision is still revision
+func (lfs *localFileStore) CompareAndUpdate(ns, guid string, rev uint64, value interface{}) (uint64, error) {
+
+	l, err := lfs.lock(ns)
+	if err != nil {
+		return 0, err
+	}
+	defer l.Unlock()
+
+	if _, err := os.Stat(path.Join(lfs.path, ns, guid)); os.IsNotExist(err) {
+		return 0, &NotFoundError{err}
+	}
+
+	current, err := lfs.revision(ns, guid)
+	if err != nil {
+		return 0, err
+	}
+	if current != rev {
+		return 0, &ConflictError{ns, guid, rev, current}
+	}
+
+	return lfs.put(ns, guid, value)
+}
 
+// put writes an existing value and increments its revision, the namespace must be locked
+func (lfs *localFileStore) put(ns, guid string, value interface{}) (uint64, error) {
 	p := path.Join(lfs.path, ns, guid)
 	if _, err := os.Stat(p); os.IsNotExist(err) {
-		return fmt.Errorf("guid '%s' does not exist", guid)
+		return 0, &NotFoundError{err}
 	}
 
-	f, err := os.OpenFile(p, os.O_WRONLY, 0666)
+	err := lfs.checkIndexes(ns, guid, value)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return 0, err
+	}
 	defer f.Close()
 
+	err = gob.NewEncoder(f).Encode(value)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return gob.NewEncoder(f).Encode(value)
+	lfs.updateIndexes(ns, guid, value)
+
+	rev, err := lfs.revision(ns, guid)
+	if err != nil {
+		return 0, err
+	}
+	return rev + 1, lfs.setRevision(ns, guid, rev+1)
 }
 
 func (lfs *localFileStore) Delete(ns, guid string) error {
 
-	err := lfs.lock(ns)
+	l, err := lfs.lock(ns)
 	if err != nil {
 		return err
 	}
-	defer lfs.unlock(ns)
+	defer l.Unlock()
 
 	p := path.Join(lfs.path, ns, guid)
-	return os.Remove(p)
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return &NotFoundError{err}
+	}
+	if err != nil {
+		return err
+	}
+	lfs.removeIndexes(ns, guid)
+
+	err = os.Remove(path.Join(lfs.path, revisionsDir, ns, guid))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// revision returns the revision of a value, 0 when none is recorded. The namespace must be locked.
+func (lfs *localFileStore) revision(ns, guid string) (uint64, error) {
+	b, err := ioutil.ReadFile(path.Join(lfs.path, revisionsDir, ns, guid))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// setRevision records the revision of a value. The namespace must be locked.
+func (lfs *localFileStore) setRevision(ns, guid string, rev uint64) error {
+	dir := path.Join(lfs.path, revisionsDir, ns)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, guid), []byte(strconv.FormatUint(rev, 10)), 0666)
+}
+
+// Namespaces lists the namespaces in the store
+func (lfs *localFileStore) Namespaces() ([]string, error) {
+	lfs.lockStore()
+	defer lfs.unlockStore()
+
+	namespaces := []string{}
+	for ns := range lfs.nsLocks {
+		if !reserved(ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// Backup copies the files of the store to a new directory in dir
+func (lfs *localFileStore) Backup(dir string) (string, error) {
+	lfs.lockStore()
+	defer lfs.unlockStore()
+
+	backup := path.Join(dir, fmt.Sprintf("local-%s", time.Now().UTC().Format(backupTimeFormat)))
+	return backup, filepath.Walk(lfs.path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// backups may be kept in the store's directory
+		if p == dir {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(lfs.path, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(backup, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, b, 0600)
+	})
 }
 
 // CreateNamespace ensures a namespace is configured
@@ -190,27 +413,56 @@ func (lfs *localFileStore) CreateNamespace(ns string) error {
 	lfs.lockStore()
 	defer lfs.unlockStore()
 
+	// operations may hold the lock of an existing namespace
+	if _, ok := lfs.nsLocks[ns]; !ok {
+		lfs.nsLocks[ns] = &namespaceLock{}
+	}
+
 	nsPath := path.Join(lfs.path, ns)
-	lfs.nsLocks[ns] = &sync.Mutex{}
 	return os.MkdirAll(nsPath, os.ModePerm)
 }
 
-func (lfs *localFileStore) lock(ns string) error {
-	log.Printf("[DEBUG] Locking persist namespace '%s'", ns)
+// DeleteNamespace removes a namespace, its values and their revisions
+func (lfs *localFileStore) DeleteNamespace(ns string) error {
+
+	log.Printf("[DEBUG] Deleting namespace '%s'", ns)
+
+	lfs.lockStore()
+	defer lfs.unlockStore()
 
-	var v *sync.Mutex
-	var ok bool
-	if v, ok = lfs.nsLocks[ns]; !ok {
-		return fmt.Errorf("Namespace '%s' does not exist!", ns)
+	// wait for operations on the namespace to finish
+	if l, ok := lfs.nsLocks[ns]; ok {
+		l.Lock()
+		l.deleted = true
+		defer l.Unlock()
 	}
+	delete(lfs.nsLocks, ns)
+	delete(lfs.indexes, ns)
 
-	v.Lock()
-	return nil
+	err := os.RemoveAll(path.Join(lfs.path, ns))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path.Join(lfs.path, revisionsDir, ns))
 }
 
-func (lfs *localFileStore) unlock(ns string) {
-	log.Printf("[DEBUG] Unlocking persist namespace '%s'", ns)
-	lfs.nsLocks[ns].Unlock()
+// lock locks a namespace for an operation, returning the lock to release when it is done
+func (lfs *localFileStore) lock(ns string) (*namespaceLock, error) {
+	log.Printf("[DEBUG] Locking persist namespace '%s'", ns)
+
+	lfs.storeLock.Lock()
+	l, ok := lfs.nsLocks[ns]
+	lfs.storeLock.Unlock()
+	if !ok {
+		return nil, namespaceNotFound(ns)
+	}
+
+	l.Lock()
+	if l.deleted {
+		l.Unlock()
+		return nil, namespaceNotFound(ns)
+	}
+	return l, nil
 }
 
 func (lfs *localFileStore) lockStore() {
@@ -0,0 +1,165 @@
+package persist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+// mapIndex is an index of the local file and memory stores, held in memory and built when it is declared
+type mapIndex struct {
+	Index
+	keys map[string]string
+}
+
+// CreateIndex declares an index on a namespace and builds it by reading every value in the namespace
+func (lfs *localFileStore) CreateIndex(ns string, index Index) error {
+	l, err := lfs.lock(ns)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	for _, idx := range lfs.indexes[ns] {
+		if idx.Name == index.Name {
+			return nil
+		}
+	}
+
+	guids, err := lfs.guids(ns)
+	if err != nil {
+		return err
+	}
+
+	idx := &mapIndex{index, make(map[string]string)}
+	for _, guid := range guids {
+		value := index.New()
+		if err := lfs.read(ns, guid, value); err != nil {
+			return err
+		}
+
+		err := idx.check(ns, guid, value)
+		if dup, ok := err.(*DuplicateError); ok {
+			log.Printf("[WARN] Not indexing '%s', %s", guid, dup)
+			continue
+		}
+		idx.add(guid, value)
+	}
+
+	log.Printf("[DEBUG] Created index '%s' on namespace '%s'", index.Name, ns)
+	lfs.indexes[ns] = append(lfs.indexes[ns], idx)
+	return nil
+}
+
+// Query returns the guids of values in an index matching the query, ordered by key
+func (lfs *localFileStore) Query(ns, index string, q Query) (guids []string, err error) {
+	err = lfs.Range(ns, index, q, collect(&guids))
+	return
+}
+
+// Range visits the values in an index matching the query, the namespace is locked while visiting
+func (lfs *localFileStore) Range(ns, index string, q Query, visit Visitor) error {
+	l, err := lfs.lock(ns)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	for _, idx := range lfs.indexes[ns] {
+		if idx.Name != index {
+			continue
+		}
+
+		for i, e := range idx.query(q) {
+			if q.limited(i) {
+				break
+			}
+
+			guid := e.guid
+			more, err := visit(e.key, guid, func(value interface{}) error {
+				err := lfs.read(ns, guid, value)
+				if os.IsNotExist(err) {
+					return &NotFoundError{err}
+				}
+				return err
+			})
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Index '%s' on namespace '%s' does not exist", index, ns)
+}
+
+// checkIndexes checks a value can be indexed by each index on its namespace. The namespace must be locked.
+func (lfs *localFileStore) checkIndexes(ns, guid string, value interface{}) error {
+	for _, idx := range lfs.indexes[ns] {
+		if err := idx.check(ns, guid, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateIndexes indexes a value in each index on its namespace. The namespace must be locked.
+func (lfs *localFileStore) updateIndexes(ns, guid string, value interface{}) {
+	for _, idx := range lfs.indexes[ns] {
+		idx.add(guid, value)
+	}
+}
+
+// removeIndexes removes a value from each index on its namespace. The namespace must be locked.
+func (lfs *localFileStore) removeIndexes(ns, guid string) {
+	for _, idx := range lfs.indexes[ns] {
+		delete(idx.keys, guid)
+	}
+}
+
+// read decodes a stored value. The namespace must be locked.
+func (lfs *localFileStore) read(ns, guid string, value interface{}) error {
+	data, err := ioutil.ReadFile(path.Join(lfs.path, ns, guid))
+	if err != nil {
+		return err
+	}
+	return decode(data, value)
+}
+
+// check returns a *DuplicateError if a unique index has the value's key for another guid
+func (idx *mapIndex) check(ns, guid string, value interface{}) error {
+	if !idx.Unique {
+		return nil
+	}
+
+	key := idx.Key(value)
+	if key == "" {
+		return nil
+	}
+
+	for other, k := range idx.keys {
+		if k == key && other != guid {
+			return &DuplicateError{ns, idx.Name, key, other}
+		}
+	}
+	return nil
+}
+
+// query returns the entries of the index matching the query, in order
+func (idx *mapIndex) query(q Query) []indexEntry {
+	entries := make([]indexEntry, 0, len(idx.keys))
+	for guid, key := range idx.keys {
+		entries = append(entries, indexEntry{key, guid})
+	}
+	return query(entries, q)
+}
+
+// add indexes a value, replacing its previous entry
+func (idx *mapIndex) add(guid string, value interface{}) {
+	delete(idx.keys, guid)
+	if key := idx.Key(value); key != "" {
+		idx.keys[guid] = key
+	}
+}
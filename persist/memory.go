@@ -0,0 +1,342 @@
+package persist
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// memoryValue is a value of the memory store with its revision
+type memoryValue struct {
+	data     []byte
+	revision uint64
+}
+
+// memoryNamespace holds the values and indexes of a namespace. Guids are sequence numbers, as in
+// the bolt store.
+type memoryNamespace struct {
+	values   map[string]*memoryValue
+	sequence uint64
+	indexes  []*mapIndex
+}
+
+type memoryStore struct {
+	lock       sync.RWMutex
+	namespaces map[string]*memoryNamespace
+}
+
+// NewMemoryStore creates a store that holds values in memory, for tests and runs that keep nothing.
+// Values are encoded when they are stored, so callers never share a stored value.
+func NewMemoryStore() Store {
+	return &memoryStore{namespaces: make(map[string]*memoryNamespace)}
+}
+
+// List returns the guids of a namespace in order
+func (m *memoryStore) List(ns string) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	n, err := m.namespace(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	guids := make([]string, 0, len(n.values))
+	for guid := range n.values {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	return guids, nil
+}
+
+// Get retrieves a value by guid
+func (m *memoryStore) Get(ns, guid string, value interface{}) error {
+	_, err := m.GetRevision(ns, guid, value)
+	return err
+}
+
+// GetRevision retrieves a value by guid along with its revision
+func (m *memoryStore) GetRevision(ns, guid string, value interface{}) (uint64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	v, err := m.value(ns, guid)
+	if err != nil {
+		return 0, err
+	}
+	return v.revision, decode(v.data, value)
+}
+
+// Create stores a value by the next guid of its namespace
+func (m *memoryStore) Create(ns string, value interface{}) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n, err := m.namespace(ns)
+	if err != nil {
+		return "", err
+	}
+
+	guid := strconv.FormatUint(n.sequence+1, 10)
+	if err := n.put(ns, guid, value, 1); err != nil {
+		return "", err
+	}
+	n.sequence++
+	return guid, nil
+}
+
+// Update updates a stored value, incrementing its revision
+func (m *memoryStore) Update(ns, guid string, value interface{}) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	v, err := m.value(ns, guid)
+	if err != nil {
+		return err
+	}
+	return m.namespaces[ns].put(ns, guid, value, v.revision+1)
+}
+
+// CompareAndUpdate updates a stored value only if its revision is still revision
+func (m *memoryStore) CompareAndUpdate(ns, guid string, rev uint64, value interface{}) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	v, err := m.value(ns, guid)
+	if err != nil {
+		return 0, err
+	}
+	if v.revision != rev {
+		return 0, &ConflictError{ns, guid, rev, v.revision}
+	}
+	return rev + 1, m.namespaces[ns].put(ns, guid, value, rev+1)
+}
+
+// GetEncoded returns a copy of a value as it is encoded, and its revision
+func (m *memoryStore) GetEncoded(ns, guid string) ([]byte, uint64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	v, err := m.value(ns, guid)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append([]byte(nil), v.data...), v.revision, nil
+}
+
+// PutEncoded stores an encoded value by guid with a revision, decoding it for each index
+func (m *memoryStore) PutEncoded(ns, guid string, data []byte, rev uint64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n, err := m.namespace(ns)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(n.indexes))
+	for i, idx := range n.indexes {
+		values[i] = idx.New()
+		if err := decode(data, values[i]); err != nil {
+			return err
+		}
+		if err := idx.check(ns, guid, values[i]); err != nil {
+			return err
+		}
+	}
+
+	for i, idx := range n.indexes {
+		idx.add(guid, values[i])
+	}
+	n.values[guid] = &memoryValue{append([]byte(nil), data...), rev}
+
+	// values created later must not be given the guid of a value put
+	if seq, err := strconv.ParseUint(guid, 10, 64); err == nil && seq > n.sequence {
+		n.sequence = seq
+	}
+	return nil
+}
+
+// Delete removes a value and its index entries
+func (m *memoryStore) Delete(ns, guid string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, err := m.value(ns, guid); err != nil {
+		return err
+	}
+
+	n := m.namespaces[ns]
+	for _, idx := range n.indexes {
+		delete(idx.keys, guid)
+	}
+	delete(n.values, guid)
+	return nil
+}
+
+// Namespaces lists the namespaces in the store in order
+func (m *memoryStore) Namespaces() ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	namespaces := []string{}
+	for ns := range m.namespaces {
+		if !reserved(ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// CreateNamespace ensures that a namespace exists
+func (m *memoryStore) CreateNamespace(ns string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.namespaces[ns]; !ok {
+		m.namespaces[ns] = &memoryNamespace{values: make(map[string]*memoryValue)}
+	}
+	return nil
+}
+
+// DeleteNamespace removes a namespace with its values and indexes
+func (m *memoryStore) DeleteNamespace(ns string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.namespaces, ns)
+	return nil
+}
+
+// CreateIndex declares an index on a namespace and builds it by decoding every value in the namespace
+func (m *memoryStore) CreateIndex(ns string, index Index) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n, err := m.namespace(ns)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range n.indexes {
+		if idx.Name == index.Name {
+			return nil
+		}
+	}
+
+	idx := &mapIndex{index, make(map[string]string)}
+	for guid, v := range n.values {
+		value := index.New()
+		if err := decode(v.data, value); err != nil {
+			return err
+		}
+
+		err := idx.check(ns, guid, value)
+		if dup, ok := err.(*DuplicateError); ok {
+			log.Printf("[WARN] Not indexing '%s', %s", guid, dup)
+			continue
+		}
+		idx.add(guid, value)
+	}
+
+	n.indexes = append(n.indexes, idx)
+	return nil
+}
+
+// Query returns the guids of values in an index matching the query, ordered by key
+func (m *memoryStore) Query(ns, index string, q Query) (guids []string, err error) {
+	err = m.Range(ns, index, q, collect(&guids))
+	return
+}
+
+// Range visits the values in an index matching the query. The store cannot be modified while
+// visiting.
+func (m *memoryStore) Range(ns, index string, q Query, visit Visitor) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	n, err := m.namespace(ns)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range n.indexes {
+		if idx.Name != index {
+			continue
+		}
+
+		for i, e := range idx.query(q) {
+			if q.limited(i) {
+				break
+			}
+
+			v := n.values[e.guid]
+			more, err := visit(e.key, e.guid, func(value interface{}) error {
+				return decode(v.data, value)
+			})
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Index '%s' on namespace '%s' does not exist", index, ns)
+}
+
+// Destroy removes every namespace, leaving the store empty
+func (m *memoryStore) Destroy() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.namespaces = make(map[string]*memoryNamespace)
+	return nil
+}
+
+// namespace returns a namespace, a *NotFoundError when it does not exist. The store must be locked.
+func (m *memoryStore) namespace(ns string) (*memoryNamespace, error) {
+	n, ok := m.namespaces[ns]
+	if !ok {
+		return nil, namespaceNotFound(ns)
+	}
+	return n, nil
+}
+
+// value returns a stored value, a *NotFoundError when it or its namespace does not exist. The store
+// must be locked.
+func (m *memoryStore) value(ns, guid string) (*memoryValue, error) {
+	n, err := m.namespace(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := n.values[guid]
+	if !ok {
+		return nil, &NotFoundError{fmt.Errorf("'%s' not found in '%s'", guid, ns)}
+	}
+	return v, nil
+}
+
+// put encodes and stores a value with a revision, updating its index entries. Nothing is stored
+// when a unique index has the value's key for another guid.
+func (n *memoryNamespace) put(ns, guid string, value interface{}, rev uint64) error {
+	for _, idx := range n.indexes {
+		if err := idx.check(ns, guid, value); err != nil {
+			return err
+		}
+	}
+
+	data, err := GobEncoding.encode(value)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range n.indexes {
+		idx.add(guid, value)
+	}
+	n.values[guid] = &memoryValue{data, rev}
+	return nil
+}
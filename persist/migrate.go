@@ -0,0 +1,140 @@
+package persist
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// schemaNS holds the schema version of a store. Namespaces beginning with "__" are reserved by stores.
+const schemaNS = "__schema"
+
+// backupTimeFormat names backups by the time they were taken
+const backupTimeFormat = "20060102T150405Z"
+
+// reserved returns true if a namespace is reserved by stores
+func reserved(ns string) bool {
+	return strings.HasPrefix(ns, "__")
+}
+
+// schema is the stored schema version
+type schema struct {
+	Version int
+}
+
+// Migration upgrades the values in a store to a version of the schema. Migrations run once, in order
+// of version, when a store is opened.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(store Store) error
+}
+
+// Backuper is implemented by stores that can copy their values to a backup
+type Backuper interface {
+	// Backup copies the store to a new backup in dir, returning its path
+	Backup(dir string) (string, error)
+}
+
+// migrations are the registered migrations, ordered by version
+var migrations []Migration
+
+// RegisterMigration registers a migration, from the init function of the package owning the values
+// it migrates. Versions are unique.
+func RegisterMigration(m Migration) {
+	for _, registered := range migrations {
+		if registered.Version == m.Version {
+			panic(fmt.Sprintf("Migration %d is registered twice", m.Version))
+		}
+	}
+
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+// SchemaVersion returns the schema version of a store, 0 when no migration has run
+func SchemaVersion(store Store) (int, error) {
+	s, _, err := readSchema(store)
+	return s.Version, err
+}
+
+// Migrate runs the registered migrations newer than the schema version of the store, in order, after
+// backing the store up to backupDir. A dry run returns the migrations that would run without running
+// them.
+func Migrate(store Store, backupDir string, dryRun bool) ([]Migration, error) {
+	return migrate(store, migrations, backupDir, dryRun)
+}
+
+// migrate runs the migrations newer than the schema version of the store
+func migrate(store Store, migrations []Migration, backupDir string, dryRun bool) ([]Migration, error) {
+	s, guid, err := readSchema(store)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := 0
+	pending := []Migration{}
+	for _, m := range migrations {
+		if m.Version > s.Version {
+			pending = append(pending, m)
+		}
+		latest = m.Version
+	}
+
+	if s.Version > latest {
+		return nil, fmt.Errorf("Store schema version %d is newer than version %d, it was written by a newer tfwatch", s.Version, latest)
+	}
+
+	if dryRun || len(pending) == 0 {
+		return pending, nil
+	}
+
+	if b, ok := Unwrap(store).(Backuper); ok {
+		backup, err := b.Backup(backupDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error backing up store before migrating: %s", err)
+		}
+		log.Printf("[INFO] Backed up store to '%s' before migrating", backup)
+	}
+
+	for _, m := range pending {
+		log.Printf("[INFO] Migrating store to version %d: %s", m.Version, m.Description)
+		if m.Migrate != nil {
+			if err := m.Migrate(store); err != nil {
+				return nil, fmt.Errorf("Error migrating store to version %d: %s", m.Version, err)
+			}
+		}
+
+		// the version is recorded after each migration, so a failed migration is the next to run
+		s.Version = m.Version
+		if guid == "" {
+			guid, err = store.Create(schemaNS, &s)
+		} else {
+			err = store.Update(schemaNS, guid, &s)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pending, nil
+}
+
+// readSchema returns the schema of a store and the guid it is stored by, empty when none is stored
+func readSchema(store Store) (s schema, guid string, err error) {
+	if err = store.CreateNamespace(schemaNS); err != nil {
+		return
+	}
+
+	guids, err := store.List(schemaNS)
+	if err != nil || len(guids) == 0 {
+		return
+	}
+
+	guid = guids[0]
+	err = store.Get(schemaNS, guid, &s)
+	return
+}
@@ -0,0 +1,71 @@
+package persist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Migrate(t *testing.T) {
+	store, cleanup := createStore()
+	defer cleanup()
+
+	backups, err := ioutil.TempDir("", "tfwatch-backups")
+	assert.Nil(t, err)
+	defer os.RemoveAll(backups)
+
+	var ran []int
+	migration := func(version int) Migration {
+		return Migration{version, fmt.Sprintf("Migration %d", version), func(Store) error {
+			ran = append(ran, version)
+			return nil
+		}}
+	}
+	migrations := []Migration{migration(1), migration(2)}
+
+	// a dry run migrates nothing
+	pending, err := migrate(store, migrations, backups, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pending))
+	assert.Equal(t, 0, len(ran))
+
+	pending, err = migrate(store, migrations, backups, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pending))
+	assert.Equal(t, []int{1, 2}, ran)
+	version, err := SchemaVersion(store)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+
+	// the store was backed up before migrating
+	files, err := ioutil.ReadDir(backups)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+
+	// only new migrations run
+	migrations = append(migrations, migration(3))
+	pending, err = migrate(store, migrations, backups, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, []int{1, 2, 3}, ran)
+
+	// failed migrations run again
+	migrations = append(migrations, Migration{4, "Fails", func(Store) error { return fmt.Errorf("failed") }})
+	_, err = migrate(store, migrations, backups, false)
+	assert.NotNil(t, err)
+	version, err = SchemaVersion(store)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version)
+
+	// stores migrated by newer versions are not opened
+	_, err = migrate(store, migrations[:2], backups, false)
+	assert.NotNil(t, err)
+
+	// the schema is not a namespace of values
+	namespaces, err := store.Namespaces()
+	assert.Nil(t, err)
+	assert.NotContains(t, namespaces, schemaNS)
+}
@@ -0,0 +1,457 @@
+package persist
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sqlDialect is what differs between the databases of the SQL store
+type sqlDialect struct {
+	// driver is the database/sql driver name
+	driver string
+
+	// types replaces the placeholders of column types in the schema
+	types *strings.Replacer
+
+	// positional converts ? parameters to the database's parameters
+	positional bool
+
+	// lockRow is appended to selects of values that are about to be updated
+	lockRow string
+
+	// connections limits the open connections, 0 is unlimited
+	connections int
+
+	// setup is run when the database is opened
+	setup []string
+}
+
+// sqlDialects are the databases the SQL store can use. Text is compared byte by byte in both, so
+// guids, namespaces and index keys are ordered as in other stores.
+var sqlDialects = map[string]*sqlDialect{
+	"sqlite": {
+		driver:      "sqlite",
+		types:       strings.NewReplacer("{text}", "TEXT", "{blob}", "BLOB"),
+		connections: 1,
+		setup:       []string{"PRAGMA busy_timeout = 5000", "PRAGMA journal_mode = WAL"},
+	},
+	"postgres": {
+		driver:     "postgres",
+		types:      strings.NewReplacer("{text}", `TEXT COLLATE "C"`, "{blob}", "BYTEA"),
+		positional: true,
+		lockRow:    " FOR UPDATE",
+	},
+}
+
+// sqlMigration changes the schema of the SQL store
+type sqlMigration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// sqlMigrations are run in order when the store is opened, each once. Migrations are never changed
+// once released, the schema is changed by adding one.
+var sqlMigrations = []sqlMigration{
+	{1, "Create the namespaces, values and index entries tables", []string{
+		`CREATE TABLE tfwatch_namespaces (
+			name {text} PRIMARY KEY,
+			sequence BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE tfwatch_values (
+			namespace {text} NOT NULL,
+			guid {text} NOT NULL,
+			data {blob} NOT NULL,
+			revision BIGINT NOT NULL,
+			PRIMARY KEY (namespace, guid)
+		)`,
+		`CREATE TABLE tfwatch_index_entries (
+			namespace {text} NOT NULL,
+			index_name {text} NOT NULL,
+			guid {text} NOT NULL,
+			index_key {text} NOT NULL,
+			unique_key {text},
+			PRIMARY KEY (namespace, index_name, guid),
+			UNIQUE (namespace, index_name, unique_key)
+		)`,
+		`CREATE INDEX tfwatch_index_entries_key ON tfwatch_index_entries (namespace, index_name, index_key, guid)`,
+	}},
+}
+
+// sqlStore keeps namespaces, values and index entries in a table each, with the same schema in each
+// database. Guids are sequence numbers, as in the bolt store.
+type sqlStore struct {
+	db        *sql.DB
+	dialect   *sqlDialect
+	encoding  Encoding
+	indexes   map[string][]Index
+	indexLock sync.RWMutex
+}
+
+// NewSQLStore creates a store in a SQLite or Postgres database, migrating its schema. The dialect
+// is sqlite or postgres, and dsn is the file of a SQLite database or the URL of a Postgres database.
+func NewSQLStore(dialect, dsn string, encoding Encoding) (Store, error) {
+	d, ok := sqlDialects[dialect]
+	if !ok {
+		return nil, fmt.Errorf("Unknown SQL dialect '%s', expected sqlite or postgres", dialect)
+	}
+
+	log.Printf("[INFO] SQL store using %s", dialect)
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if d.connections > 0 {
+		db.SetMaxOpenConns(d.connections)
+	}
+
+	s := &sqlStore{db: db, dialect: d, encoding: encoding, indexes: make(map[string][]Index)}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate runs the setup of the dialect, and the migrations of the schema that have not run
+func (s *sqlStore) migrate() error {
+	for _, stmt := range s.dialect.setup {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS tfwatch_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM tfwatch_migrations").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range sqlMigrations {
+		if m.Version <= version {
+			continue
+		}
+
+		log.Printf("[INFO] Migrating SQL store to version %d: %s", m.Version, m.Description)
+		err := s.tx(func(tx *sql.Tx) error {
+			for _, stmt := range m.Statements {
+				if _, err := tx.Exec(s.dialect.types.Replace(stmt)); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(s.bind("INSERT INTO tfwatch_migrations (version, description) VALUES (?, ?)"), m.Version, m.Description)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error migrating SQL store to version %d: %s", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// List retrieves the guids in a namespace in order
+func (s *sqlStore) List(ns string) (guids []string, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		if _, err := s.sequence(tx, ns); err != nil {
+			return err
+		}
+
+		guids, err = s.strings(tx, "SELECT guid FROM tfwatch_values WHERE namespace = ? ORDER BY guid", ns)
+		return err
+	})
+	return
+}
+
+// Get retrieves a value from the Store by guid
+func (s *sqlStore) Get(ns, guid string, value interface{}) error {
+	_, err := s.GetRevision(ns, guid, value)
+	return err
+}
+
+// GetRevision retrieves a value from the Store by guid along with its revision
+func (s *sqlStore) GetRevision(ns, guid string, value interface{}) (uint64, error) {
+	data, rev, err := s.GetEncoded(ns, guid)
+	if err != nil {
+		return 0, err
+	}
+	return rev, decode(data, value)
+}
+
+// Create stores a value by the next sequence number of its namespace
+func (s *sqlStore) Create(ns string, value interface{}) (guid string, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		// incrementing the sequence first locks the namespace against concurrent creates
+		res, err := tx.Exec(s.bind("UPDATE tfwatch_namespaces SET sequence = sequence + 1 WHERE name = ?"), ns)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return namespaceNotFound(ns)
+		}
+
+		seq, err := s.sequence(tx, ns)
+		if err != nil {
+			return err
+		}
+
+		guid = strconv.FormatUint(seq, 10)
+		return s.write(tx, ns, guid, value, 1)
+	})
+	return
+}
+
+// Update updates a stored value, incrementing its revision
+func (s *sqlStore) Update(ns, guid string, value interface{}) error {
+	return s.tx(func(tx *sql.Tx) error {
+		_, rev, err := s.get(tx, ns, guid, s.dialect.lockRow)
+		if err != nil {
+			return err
+		}
+		return s.write(tx, ns, guid, value, rev+1)
+	})
+}
+
+// CompareAndUpdate updates a stored value only if its revision is still revision
+func (s *sqlStore) CompareAndUpdate(ns, guid string, rev uint64, value interface{}) (next uint64, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		_, current, err := s.get(tx, ns, guid, s.dialect.lockRow)
+		if err != nil {
+			return err
+		}
+		if current != rev {
+			return &ConflictError{ns, guid, rev, current}
+		}
+
+		next = rev + 1
+		return s.write(tx, ns, guid, value, next)
+	})
+	return
+}
+
+// GetEncoded retrieves a value as it is stored and its revision
+func (s *sqlStore) GetEncoded(ns, guid string) (data []byte, rev uint64, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		data, rev, err = s.get(tx, ns, guid, "")
+		return err
+	})
+	return
+}
+
+// PutEncoded stores an encoded value by guid with a revision, decoding it for each index
+func (s *sqlStore) PutEncoded(ns, guid string, data []byte, rev uint64) error {
+	return s.tx(func(tx *sql.Tx) error {
+		seq, err := s.sequence(tx, ns)
+		if err != nil {
+			return err
+		}
+
+		for _, index := range s.namespaceIndexes(ns) {
+			value := index.New()
+			if err := decode(data, value); err != nil {
+				return err
+			}
+			if err := s.indexValue(tx, ns, index, guid, value); err != nil {
+				return err
+			}
+		}
+
+		// values created later must not be given the guid of a value put
+		if n, err := strconv.ParseUint(guid, 10, 64); err == nil && n > seq {
+			_, err := tx.Exec(s.bind("UPDATE tfwatch_namespaces SET sequence = ? WHERE name = ?"), int64(n), ns)
+			if err != nil {
+				return err
+			}
+		}
+
+		return s.put(tx, ns, guid, data, rev)
+	})
+}
+
+// Delete removes a value and its index entries
+func (s *sqlStore) Delete(ns, guid string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		if _, _, err := s.get(tx, ns, guid, ""); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(s.bind("DELETE FROM tfwatch_values WHERE namespace = ? AND guid = ?"), ns, guid)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.bind("DELETE FROM tfwatch_index_entries WHERE namespace = ? AND guid = ?"), ns, guid)
+		return err
+	})
+}
+
+// Namespaces lists the namespaces in the database in order
+func (s *sqlStore) Namespaces() ([]string, error) {
+	namespaces := []string{}
+	err := s.tx(func(tx *sql.Tx) error {
+		names, err := s.strings(tx, "SELECT name FROM tfwatch_namespaces ORDER BY name")
+		for _, ns := range names {
+			if !reserved(ns) {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		return err
+	})
+	return namespaces, err
+}
+
+// CreateNamespace ensures that a namespace exists
+func (s *sqlStore) CreateNamespace(ns string) error {
+	_, err := s.db.Exec(s.bind("INSERT INTO tfwatch_namespaces (name) VALUES (?) ON CONFLICT (name) DO NOTHING"), ns)
+	return err
+}
+
+// DeleteNamespace removes a namespace with its values and index entries
+func (s *sqlStore) DeleteNamespace(ns string) error {
+	s.indexLock.Lock()
+	defer s.indexLock.Unlock()
+
+	err := s.tx(func(tx *sql.Tx) error {
+		for _, table := range []string{"tfwatch_index_entries", "tfwatch_values"} {
+			if _, err := tx.Exec(s.bind("DELETE FROM "+table+" WHERE namespace = ?"), ns); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(s.bind("DELETE FROM tfwatch_namespaces WHERE name = ?"), ns)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	delete(s.indexes, ns)
+	return nil
+}
+
+// Destroy removes every namespace, value and index entry, leaving the schema in place
+func (s *sqlStore) Destroy() error {
+	s.indexLock.Lock()
+	defer s.indexLock.Unlock()
+
+	err := s.tx(func(tx *sql.Tx) error {
+		for _, table := range []string{"tfwatch_index_entries", "tfwatch_values", "tfwatch_namespaces"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.indexes = make(map[string][]Index)
+	return nil
+}
+
+// Close closes the database
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// tx runs fn in a transaction, which is committed unless fn returns an error
+func (s *sqlStore) tx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// bind converts the ? parameters of a statement to the parameters of the database
+func (s *sqlStore) bind(stmt string) string {
+	if !s.dialect.positional {
+		return stmt
+	}
+
+	parts := strings.Split(stmt, "?")
+	bound := parts[0]
+	for i, part := range parts[1:] {
+		bound += "$" + strconv.Itoa(i+1) + part
+	}
+	return bound
+}
+
+// strings returns the single column of the rows of a query
+func (s *sqlStore) strings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// sequence returns the last sequence number given in a namespace, a *NotFoundError when the namespace
+// does not exist
+func (s *sqlStore) sequence(tx *sql.Tx, ns string) (seq uint64, err error) {
+	err = tx.QueryRow(s.bind("SELECT sequence FROM tfwatch_namespaces WHERE name = ?"), ns).Scan(&seq)
+	if err == sql.ErrNoRows {
+		err = namespaceNotFound(ns)
+	}
+	return
+}
+
+// get returns a stored value and its revision, a *NotFoundError when it or its namespace does not
+// exist. lock is appended to the select, locking the value's row until it is updated.
+func (s *sqlStore) get(tx *sql.Tx, ns, guid, lock string) (data []byte, rev uint64, err error) {
+	err = tx.QueryRow(s.bind("SELECT data, revision FROM tfwatch_values WHERE namespace = ? AND guid = ?"+lock), ns, guid).Scan(&data, &rev)
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	if _, err = s.sequence(tx, ns); err == nil {
+		err = &NotFoundError{fmt.Errorf("'%s' not found in '%s'", guid, ns)}
+	}
+	return
+}
+
+// write encodes a value and stores it with a revision, updating its index entries
+func (s *sqlStore) write(tx *sql.Tx, ns, guid string, value interface{}, rev uint64) error {
+	data, err := s.encoding.encode(value)
+	if err != nil {
+		return err
+	}
+
+	if err := s.updateIndexes(tx, ns, guid, value); err != nil {
+		return err
+	}
+	return s.put(tx, ns, guid, data, rev)
+}
+
+// put stores an encoded value with a revision, replacing any value stored by the guid
+func (s *sqlStore) put(tx *sql.Tx, ns, guid string, data []byte, rev uint64) error {
+	_, err := tx.Exec(s.bind(`INSERT INTO tfwatch_values (namespace, guid, data, revision) VALUES (?, ?, ?, ?)
+		ON CONFLICT (namespace, guid) DO UPDATE SET data = excluded.data, revision = excluded.revision`),
+		ns, guid, data, int64(rev))
+	return err
+}
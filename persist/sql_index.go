@@ -0,0 +1,223 @@
+package persist
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// CreateIndex declares an index on a namespace and builds its entries from the stored values
+func (s *sqlStore) CreateIndex(ns string, index Index) error {
+	s.indexLock.Lock()
+	defer s.indexLock.Unlock()
+
+	for _, idx := range s.indexes[ns] {
+		if idx.Name == index.Name {
+			return nil
+		}
+	}
+
+	err := s.tx(func(tx *sql.Tx) error {
+		if _, err := s.sequence(tx, ns); err != nil {
+			return err
+		}
+
+		// rebuild the index, values may have been stored while it was not declared
+		_, err := tx.Exec(s.bind("DELETE FROM tfwatch_index_entries WHERE namespace = ? AND index_name = ?"), ns, index.Name)
+		if err != nil {
+			return err
+		}
+
+		// values are read before they are indexed, a connection runs one statement at a time
+		guids, values, err := s.values(tx, ns)
+		if err != nil {
+			return err
+		}
+
+		for i, guid := range guids {
+			value := index.New()
+			if err := decode(values[i], value); err != nil {
+				return err
+			}
+
+			err := s.indexValue(tx, ns, index, guid, value)
+			if dup, ok := err.(*DuplicateError); ok {
+				log.Printf("[WARN] Not indexing '%s', %s", guid, dup)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] Created index '%s' on namespace '%s'", index.Name, ns)
+	s.indexes[ns] = append(s.indexes[ns], index)
+	return nil
+}
+
+// Query returns the guids of values in an index matching the query, ordered by key
+func (s *sqlStore) Query(ns, index string, q Query) (guids []string, err error) {
+	err = s.Range(ns, index, q, collect(&guids))
+	return
+}
+
+// Range visits the values in an index matching the query in a single transaction, selecting the
+// entries and values in one statement
+func (s *sqlStore) Range(ns, index string, q Query, visit Visitor) error {
+	return s.tx(func(tx *sql.Tx) error {
+		if _, err := s.sequence(tx, ns); err != nil {
+			return err
+		}
+
+		declared := false
+		for _, idx := range s.namespaceIndexes(ns) {
+			declared = declared || idx.Name == index
+		}
+		if !declared {
+			return fmt.Errorf("Index '%s' on namespace '%s' does not exist", index, ns)
+		}
+
+		stmt, args := rangeStatement(ns, index, q)
+		rows, err := tx.Query(s.bind(stmt), args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key, guid string
+			var data []byte
+			if err := rows.Scan(&key, &guid, &data); err != nil {
+				return err
+			}
+
+			more, err := visit(key, guid, func(value interface{}) error {
+				return decode(data, value)
+			})
+			if err != nil || !more {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// rangeStatement returns the select of the index entries matching a query, joined with their values
+func rangeStatement(ns, index string, q Query) (string, []interface{}) {
+	where := []string{"e.namespace = ?", "e.index_name = ?"}
+	args := []interface{}{ns, index}
+
+	if lower := q.lower(); lower != "" {
+		where = append(where, "e.index_key >= ?")
+		args = append(args, lower)
+	}
+
+	if upper := q.upper(); upper != "" {
+		// keys cannot contain a 0 byte in every database, the keys below a key ending in one are
+		// the keys up to the key without it
+		if strings.HasSuffix(upper, "\x00") {
+			where = append(where, "e.index_key <= ?")
+			args = append(args, strings.TrimSuffix(upper, "\x00"))
+		} else {
+			where = append(where, "e.index_key < ?")
+			args = append(args, upper)
+		}
+	}
+
+	// entries are ordered by key then guid, which is the order of their cursors
+	order, after := "ASC", ">"
+	if q.Reverse {
+		order, after = "DESC", "<"
+	}
+	if q.After != "" {
+		key, guid := splitEntryKey([]byte(q.After))
+		where = append(where, "(e.index_key "+after+" ? OR (e.index_key = ? AND e.guid "+after+" ?))")
+		args = append(args, key, key, guid)
+	}
+
+	stmt := "SELECT e.index_key, e.guid, v.data FROM tfwatch_index_entries e " +
+		"JOIN tfwatch_values v ON v.namespace = e.namespace AND v.guid = e.guid " +
+		"WHERE " + strings.Join(where, " AND ") + " " +
+		"ORDER BY e.index_key " + order + ", e.guid " + order
+	if q.Limit > 0 {
+		stmt += " LIMIT " + strconv.Itoa(q.Limit)
+	}
+	return stmt, args
+}
+
+// updateIndexes indexes a value in each index on its namespace, replacing its previous entries
+func (s *sqlStore) updateIndexes(tx *sql.Tx, ns, guid string, value interface{}) error {
+	for _, index := range s.namespaceIndexes(ns) {
+		if err := s.indexValue(tx, ns, index, guid, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaceIndexes returns the indexes declared on a namespace
+func (s *sqlStore) namespaceIndexes(ns string) []Index {
+	s.indexLock.RLock()
+	defer s.indexLock.RUnlock()
+	return s.indexes[ns]
+}
+
+// indexValue replaces the entry of a value in an index. A *DuplicateError is returned when a unique
+// index has the value's key for another guid. Unique keys are also constrained by the schema, so
+// concurrent transactions cannot both index a key.
+func (s *sqlStore) indexValue(tx *sql.Tx, ns string, index Index, guid string, value interface{}) error {
+	_, err := tx.Exec(s.bind("DELETE FROM tfwatch_index_entries WHERE namespace = ? AND index_name = ? AND guid = ?"), ns, index.Name, guid)
+	if err != nil {
+		return err
+	}
+
+	key := index.Key(value)
+	if key == "" {
+		return nil
+	}
+
+	var unique interface{}
+	if index.Unique {
+		var other string
+		err := tx.QueryRow(s.bind("SELECT guid FROM tfwatch_index_entries WHERE namespace = ? AND index_name = ? AND unique_key = ?"),
+			ns, index.Name, key).Scan(&other)
+		if err == nil {
+			return &DuplicateError{ns, index.Name, key, other}
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+		unique = key
+	}
+
+	_, err = tx.Exec(s.bind("INSERT INTO tfwatch_index_entries (namespace, index_name, guid, index_key, unique_key) VALUES (?, ?, ?, ?, ?)"),
+		ns, index.Name, guid, key, unique)
+	return err
+}
+
+// values returns the guids and encoded values of a namespace
+func (s *sqlStore) values(tx *sql.Tx, ns string) (guids []string, values [][]byte, err error) {
+	rows, err := tx.Query(s.bind("SELECT guid, data FROM tfwatch_values WHERE namespace = ? ORDER BY guid"), ns)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var guid string
+		var data []byte
+		if err := rows.Scan(&guid, &data); err != nil {
+			return nil, nil, err
+		}
+		guids = append(guids, guid)
+		values = append(values, data)
+	}
+	return guids, values, rows.Err()
+}
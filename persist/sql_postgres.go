@@ -0,0 +1,4 @@
+package persist
+
+// the Postgres driver, registered as "postgres"
+import _ "github.com/lib/pq"
@@ -0,0 +1,4 @@
+package persist
+
+// the pure Go SQLite driver, registered as "sqlite"
+import _ "modernc.org/sqlite"
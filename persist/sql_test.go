@@ -0,0 +1,33 @@
+package persist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQL_bind(t *testing.T) {
+	sqlite := &sqlStore{dialect: sqlDialects["sqlite"]}
+	postgres := &sqlStore{dialect: sqlDialects["postgres"]}
+
+	stmt := "SELECT guid FROM tfwatch_values WHERE namespace = ? AND guid = ?"
+	assert.Equal(t, stmt, sqlite.bind(stmt))
+	assert.Equal(t, "SELECT guid FROM tfwatch_values WHERE namespace = $1 AND guid = $2", postgres.bind(stmt))
+}
+
+func TestSQL_rangeStatement(t *testing.T) {
+	// an exact key is selected without a 0 byte
+	stmt, args := rangeStatement("ns", "name", Exact("apple"))
+	assert.Contains(t, stmt, "e.index_key >= ? AND e.index_key <= ?")
+	assert.Equal(t, []interface{}{"ns", "name", "apple", "apple"}, args)
+
+	// entries after a cursor, newest first
+	stmt, args = rangeStatement("ns", "started", Query{After: Cursor("b", "7"), Reverse: true, Limit: 2})
+	assert.Contains(t, stmt, "(e.index_key < ? OR (e.index_key = ? AND e.guid < ?))")
+	assert.Contains(t, stmt, "ORDER BY e.index_key DESC, e.guid DESC LIMIT 2")
+	assert.Equal(t, []interface{}{"ns", "started", "b", "b", "7"}, args)
+
+	stmt, args = rangeStatement("ns", "name", Query{Prefix: "ap"})
+	assert.Contains(t, stmt, "e.index_key >= ? AND e.index_key < ?")
+	assert.Equal(t, []interface{}{"ns", "name", "ap", "aq"}, args)
+}
@@ -1,30 +1,97 @@
 package persist
 
+import "fmt"
+
 // NotFoundError returned when an object is not found on a Get
 type NotFoundError struct {
 	error
 }
 
-// Store is a simple key-value store that uses namespace-based pessimistic locking
+// namespaceNotFound returns the error of an operation on a namespace that does not exist
+func namespaceNotFound(ns string) error {
+	return &NotFoundError{fmt.Errorf("Namespace '%s' does not exist", ns)}
+}
+
+// ConflictError returned when a value is updated based on a revision that is no longer current
+type ConflictError struct {
+	Namespace string
+	GUID      string
+	Revision  uint64
+	Current   uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("'%s' in '%s' was modified, revision %d is not current revision %d",
+		e.GUID, e.Namespace, e.Revision, e.Current)
+}
+
+// Store is a simple key-value store that uses namespace-based pessimistic locking. Every stored value
+// has a revision, starting at 1 when it is created and incremented on each update, which allows
+// optimistic concurrency through CompareAndUpdate. Values stored before revisions were recorded have
+// revision 0 until they are updated.
+//
+// Operations on a namespace that does not exist return a *NotFoundError, as do operations on a
+// guid that is not stored. Guids are opaque, and are not given to another value once deleted. The
+// conformance suite in persist/storetest pins down the behavior every Store must have.
 type Store interface {
-	// List retrieves the guids in a namespace
+	// List retrieves the guids in a namespace, in order
 	List(ns string) ([]string, error)
 
 	// Get retrieves a value from the Store by guid
 	Get(ns, guid string, value interface{}) error
 
+	// GetRevision retrieves a value from the Store by guid along with its revision
+	GetRevision(ns, guid string, value interface{}) (uint64, error)
+
 	// Create stores a value, and returns the guid, if any error is returned, nothing is saved
 	Create(ns string, value interface{}) (string, error)
 
 	// Update updates a stored value, if value does not exist, an error is returned
 	Update(ns, guid string, value interface{}) error
 
-	// Delete removes a value from the key-value store
+	// CompareAndUpdate updates a stored value only if its revision is still revision, returning the new
+	// revision. A *ConflictError is returned when the value has been modified since.
+	CompareAndUpdate(ns, guid string, revision uint64, value interface{}) (uint64, error)
+
+	// GetEncoded retrieves a value as it is encoded in the Store, and its revision, to copy it to
+	// another store
+	GetEncoded(ns, guid string) ([]byte, uint64, error)
+
+	// PutEncoded stores an encoded value by guid with a revision, replacing any value stored by the
+	// guid. Values are copied from another store this way, keeping their guids and revisions.
+	PutEncoded(ns, guid string, data []byte, revision uint64) error
+
+	// Delete removes a value from the key-value store, a *NotFoundError is returned if it is not stored
 	Delete(ns, guid string) error
 
+	// Namespaces lists the namespaces in the store in order, except those beginning with "__" that
+	// are reserved by stores
+	Namespaces() ([]string, error)
+
 	// CreateNamespace ensures that a namespace exists
 	CreateNamespace(ns string) error
 
-	// Destroys the store, removing all persisted data
+	// DeleteNamespace removes a namespace, the values in it and its indexes. Deleting a namespace
+	// that does not exist has no effect.
+	DeleteNamespace(ns string) error
+
+	// CreateIndex declares an index on a namespace and indexes the values already stored. Indexes
+	// are kept up to date as values are created, updated and deleted. Declaring an index again has
+	// no effect.
+	CreateIndex(ns string, index Index) error
+
+	// Query returns the guids of values in an index matching the query, ordered by key
+	Query(ns, index string, q Query) ([]string, error)
+
+	// Range visits the values in an index matching the query, in order, without loading every value
+	Range(ns, index string, q Query, visit Visitor) error
+
+	// Destroy removes all persisted data, leaving the store empty
 	Destroy() error
 }
+
+// Compactor is implemented by stores that can release the space held by deleted values
+type Compactor interface {
+	// Compact rewrites the store without the space held by deleted values
+	Compact() error
+}
@@ -0,0 +1,93 @@
+package persist_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/webdevwilson/tfwatch/persist"
+	"github.com/webdevwilson/tfwatch/persist/storetest"
+)
+
+// openDir opens a store in a temporary directory
+func openDir(create func(dir string) (persist.Store, error)) storetest.Open {
+	return func(t *testing.T) (persist.Store, func()) {
+		dir, err := ioutil.TempDir("", "tfwatch-store")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := create(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return store, func() {
+			if c, ok := store.(io.Closer); ok {
+				c.Close()
+			}
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+func TestStore_LocalFile(t *testing.T) {
+	storetest.Run(t, openDir(persist.NewLocalFileStore))
+}
+
+func TestStore_Bolt(t *testing.T) {
+	storetest.Run(t, openDir(func(dir string) (persist.Store, error) {
+		return persist.NewBoltStore(dir, persist.GobEncoding)
+	}))
+}
+
+func TestStore_Bolt_JSON(t *testing.T) {
+	storetest.Run(t, openDir(func(dir string) (persist.Store, error) {
+		return persist.NewBoltStore(dir, persist.JSONEncoding)
+	}))
+}
+
+func TestStore_Memory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (persist.Store, func()) {
+		return persist.NewMemoryStore(), func() {}
+	})
+}
+
+func TestStore_Watched(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (persist.Store, func()) {
+		store := persist.NewWatchedStore(persist.NewMemoryStore())
+		subscription := store.Watch(1, nil)
+		return store, subscription.Close
+	})
+}
+
+func TestStore_SQLite(t *testing.T) {
+	storetest.Run(t, openDir(func(dir string) (persist.Store, error) {
+		return persist.NewSQLStore("sqlite", path.Join(dir, "tfwatch.db"), persist.GobEncoding)
+	}))
+}
+
+// TestStore_Postgres runs against the database at TFWATCH_TEST_POSTGRES, which is emptied by each test
+func TestStore_Postgres(t *testing.T) {
+	url := os.Getenv("TFWATCH_TEST_POSTGRES")
+	if url == "" {
+		t.Skip("Set TFWATCH_TEST_POSTGRES to the URL of a database")
+	}
+
+	storetest.Run(t, func(t *testing.T) (persist.Store, func()) {
+		store, err := persist.NewSQLStore("postgres", url, persist.GobEncoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Destroy(); err != nil {
+			t.Fatal(err)
+		}
+
+		return store, func() {
+			store.Destroy()
+			store.(io.Closer).Close()
+		}
+	})
+}
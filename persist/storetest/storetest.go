@@ -0,0 +1,558 @@
+// Package storetest is the conformance suite of persist.Store. Every Store implementation must pass
+// it, so code using a Store behaves the same whichever store it is given.
+//
+// The suite pins down behavior the Store interface leaves open:
+//
+//   - every operation on a namespace that does not exist returns a *persist.NotFoundError, except
+//     CreateNamespace and DeleteNamespace, and no operation panics
+//   - Get, GetRevision, GetEncoded, Update, CompareAndUpdate and Delete of a guid that is not stored
+//     return a *persist.NotFoundError, and Update never creates a value
+//   - guids are opaque, unique within a namespace and never given to another value, even once deleted
+//   - List returns guids, and Namespaces returns namespaces, in ascending byte order
+//   - Destroy removes every namespace, value and index, and the store remains usable
+package storetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+// Open creates an empty store, and a func that removes it once a test is done
+type Open func(t *testing.T) (persist.Store, func())
+
+// Run runs the conformance suite, each test on a new store
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		test func(*testing.T, persist.Store)
+	}{
+		{"Namespaces", testNamespaces},
+		{"MissingNamespace", testMissingNamespace},
+		{"NotFound", testNotFound},
+		{"Create", testCreate},
+		{"Revisions", testRevisions},
+		{"Encoded", testEncoded},
+		{"Indexes", testIndexes},
+		{"Range", testRange},
+		{"DeleteNamespace", testDeleteNamespace},
+		{"Destroy", testDestroy},
+		{"Concurrency", testConcurrency},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store, cleanup := open(t)
+			defer cleanup()
+
+			tc.test(t, store)
+		})
+	}
+}
+
+type value struct {
+	Name  string
+	Count int
+}
+
+var nameIndex = persist.Index{
+	Name:   "name",
+	Unique: true,
+	Key:    func(v interface{}) string { return v.(*value).Name },
+	New:    func() interface{} { return &value{} },
+}
+
+var countIndex = persist.Index{
+	Name: "count",
+	Key:  func(v interface{}) string { return string('a' + rune(v.(*value).Count)) },
+	New:  func() interface{} { return &value{} },
+}
+
+// notFound asserts an error is a *persist.NotFoundError
+func notFound(t *testing.T, err error, op string) {
+	_, ok := err.(*persist.NotFoundError)
+	assert.True(t, ok, "%s: expected *persist.NotFoundError, got %#v", op, err)
+}
+
+// testNamespaces checks namespaces are listed in order, and creating a namespace again keeps its values
+func testNamespaces(t *testing.T, store persist.Store) {
+	namespaces, err := store.Namespaces()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(namespaces))
+
+	for _, ns := range []string{"b", "a", "b", "__reserved"} {
+		assert.Nil(t, store.CreateNamespace(ns))
+	}
+
+	namespaces, err = store.Namespaces()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, namespaces)
+
+	guid, err := store.Create("a", &value{"apple", 1})
+	assert.Nil(t, err)
+	assert.Nil(t, store.CreateNamespace("a"))
+
+	var v value
+	assert.Nil(t, store.Get("a", guid, &v))
+	assert.Equal(t, value{"apple", 1}, v)
+}
+
+// testMissingNamespace checks operations on a namespace that does not exist return a *persist.NotFoundError
+func testMissingNamespace(t *testing.T, store persist.Store) {
+	const ns = "missing"
+	var v value
+
+	_, err := store.List(ns)
+	notFound(t, err, "List")
+
+	notFound(t, store.Get(ns, "1", &v), "Get")
+
+	_, err = store.GetRevision(ns, "1", &v)
+	notFound(t, err, "GetRevision")
+
+	_, _, err = store.GetEncoded(ns, "1")
+	notFound(t, err, "GetEncoded")
+
+	_, err = store.Create(ns, &value{"apple", 1})
+	notFound(t, err, "Create")
+
+	notFound(t, store.Update(ns, "1", &value{"apple", 1}), "Update")
+
+	_, err = store.CompareAndUpdate(ns, "1", 1, &value{"apple", 1})
+	notFound(t, err, "CompareAndUpdate")
+
+	notFound(t, store.PutEncoded(ns, "1", []byte{}, 1), "PutEncoded")
+
+	notFound(t, store.Delete(ns, "1"), "Delete")
+
+	notFound(t, store.CreateIndex(ns, nameIndex), "CreateIndex")
+
+	_, err = store.Query(ns, nameIndex.Name, persist.Query{})
+	notFound(t, err, "Query")
+
+	err = store.Range(ns, nameIndex.Name, persist.Query{}, func(key, guid string, decode func(interface{}) error) (bool, error) {
+		return true, nil
+	})
+	notFound(t, err, "Range")
+
+	// operations do not create the namespace
+	namespaces, err := store.Namespaces()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(namespaces))
+
+	assert.Nil(t, store.DeleteNamespace(ns))
+}
+
+// testNotFound checks operations on a guid that is not stored return a *persist.NotFoundError
+func testNotFound(t *testing.T, store persist.Store) {
+	const ns = "values"
+	assert.Nil(t, store.CreateNamespace(ns))
+	var v value
+
+	notFound(t, store.Get(ns, "missing", &v), "Get")
+
+	_, err := store.GetRevision(ns, "missing", &v)
+	notFound(t, err, "GetRevision")
+
+	_, _, err = store.GetEncoded(ns, "missing")
+	notFound(t, err, "GetEncoded")
+
+	notFound(t, store.Update(ns, "missing", &value{"apple", 1}), "Update")
+
+	_, err = store.CompareAndUpdate(ns, "missing", 1, &value{"apple", 1})
+	notFound(t, err, "CompareAndUpdate")
+
+	notFound(t, store.Delete(ns, "missing"), "Delete")
+
+	// nothing was stored by the updates
+	guids, err := store.List(ns)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(guids))
+
+	// deleted values are not found
+	guid, err := store.Create(ns, &value{"apple", 1})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Delete(ns, guid))
+	notFound(t, store.Get(ns, guid, &v), "Get deleted")
+	notFound(t, store.Delete(ns, guid), "Delete deleted")
+}
+
+// testCreate checks guids are unique and listed in order, and stored values are copies
+func testCreate(t *testing.T, store persist.Store) {
+	const ns = "created"
+	assert.Nil(t, store.CreateNamespace(ns))
+
+	created := make(map[string]bool)
+	for i := 0; i < 12; i++ {
+		guid, err := store.Create(ns, &value{fmt.Sprintf("value %d", i), i})
+		assert.Nil(t, err)
+		assert.NotEqual(t, "", guid)
+		assert.False(t, created[guid], "guid '%s' was given twice", guid)
+		created[guid] = true
+	}
+
+	guids, err := store.List(ns)
+	assert.Nil(t, err)
+	assert.Equal(t, len(created), len(guids))
+	assert.True(t, sort.StringsAreSorted(guids), "guids are not in order: %v", guids)
+	for _, guid := range guids {
+		assert.True(t, created[guid])
+	}
+
+	// the guids of deleted values are not given again
+	for _, guid := range guids {
+		assert.Nil(t, store.Delete(ns, guid))
+	}
+	guid, err := store.Create(ns, &value{"apple", 1})
+	assert.Nil(t, err)
+	assert.False(t, created[guid], "guid '%s' of a deleted value was given again", guid)
+
+	// changing a value after it is stored does not change the stored value
+	v := &value{"banana", 1}
+	guid, err = store.Create(ns, v)
+	assert.Nil(t, err)
+	v.Name = "cherry"
+
+	var stored value
+	assert.Nil(t, store.Get(ns, guid, &stored))
+	assert.Equal(t, "banana", stored.Name)
+}
+
+// testRevisions checks revisions are incremented and stale updates rejected
+func testRevisions(t *testing.T, store persist.Store) {
+	const ns = "revisions"
+	assert.Nil(t, store.CreateNamespace(ns))
+
+	guid, err := store.Create(ns, &value{"a", 0})
+	assert.Nil(t, err)
+
+	var v value
+	rev, err := store.GetRevision(ns, guid, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), rev)
+
+	rev, err = store.CompareAndUpdate(ns, guid, rev, &value{"b", 1})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), rev)
+
+	// an update based on the first revision conflicts
+	_, err = store.CompareAndUpdate(ns, guid, 1, &value{"c", 2})
+	conflict, ok := err.(*persist.ConflictError)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint64(2), conflict.Current)
+	}
+
+	rev, err = store.GetRevision(ns, guid, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), rev)
+	assert.Equal(t, "b", v.Name)
+
+	// unconditional updates increment the revision
+	assert.Nil(t, store.Update(ns, guid, &value{"d", 3}))
+	rev, err = store.GetRevision(ns, guid, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), rev)
+	assert.Equal(t, value{"d", 3}, v)
+}
+
+// testEncoded checks encoded values are copied between stores keeping their guids and revisions
+func testEncoded(t *testing.T, store persist.Store) {
+	assert.Nil(t, store.CreateNamespace("source"))
+	assert.Nil(t, store.CreateNamespace("copy"))
+	assert.Nil(t, store.CreateIndex("copy", nameIndex))
+
+	var guids []string
+	for _, name := range []string{"apple", "banana"} {
+		guid, err := store.Create("source", &value{name, 1})
+		assert.Nil(t, err)
+		guids = append(guids, guid)
+	}
+	assert.Nil(t, store.Update("source", guids[1], &value{"banana", 2}))
+
+	data, rev, err := store.GetEncoded("source", guids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), rev)
+	assert.Nil(t, store.PutEncoded("copy", guids[1], data, rev))
+
+	var v value
+	rev, err = store.GetRevision("copy", guids[1], &v)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), rev)
+	assert.Equal(t, value{"banana", 2}, v)
+
+	// put values are indexed
+	indexed, err := store.Query("copy", nameIndex.Name, persist.Exact("banana"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{guids[1]}, indexed)
+
+	// values created later are not given the guid of a value put
+	for range guids {
+		guid, err := store.Create("copy", &value{"cherry " + guids[0], 1})
+		assert.Nil(t, err)
+		assert.NotEqual(t, guids[1], guid)
+		assert.Nil(t, store.Delete("copy", guid))
+	}
+
+	// putting a value replaces the value stored by the guid
+	data, _, err = store.GetEncoded("source", guids[0])
+	assert.Nil(t, err)
+	assert.Nil(t, store.PutEncoded("copy", guids[1], data, 7))
+	rev, err = store.GetRevision("copy", guids[1], &v)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(7), rev)
+	assert.Equal(t, "apple", v.Name)
+}
+
+// testIndexes checks indexes are built, maintained and queried
+func testIndexes(t *testing.T, store persist.Store) {
+	assert.Nil(t, store.CreateNamespace("indexed"))
+
+	// values stored before the index is declared are indexed
+	a, err := store.Create("indexed", &value{"apple", 0})
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.CreateIndex("indexed", nameIndex))
+	assert.Nil(t, store.CreateIndex("indexed", nameIndex))
+	assert.Nil(t, store.CreateIndex("indexed", countIndex))
+
+	b, err := store.Create("indexed", &value{"banana", 1})
+	assert.Nil(t, err)
+	c, err := store.Create("indexed", &value{"cherry", 1})
+	assert.Nil(t, err)
+
+	// unique keys are enforced, and nothing is saved
+	_, err = store.Create("indexed", &value{"apple", 2})
+	_, ok := err.(*persist.DuplicateError)
+	assert.True(t, ok)
+	guids, err := store.List("indexed")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(guids))
+
+	guids, err = store.Query("indexed", "name", persist.Exact("banana"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{b}, guids)
+
+	guids, err = store.Query("indexed", "name", persist.Query{Prefix: "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{b}, guids)
+
+	guids, err = store.Query("indexed", "name", persist.Query{Start: "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{b, c}, guids)
+
+	guids, err = store.Query("indexed", "name", persist.Query{End: "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{a, b}, guids)
+
+	guids, err = store.Query("indexed", "name", persist.Query{Reverse: true, Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{c, b}, guids)
+
+	guids, err = store.Query("indexed", "name", persist.Query{End: "c", Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{b, a}, guids)
+
+	guids, err = store.Query("indexed", "count", persist.Exact("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(guids))
+
+	// updates move values in the index
+	assert.Nil(t, store.Update("indexed", b, &value{"blueberry", 1}))
+	guids, err = store.Query("indexed", "name", persist.Exact("banana"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, guids)
+	guids, err = store.Query("indexed", "name", persist.Exact("blueberry"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{b}, guids)
+
+	// a value can keep its own unique key
+	assert.Nil(t, store.Update("indexed", b, &value{"blueberry", 2}))
+	_, err = store.CompareAndUpdate("indexed", c, 1, &value{"apple", 1})
+	_, ok = err.(*persist.DuplicateError)
+	assert.True(t, ok)
+
+	// deleted values are removed
+	assert.Nil(t, store.Delete("indexed", a))
+	guids, err = store.Query("indexed", "name", persist.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{b, c}, guids)
+
+	_, err = store.Query("indexed", "missing", persist.Query{})
+	assert.NotNil(t, err)
+}
+
+// testRange checks values are visited in pages continuing after a cursor, in both directions
+func testRange(t *testing.T, store persist.Store) {
+	assert.Nil(t, store.CreateNamespace("ranged"))
+	assert.Nil(t, store.CreateIndex("ranged", countIndex))
+
+	for _, v := range []*value{{"a", 0}, {"b", 1}, {"c", 1}, {"d", 1}, {"e", 2}} {
+		_, err := store.Create("ranged", v)
+		assert.Nil(t, err)
+	}
+
+	// page reads values in pages of two, returning the names visited and the cursor of the last
+	page := func(q persist.Query) (names []string, cursor string) {
+		q.Limit = 2
+		err := store.Range("ranged", "count", q, func(key, guid string, decode func(interface{}) error) (bool, error) {
+			var v value
+			if err := decode(&v); err != nil {
+				return false, err
+			}
+			names = append(names, v.Name)
+			cursor = persist.Cursor(key, guid)
+			return true, nil
+		})
+		assert.Nil(t, err)
+		return
+	}
+
+	for _, reverse := range []bool{false, true} {
+		var visited []string
+		q := persist.Query{Reverse: reverse}
+		for {
+			names, cursor := page(q)
+			if len(names) == 0 {
+				break
+			}
+			visited = append(visited, names...)
+			q.After = cursor
+		}
+
+		// values sharing a key are each visited once
+		if assert.Equal(t, 5, len(visited)) {
+			if reverse {
+				assert.Equal(t, "e", visited[0])
+				assert.Equal(t, "a", visited[4])
+			} else {
+				assert.Equal(t, "a", visited[0])
+				assert.Equal(t, "e", visited[4])
+			}
+		}
+	}
+
+	// bounds apply with a cursor
+	names, cursor := page(persist.Query{Start: "b", End: "c", Reverse: true})
+	assert.Equal(t, 2, len(names))
+	names, _ = page(persist.Query{Start: "b", End: "c", Reverse: true, After: cursor})
+	assert.Equal(t, 1, len(names))
+
+	// visiting stops when the visitor returns false
+	count := 0
+	err := store.Range("ranged", "count", persist.Query{}, func(key, guid string, decode func(interface{}) error) (bool, error) {
+		count++
+		return false, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+// testDeleteNamespace checks a deleted namespace takes its values, revisions and indexes with it
+func testDeleteNamespace(t *testing.T, store persist.Store) {
+	assert.Nil(t, store.CreateNamespace("deleted"))
+	assert.Nil(t, store.CreateIndex("deleted", nameIndex))
+	_, err := store.Create("deleted", &value{"apple", 0})
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.DeleteNamespace("deleted"))
+	assert.Nil(t, store.DeleteNamespace("deleted"))
+
+	namespaces, err := store.Namespaces()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(namespaces))
+
+	// a namespace created again is empty, and indexes are declared again
+	assert.Nil(t, store.CreateNamespace("deleted"))
+	guids, err := store.List("deleted")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(guids))
+
+	_, err = store.Query("deleted", "name", persist.Query{})
+	assert.NotNil(t, err)
+	assert.Nil(t, store.CreateIndex("deleted", nameIndex))
+
+	guid, err := store.Create("deleted", &value{"apple", 0})
+	assert.Nil(t, err)
+	rev, err := store.GetRevision("deleted", guid, &value{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), rev)
+}
+
+// testDestroy checks a destroyed store is empty and can be used again
+func testDestroy(t *testing.T, store persist.Store) {
+	for _, ns := range []string{"a", "b"} {
+		assert.Nil(t, store.CreateNamespace(ns))
+		assert.Nil(t, store.CreateIndex(ns, nameIndex))
+		_, err := store.Create(ns, &value{"apple", 1})
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, store.Destroy())
+
+	namespaces, err := store.Namespaces()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(namespaces))
+
+	_, err = store.List("a")
+	notFound(t, err, "List")
+
+	// indexes are declared again
+	assert.Nil(t, store.CreateNamespace("a"))
+	_, err = store.Query("a", nameIndex.Name, persist.Query{})
+	assert.NotNil(t, err)
+	assert.Nil(t, store.CreateIndex("a", nameIndex))
+
+	guid, err := store.Create("a", &value{"apple", 1})
+	assert.Nil(t, err)
+	rev, err := store.GetRevision("a", guid, &value{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), rev)
+
+	guids, err := store.Query("a", nameIndex.Name, persist.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{guid}, guids)
+}
+
+// testConcurrency checks concurrent creates get distinct guids and concurrent updates are not lost
+func testConcurrency(t *testing.T, store persist.Store) {
+	const ns, workers, writes = "concurrent", 8, 10
+	assert.Nil(t, store.CreateNamespace(ns))
+
+	guid, err := store.Create(ns, &value{"counter", 0})
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*writes*2)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if _, err := store.Create(ns, &value{fmt.Sprintf("%d-%d", w, i), i}); err != nil {
+					errs <- err
+				}
+				if err := store.Update(ns, guid, &value{"counter", i}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	guids, err := store.List(ns)
+	assert.Nil(t, err)
+	assert.Equal(t, workers*writes+1, len(guids))
+
+	rev, err := store.GetRevision(ns, guid, &value{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(workers*writes+1), rev)
+}
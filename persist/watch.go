@@ -0,0 +1,207 @@
+package persist
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Operation is the kind of change made to a value
+type Operation string
+
+// Operations of changes
+const (
+	Created Operation = "create"
+	Updated Operation = "update"
+	Deleted Operation = "delete"
+)
+
+// DefaultWatchBuffer is the number of changes a subscription holds when no buffer is given
+const DefaultWatchBuffer = 256
+
+// Change is a change made to a value of a store. A delete without a guid removed every value of its
+// namespace, and a delete without a namespace every value of the store. Sequence numbers increase by
+// one with each change published, so a subscriber can tell when it missed changes.
+type Change struct {
+	Sequence  uint64    `json:"sequence"`
+	Operation Operation `json:"operation"`
+	Namespace string    `json:"namespace"`
+	GUID      string    `json:"guid"`
+	Time      time.Time `json:"time"`
+}
+
+// Watcher publishes the changes made to a store to subscribers
+type Watcher interface {
+	// Watch subscribes to the changes of the namespaces match returns true for, or of every namespace
+	// when match is nil. The subscription holds up to buffer changes that have not been received.
+	Watch(buffer int, match func(ns string) bool) *Subscription
+}
+
+// WatchedStore is a store that publishes the changes made through it
+type WatchedStore interface {
+	Store
+	Watcher
+}
+
+// Subscription receives changes from a Watcher on Changes, in the order they were published. Changes
+// are published without waiting for subscribers. A change published while the subscription's buffer
+// is full is dropped for that subscription and counted by Dropped, the subscriber sees a gap in the
+// sequence numbers. A subscriber that cannot keep up should reload what it watches when it finds a
+// gap. Changes is closed when the subscription is closed.
+type Subscription struct {
+	Changes <-chan Change
+
+	changes chan Change
+	match   func(ns string) bool
+	dropped uint64
+	watcher *watchedStore
+}
+
+// Dropped returns the number of changes dropped because the subscription's buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close ends the subscription, closing Changes
+func (s *Subscription) Close() {
+	s.watcher.unsubscribe(s)
+}
+
+type watchedStore struct {
+	Store
+	lock          sync.Mutex
+	sequence      uint64
+	subscriptions map[*Subscription]bool
+}
+
+// NewWatchedStore wraps a store, publishing the changes made through the wrapper. Changes made to the
+// store directly are not published.
+func NewWatchedStore(store Store) WatchedStore {
+	return &watchedStore{Store: store, subscriptions: make(map[*Subscription]bool)}
+}
+
+// Unwrap returns the store wrapped by a watched store, or the store itself. Compacting and backing up
+// change no values, so they are done on the wrapped store.
+func Unwrap(store Store) Store {
+	if w, ok := store.(*watchedStore); ok {
+		return w.Store
+	}
+	return store
+}
+
+// Watch subscribes to the changes of the matching namespaces
+func (w *watchedStore) Watch(buffer int, match func(ns string) bool) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultWatchBuffer
+	}
+
+	changes := make(chan Change, buffer)
+	s := &Subscription{Changes: changes, changes: changes, match: match, watcher: w}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.subscriptions[s] = true
+	return s
+}
+
+// unsubscribe removes a subscription and closes its channel
+func (w *watchedStore) unsubscribe(s *Subscription) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.subscriptions[s] {
+		delete(w.subscriptions, s)
+		close(s.changes)
+	}
+}
+
+// publish numbers a change and sends it to the matching subscriptions without blocking
+func (w *watchedStore) publish(op Operation, ns, guid string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.sequence++
+	c := Change{w.sequence, op, ns, guid, time.Now().UTC()}
+	for s := range w.subscriptions {
+		if s.match != nil && ns != "" && !s.match(ns) {
+			continue
+		}
+
+		select {
+		case s.changes <- c:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			log.Printf("[DEBUG] Dropped change %d of '%s' in '%s', the subscriber's buffer is full", c.Sequence, guid, ns)
+		}
+	}
+}
+
+// Create stores a value and publishes its creation
+func (w *watchedStore) Create(ns string, value interface{}) (string, error) {
+	guid, err := w.Store.Create(ns, value)
+	if err == nil {
+		w.publish(Created, ns, guid)
+	}
+	return guid, err
+}
+
+// Update updates a stored value and publishes the update
+func (w *watchedStore) Update(ns, guid string, value interface{}) error {
+	err := w.Store.Update(ns, guid, value)
+	if err == nil {
+		w.publish(Updated, ns, guid)
+	}
+	return err
+}
+
+// CompareAndUpdate updates a stored value if its revision is current and publishes the update
+func (w *watchedStore) CompareAndUpdate(ns, guid string, revision uint64, value interface{}) (uint64, error) {
+	rev, err := w.Store.CompareAndUpdate(ns, guid, revision, value)
+	if err == nil {
+		w.publish(Updated, ns, guid)
+	}
+	return rev, err
+}
+
+// PutEncoded stores an encoded value and publishes its creation, or its update when a value was
+// stored by the guid
+func (w *watchedStore) PutEncoded(ns, guid string, data []byte, revision uint64) error {
+	op := Updated
+	if _, _, err := w.Store.GetEncoded(ns, guid); err != nil {
+		op = Created
+	}
+
+	err := w.Store.PutEncoded(ns, guid, data, revision)
+	if err == nil {
+		w.publish(op, ns, guid)
+	}
+	return err
+}
+
+// Delete removes a value and publishes its deletion
+func (w *watchedStore) Delete(ns, guid string) error {
+	err := w.Store.Delete(ns, guid)
+	if err == nil {
+		w.publish(Deleted, ns, guid)
+	}
+	return err
+}
+
+// DeleteNamespace removes a namespace and publishes the deletion of its values
+func (w *watchedStore) DeleteNamespace(ns string) error {
+	err := w.Store.DeleteNamespace(ns)
+	if err == nil {
+		w.publish(Deleted, ns, "")
+	}
+	return err
+}
+
+// Destroy removes every value and publishes their deletion
+func (w *watchedStore) Destroy() error {
+	err := w.Store.Destroy()
+	if err == nil {
+		w.publish(Deleted, "", "")
+	}
+	return err
+}
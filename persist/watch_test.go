@@ -0,0 +1,99 @@
+package persist
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receive returns the changes a subscription holds
+func receive(s *Subscription) []Change {
+	var changes []Change
+	for {
+		select {
+		case c := <-s.Changes:
+			changes = append(changes, c)
+		default:
+			return changes
+		}
+	}
+}
+
+func TestWatchedStore(t *testing.T) {
+	store := NewWatchedStore(NewMemoryStore())
+	all := store.Watch(0, nil)
+	executions := store.Watch(0, func(ns string) bool { return strings.HasSuffix(ns, "-executions") })
+
+	assert.Nil(t, store.CreateNamespace("projects"))
+	assert.Nil(t, store.CreateNamespace("project-1-executions"))
+
+	guid, err := store.Create("projects", &data{"a", 0})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Update("projects", guid, &data{"a", 1}))
+	_, err = store.CompareAndUpdate("projects", guid, 2, &data{"a", 2})
+	assert.Nil(t, err)
+	execution, err := store.Create("project-1-executions", &data{"plan", 0})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Delete("projects", guid))
+	assert.Nil(t, store.DeleteNamespace("project-1-executions"))
+
+	// failed changes are not published
+	assert.NotNil(t, store.Update("projects", guid, &data{"a", 3}))
+
+	changes := receive(all)
+	if assert.Equal(t, 6, len(changes)) {
+		for i, c := range changes {
+			assert.Equal(t, uint64(i+1), c.Sequence)
+		}
+		assert.Equal(t, Created, changes[0].Operation)
+		assert.Equal(t, guid, changes[0].GUID)
+		assert.Equal(t, Updated, changes[1].Operation)
+		assert.Equal(t, Updated, changes[2].Operation)
+		assert.Equal(t, Deleted, changes[4].Operation)
+		assert.Equal(t, Change{Sequence: 6, Operation: Deleted, Namespace: "project-1-executions", Time: changes[5].Time}, changes[5])
+	}
+
+	changes = receive(executions)
+	if assert.Equal(t, 2, len(changes)) {
+		assert.Equal(t, execution, changes[0].GUID)
+		assert.Equal(t, uint64(4), changes[0].Sequence)
+	}
+
+	// closed subscriptions receive nothing more
+	all.Close()
+	all.Close()
+	_, err = store.Create("projects", &data{"b", 0})
+	assert.Nil(t, err)
+	_, open := <-all.Changes
+	assert.False(t, open)
+}
+
+func TestWatchedStore_drops_changes_of_slow_subscribers(t *testing.T) {
+	store := NewWatchedStore(NewMemoryStore())
+	slow := store.Watch(2, nil)
+	defer slow.Close()
+
+	assert.Nil(t, store.CreateNamespace("values"))
+	for i := 0; i < 5; i++ {
+		_, err := store.Create("values", &data{"a", i})
+		assert.Nil(t, err)
+	}
+
+	// the newest changes are dropped, leaving a gap after the changes held
+	changes := receive(slow)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, uint64(2), changes[1].Sequence)
+	assert.Equal(t, uint64(3), slow.Dropped())
+
+	_, err := store.Create("values", &data{"b", 0})
+	assert.Nil(t, err)
+	changes = receive(slow)
+	assert.Equal(t, uint64(6), changes[0].Sequence)
+}
+
+func TestUnwrap(t *testing.T) {
+	store := NewMemoryStore()
+	assert.Equal(t, store, Unwrap(NewWatchedStore(store)))
+	assert.Equal(t, store, Unwrap(store))
+}
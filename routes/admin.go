@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/webdevwilson/tfwatch/controller"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"PUT", "/api/admin/import", adminImport},
+		}...)
+		s.registerEndpoint("GET", "/api/admin/export", adminExport)
+	}
+}
+
+// adminExport streams an archive of the store. An error once the archive is being written can only be
+// logged, the archive is left incomplete and cannot be imported.
+func adminExport(resp http.ResponseWriter, req *http.Request) {
+	log.Printf("[INFO] %s %s", req.Method, req.URL)
+
+	id := requestID(req)
+	resp.Header().Set("X-Request-ID", id)
+	resp.Header().Set("Content-Type", "application/json")
+
+	w := &startedWriter{w: resp}
+	if err := adminController().Export(w); err != nil {
+		log.Printf("[ERROR] Error exporting store, request %s: %s", id, err)
+		if !w.started {
+			writeError(resp, id, err)
+		}
+	}
+}
+
+// startedWriter records whether anything was written
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
+
+func adminImport(req *http.Request) (data interface{}, err error) {
+	strategy, err := persist.ParseImportStrategy(req.URL.Query().Get("strategy"))
+	if err != nil {
+		return nil, controller.Invalid("%s", err)
+	}
+
+	var archive persist.Archive
+	err = decodeBody(req, &archive)
+	if err != nil {
+		return
+	}
+
+	data, err = adminController().Import(&archive, strategy)
+	return
+}
@@ -2,40 +2,77 @@ package routes
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/webdevwilson/tfwatch/controller"
+	"github.com/webdevwilson/tfwatch/persist"
 )
 
 // function contract for API endpoints used to add common behavior to all API endpoints
 type apiHandlerFunc func(*http.Request) (interface{}, error)
 
+// tagged is implemented by data with an entity tag, returned in the ETag header
+type tagged interface {
+	ETag() string
+}
+
+// cookied is implemented by data setting cookies, such as the session cookie of a login
+type cookied interface {
+	Cookies() []*http.Cookie
+}
+
 type api struct {
 	method  string
 	path    string
 	handler apiHandlerFunc
 }
 
+// apiError is the body of an API error response. The request id is logged with the error, and returned
+// in the X-Request-ID header of every API response.
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
 // ServeHTTP is used to service all API requests
 func (api apiHandlerFunc) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	log.Printf("[INFO] %s %s", req.Method, req.URL)
 
+	id := requestID(req)
+	resp.Header().Set("X-Request-ID", id)
+	resp.Header().Add("Access-Control-Allow-Origin", "http://localhost:8080")
+	resp.Header().Add("Access-Control-Allow-Credentials", "true")
+
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("[ERROR] Handler recovered from panic: %s\n%s", r, debug.Stack())
+			log.Printf("[ERROR] Handler recovered from panic in request %s: %s\n%s", id, r, debug.Stack())
+			writeError(resp, id, fmt.Errorf("%s", r))
 		}
 	}()
 
 	data, err := api(req)
 	if err != nil {
-		log.Printf("[ERROR] Error in handler '%s': %s", req.URL, err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		log.Printf("[ERROR] Error in handler '%s', request %s: %s", req.URL, id, err)
+		writeError(resp, id, err)
 		return
 	}
 
+	if t, ok := data.(tagged); ok {
+		resp.Header().Set("ETag", t.ETag())
+	}
+	if c, ok := data.(cookied); ok {
+		for _, cookie := range c.Cookies() {
+			http.SetCookie(resp, cookie)
+		}
+	}
 	resp.Header().Add("Content-Type", "application/json")
-	resp.Header().Add("Access-Control-Allow-Origin", "http://localhost:8080")
-	resp.Header().Add("Access-Control-Allow-Credentials", "true")
 	err = json.NewEncoder(resp).Encode(data)
 	if err != nil {
 		log.Printf("[ERROR] Error encoding response '%s': %s", req.URL, err)
@@ -43,3 +80,75 @@ func (api apiHandlerFunc) ServeHTTP(resp http.ResponseWriter, req *http.Request)
 		return
 	}
 }
+
+// writeError writes the status and JSON body of an error. The messages of unexpected errors are only
+// logged, they may describe the internals of the service.
+func writeError(resp http.ResponseWriter, id string, err error) {
+	status, code := errorStatus(err)
+	body := &apiError{code, err.Error(), id}
+	if status == http.StatusInternalServerError {
+		body.Message = "Internal server error"
+	}
+
+	resp.Header().Set("X-Request-ID", id)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	if err := json.NewEncoder(resp).Encode(body); err != nil {
+		log.Printf("[ERROR] Error encoding error of request %s: %s", id, err)
+	}
+}
+
+// errorStatus returns the HTTP status and code of an error
+func errorStatus(err error) (int, string) {
+	switch err.(type) {
+	case *controller.NotFoundError, *persist.NotFoundError:
+		return http.StatusNotFound, "not_found"
+	case *controller.ValidationError:
+		return http.StatusBadRequest, "invalid"
+	case *controller.ConflictError, *persist.ConflictError, *persist.DuplicateError:
+		return http.StatusConflict, "conflict"
+	case *controller.UnauthorizedError:
+		return http.StatusUnauthorized, "unauthorized"
+	case *controller.ForbiddenError:
+		return http.StatusForbidden, "forbidden"
+	case *controller.UnavailableError:
+		return http.StatusServiceUnavailable, "unavailable"
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// requestID returns the id of a request, the X-Request-ID header set by a proxy or a new id
+func requestID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 {
+		return id
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
+
+// decodeBody decodes the JSON body of a request, a *controller.ValidationError is returned when it
+// is not valid JSON
+func decodeBody(req *http.Request, value interface{}) error {
+	if err := json.NewDecoder(req.Body).Decode(value); err != nil {
+		return controller.Invalid("Invalid JSON body: %s", err)
+	}
+	return nil
+}
+
+// ifMatch returns the revision in the request's If-Match header, 0 when the header is absent or "*"
+func ifMatch(req *http.Request) (uint64, error) {
+	tag := req.Header.Get("If-Match")
+	if tag == "" || tag == "*" {
+		return 0, nil
+	}
+
+	rev, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(tag, "W/"), `"`), 10, 64)
+	if err != nil {
+		return 0, controller.Invalid("Invalid If-Match header '%s'", tag)
+	}
+	return rev, nil
+}
@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/webdevwilson/tfwatch/controller"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+// sessionCookie is the cookie holding the id of a login session
+const sessionCookie = "tfwatch_session"
+
+// csrfHeader is the header the CSRF token of a session is sent in
+const csrfHeader = "X-CSRF-Token"
+
+// AuthConfig configures how the server authenticates requests. With a proxy header, requests from a
+// trusted proxy are made by the user named in the header.
+type AuthConfig struct {
+	Enabled        bool
+	ProxyHeader    string
+	TrustedProxies []*net.IPNet
+}
+
+// identityKey is the context key of the identity making a request
+type identityKey struct{}
+
+// publicEndpoints need no identity, they establish one
+var publicEndpoints = map[string]bool{
+	"POST /api/login":  true,
+	"POST /api/logout": true,
+}
+
+// endpointScopes are the scopes of the endpoints that change something but need less than admin
+var endpointScopes = map[string]model.TokenScope{
+	"PUT /api/projects/{guid}/tfplan":  model.ScopePlan,
+	"POST /api/projects/{guid}/tfplan": model.ScopeApply,
+	"POST /api/session/password":       model.ScopeRead,
+}
+
+// endpointScope returns the scope needed to call an endpoint, empty for endpoints that need no
+// identity. Reading needs read, running plans plan, applying apply, and any other change admin. Admin
+// endpoints, pruning, tokens and users need admin to read too.
+func endpointScope(method, path string) model.TokenScope {
+	if !strings.HasPrefix(path, "/api/") || publicEndpoints[method+" "+path] {
+		return ""
+	}
+	for _, prefix := range []string{"/api/admin/", "/api/tokens", "/api/users"} {
+		if strings.HasPrefix(path, prefix) {
+			return model.ScopeAdmin
+		}
+	}
+	if path == "/api/prune" {
+		return model.ScopeAdmin
+	}
+	if scope, ok := endpointScopes[method+" "+path]; ok {
+		return scope
+	}
+	if method == http.MethodGet {
+		return model.ScopeRead
+	}
+	return model.ScopeAdmin
+}
+
+// authorize wraps the handler of an endpoint, requiring an identity allowed the scope. The identity is
+// added to the request's context. Nothing is required when auth is disabled or the endpoint needs no
+// scope.
+func (s *server) authorize(scope model.TokenScope, h http.Handler) http.Handler {
+	if !s.auth.Enabled || scope == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		id := requestID(req)
+		req.Header.Set("X-Request-ID", id)
+
+		identity, err := s.authenticate(req)
+		if err == nil && !identity.Allows(scope) {
+			err = controller.Forbidden("'%s' does not have the %s scope", identity, scope)
+		}
+		if err != nil {
+			log.Printf("[WARN] Refused %s %s, request %s: %s", req.Method, req.URL.Path, id, err)
+			if _, ok := err.(*controller.UnauthorizedError); ok {
+				resp.Header().Set("WWW-Authenticate", `Bearer realm="tfwatch"`)
+			}
+			writeError(resp, id, err)
+			return
+		}
+
+		h.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), identityKey{}, identity)))
+	})
+}
+
+// authenticate returns the identity making a request: the bearer of the token in the Authorization
+// header, the user named by a trusted proxy, or the user of the session cookie. Requests that change
+// something with a cookie must send the session's CSRF token.
+func (s *server) authenticate(req *http.Request) (*model.Identity, error) {
+	if header := req.Header.Get("Authorization"); header != "" {
+		secret := strings.TrimPrefix(header, "Bearer ")
+		if secret == header {
+			return nil, controller.Unauthorized("Invalid Authorization header, expected a bearer token")
+		}
+		token, err := s.tokens.Authenticate(strings.TrimSpace(secret))
+		if err != nil {
+			return nil, err
+		}
+		return token.Identity(), nil
+	}
+
+	if username := s.proxyUser(req); username != "" {
+		identity, err := s.proxyIdentity(username)
+		if err != nil {
+			return nil, err
+		}
+		if !safeMethod(req.Method) {
+			session, err := requestSession(s.users, req)
+			if err != nil || session.Username != username {
+				return nil, controller.Forbidden("A CSRF token is required, read it from /api/session")
+			}
+			return identity, checkCSRF(req, session)
+		}
+		return identity, nil
+	}
+
+	session, err := requestSession(s.users, req)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.Lookup(session.Username)
+	if _, ok := err.(*controller.NotFoundError); ok {
+		return nil, controller.Unauthorized("User '%s' no longer exists", session.Username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user.Identity(), checkCSRF(req, session)
+}
+
+// proxyUser returns the user named in the proxy header, empty when there is no proxy header or the
+// request is not from a trusted proxy
+func (s *server) proxyUser(req *http.Request) string {
+	if s.auth.ProxyHeader == "" {
+		return ""
+	}
+	username := strings.TrimSpace(req.Header.Get(s.auth.ProxyHeader))
+	if username == "" {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, trusted := range s.auth.TrustedProxies {
+			if trusted.Contains(ip) {
+				return username
+			}
+		}
+	}
+
+	log.Printf("[WARN] Ignoring the %s header of a request from %s, not a trusted proxy", s.auth.ProxyHeader, host)
+	return ""
+}
+
+// proxyIdentity returns the identity of a user named by a proxy. Users without a local account are
+// allowed to read, a local account with the same username gives the user its role.
+func (s *server) proxyIdentity(username string) (*model.Identity, error) {
+	user, err := s.users.Lookup(username)
+	if _, ok := err.(*controller.NotFoundError); ok {
+		return &model.Identity{Name: username, Kind: model.IdentityUser, Scopes: []model.TokenScope{model.ScopeRead}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user.Identity(), nil
+}
+
+// requestSession returns the session of the request's session cookie
+func requestSession(users controller.Users, req *http.Request) (*model.Session, error) {
+	cookie, err := req.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, controller.Unauthorized("Log in, or send a token in the Authorization header")
+	}
+	return users.Session(cookie.Value)
+}
+
+// checkCSRF checks that a request changing something sends the CSRF token of its session
+func checkCSRF(req *http.Request, session *model.Session) error {
+	if safeMethod(req.Method) || session.ValidCSRFToken(req.Header.Get(csrfHeader)) {
+		return nil
+	}
+	return controller.Forbidden("Missing or invalid CSRF token, send the token of the session in the %s header", csrfHeader)
+}
+
+// safeMethod returns true for methods that do not change anything
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requestIdentity returns the identity making a request, nil when auth is disabled
+func requestIdentity(req *http.Request) *model.Identity {
+	identity, _ := req.Context().Value(identityKey{}).(*model.Identity)
+	return identity
+}
+
+// actor returns the name recorded for the identity making a request, empty when auth is disabled
+func actor(req *http.Request) string {
+	if identity := requestIdentity(req); identity != nil {
+		return identity.String()
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/controller"
+	"github.com/webdevwilson/tfwatch/model"
+	"github.com/webdevwilson/tfwatch/persist"
+)
+
+func Test_endpointScope(t *testing.T) {
+	for _, c := range []struct {
+		method, path string
+		scope        model.TokenScope
+	}{
+		{"GET", "/status", ""},
+		{"GET", "/site/{path:.*}", ""},
+		{"GET", "/api/projects", model.ScopeRead},
+		{"GET", "/api/events", model.ScopeRead},
+		{"PUT", "/api/projects/{guid}/tfplan", model.ScopePlan},
+		{"POST", "/api/projects/{guid}/tfplan", model.ScopeApply},
+		{"POST", "/api/projects/{guid}", model.ScopeAdmin},
+		{"GET", "/api/admin/export", model.ScopeAdmin},
+		{"GET", "/api/tokens", model.ScopeAdmin},
+		{"PUT", "/api/prune", model.ScopeAdmin},
+		{"GET", "/api/users", model.ScopeAdmin},
+		{"POST", "/api/login", ""},
+		{"POST", "/api/logout", ""},
+		{"GET", "/api/session", model.ScopeRead},
+		{"POST", "/api/session/password", model.ScopeRead},
+	} {
+		assert.Equal(t, c.scope, endpointScope(c.method, c.path), c.method+" "+c.path)
+	}
+}
+
+func Test_authorize(t *testing.T) {
+	tokens := controller.NewTokensController(persist.NewMemoryStore())
+	s := &server{auth: AuthConfig{Enabled: true}, tokens: tokens}
+
+	planner := &model.Token{Name: "planner", Scopes: []model.TokenScope{model.ScopePlan}}
+	assert.Nil(t, tokens.Create(planner))
+	expired := &model.Token{Name: "expired", Scopes: []model.TokenScope{model.ScopeAdmin}, Expires: time.Now().Add(-time.Minute)}
+	assert.Nil(t, tokens.Create(expired))
+
+	ok := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {})
+	status := func(scope model.TokenScope, header string) int {
+		req := httptest.NewRequest("GET", "/api/projects", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp := httptest.NewRecorder()
+		s.authorize(scope, ok).ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, status(model.ScopeRead, ""))
+	assert.Equal(t, http.StatusUnauthorized, status(model.ScopeRead, "Basic abc"))
+	assert.Equal(t, http.StatusUnauthorized, status(model.ScopeRead, "Bearer tfw_unknown"))
+	assert.Equal(t, http.StatusUnauthorized, status(model.ScopeRead, "Bearer "+expired.Secret))
+	assert.Equal(t, http.StatusOK, status(model.ScopeRead, "Bearer "+planner.Secret))
+	assert.Equal(t, http.StatusOK, status(model.ScopePlan, "Bearer "+planner.Secret))
+	assert.Equal(t, http.StatusForbidden, status(model.ScopeApply, "Bearer "+planner.Secret))
+	assert.Equal(t, http.StatusOK, status("", ""))
+
+	// revoked tokens are refused
+	assert.Nil(t, tokens.Revoke(planner.GUID))
+	assert.Equal(t, http.StatusUnauthorized, status(model.ScopeRead, "Bearer "+planner.Secret))
+
+	// nothing is required when auth is disabled
+	s.auth.Enabled = false
+	assert.Equal(t, http.StatusOK, status(model.ScopeAdmin, ""))
+}
+
+func Test_authorize_session(t *testing.T) {
+	users := controller.NewUsersController(persist.NewMemoryStore())
+	s := &server{auth: AuthConfig{Enabled: true}, users: users}
+
+	alice := &model.User{Username: "alice", Role: model.ScopePlan, Password: "correct horse"}
+	assert.Nil(t, users.Create(alice))
+	session, err := users.Login("alice", "correct horse")
+	assert.Nil(t, err)
+
+	var name string
+	ok := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) { name = actor(req) })
+	status := func(method string, scope model.TokenScope, cookie, csrf string) int {
+		req := httptest.NewRequest(method, "/api/projects", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+		}
+		if csrf != "" {
+			req.Header.Set(csrfHeader, csrf)
+		}
+		resp := httptest.NewRecorder()
+		s.authorize(scope, ok).ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, status("GET", model.ScopeRead, "", ""))
+	assert.Equal(t, http.StatusUnauthorized, status("GET", model.ScopeRead, "unknown", ""))
+	assert.Equal(t, http.StatusOK, status("GET", model.ScopeRead, session.ID, ""))
+	assert.Equal(t, "alice", name)
+
+	// changes need the CSRF token of the session
+	assert.Equal(t, http.StatusForbidden, status("PUT", model.ScopePlan, session.ID, ""))
+	assert.Equal(t, http.StatusForbidden, status("PUT", model.ScopePlan, session.ID, "wrong"))
+	assert.Equal(t, http.StatusOK, status("PUT", model.ScopePlan, session.ID, session.CSRFToken))
+	assert.Equal(t, http.StatusForbidden, status("POST", model.ScopeApply, session.ID, session.CSRFToken))
+
+	// changing the password ends the user's other sessions
+	stale, err := users.Login("alice", "correct horse")
+	assert.Nil(t, err)
+	assert.Nil(t, users.SetPassword(alice.GUID, "battery staple", session.ID))
+	assert.Equal(t, http.StatusUnauthorized, status("GET", model.ScopeRead, stale.ID, ""))
+	assert.Equal(t, http.StatusOK, status("GET", model.ScopeRead, session.ID, ""))
+
+	// sessions end when users log out or are deleted
+	other, err := users.Login("alice", "battery staple")
+	assert.Nil(t, err)
+	assert.Nil(t, users.Logout(session.ID))
+	assert.Equal(t, http.StatusUnauthorized, status("GET", model.ScopeRead, session.ID, ""))
+	assert.Nil(t, users.Delete(alice.GUID))
+	assert.Equal(t, http.StatusUnauthorized, status("GET", model.ScopeRead, other.ID, ""))
+}
+
+func Test_authorize_proxy(t *testing.T) {
+	users := controller.NewUsersController(persist.NewMemoryStore())
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	s := &server{auth: AuthConfig{Enabled: true, ProxyHeader: "X-Forwarded-User", TrustedProxies: []*net.IPNet{loopback}}, users: users}
+
+	assert.Nil(t, users.Create(&model.User{Username: "carol", Role: model.ScopeAdmin, Password: "correct horse"}))
+
+	var name string
+	ok := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) { name = actor(req) })
+	status := func(method string, scope model.TokenScope, remote, user string) int {
+		req := httptest.NewRequest(method, "/api/projects", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-User", user)
+		resp := httptest.NewRecorder()
+		s.authorize(scope, ok).ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// users without an account can read
+	assert.Equal(t, http.StatusOK, status("GET", model.ScopeRead, "127.0.0.1:41000", "bob"))
+	assert.Equal(t, "bob", name)
+	assert.Equal(t, http.StatusForbidden, status("GET", model.ScopePlan, "127.0.0.1:41000", "bob"))
+
+	// users with an account have its role
+	assert.Equal(t, http.StatusOK, status("GET", model.ScopeAdmin, "127.0.0.1:41000", "carol"))
+
+	// changes need the CSRF token of a session
+	assert.Equal(t, http.StatusForbidden, status("PUT", model.ScopeAdmin, "127.0.0.1:41000", "carol"))
+
+	// the header of other hosts is ignored
+	assert.Equal(t, http.StatusUnauthorized, status("GET", model.ScopeRead, "192.0.2.1:41000", "carol"))
+}
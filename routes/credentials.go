@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"GET", "/api/credentials", credentialList},
+			api{"PUT", "/api/credentials", credentialCreate},
+			api{"GET", "/api/credentials/{credential}", credentialGet},
+			api{"POST", "/api/credentials/{credential}", credentialUpdate},
+			api{"DELETE", "/api/credentials/{credential}", credentialDelete},
+		}...)
+	}
+}
+
+func credentialList(req *http.Request) (data interface{}, err error) {
+	return credentialsController().List()
+}
+
+func credentialGet(req *http.Request) (data interface{}, err error) {
+	return credentialsController().Get(mux.Vars(req)["credential"])
+}
+
+func credentialCreate(req *http.Request) (data interface{}, err error) {
+	var profile model.CredentialProfile
+	err = decodeBody(req, &profile)
+	if err != nil {
+		return
+	}
+
+	err = credentialsController().Create(&profile)
+	if err != nil {
+		return
+	}
+
+	return profile, nil
+}
+
+func credentialUpdate(req *http.Request) (data interface{}, err error) {
+	var profile model.CredentialProfile
+	err = decodeBody(req, &profile)
+	if err != nil {
+		return
+	}
+	profile.GUID = mux.Vars(req)["credential"]
+
+	err = credentialsController().Update(&profile)
+	if err != nil {
+		return
+	}
+
+	return profile, nil
+}
+
+func credentialDelete(req *http.Request) (data interface{}, err error) {
+	err = credentialsController().Delete(mux.Vars(req)["credential"])
+	return
+}
@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webdevwilson/tfwatch/controller"
+)
+
+// eventsKeepAlive is the interval of the comments sent to keep idle event streams open
+const eventsKeepAlive = 30 * time.Second
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerEndpoint("GET", "/api/events", eventStream)
+	}
+}
+
+// eventStream streams events as server-sent events until the client disconnects. The project and type
+// query parameters filter the events, type may be repeated or a comma separated list. A reconnecting
+// client resumes after the id in its Last-Event-ID header, or the last_event_id query parameter. A
+// client that falls behind is sent a reset event and the stream ends, the browser reconnects after it.
+func eventStream(resp http.ResponseWriter, req *http.Request) {
+	log.Printf("[INFO] %s %s", req.Method, req.URL)
+
+	id := requestID(req)
+	resp.Header().Set("X-Request-ID", id)
+
+	filter, after, err := eventFilter(req)
+	if err != nil {
+		writeError(resp, id, err)
+		return
+	}
+
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		log.Printf("[ERROR] Streaming is not supported by the response writer of request %s", id)
+		writeError(resp, id, fmt.Errorf("Streaming is not supported"))
+		return
+	}
+
+	held, sub := eventsController().Subscribe(after, filter)
+	defer sub.Close()
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.Header().Add("Access-Control-Allow-Origin", "http://localhost:8080")
+	resp.Header().Add("Access-Control-Allow-Credentials", "true")
+	resp.WriteHeader(http.StatusOK)
+
+	for _, e := range held {
+		if err := writeEvent(resp, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(resp, e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(resp, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes an event in the server-sent events format
+func writeEvent(resp http.ResponseWriter, e *controller.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[ERROR] Error encoding event %d: %s", e.ID, err)
+		return nil
+	}
+
+	_, err = fmt.Fprintf(resp, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// eventFilter reads the filter of an event stream and the id of the last event received
+func eventFilter(req *http.Request) (filter controller.EventFilter, after uint64, err error) {
+	values := req.URL.Query()
+	filter.ProjectGUID = values.Get("project")
+
+	for _, v := range values["type"] {
+		for _, name := range strings.Split(v, ",") {
+			t := controller.EventType(strings.TrimSpace(name))
+			if !knownEventType(t) {
+				return filter, 0, controller.Invalid("Invalid type '%s'", name)
+			}
+			filter.Types = append(filter.Types, t)
+		}
+	}
+
+	last := req.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = values.Get("last_event_id")
+	}
+	if last != "" {
+		if after, err = strconv.ParseUint(last, 10, 64); err != nil {
+			return filter, 0, controller.Invalid("Invalid last event id '%s'", last)
+		}
+	}
+	return filter, after, nil
+}
+
+// knownEventType returns true for the types of events published
+func knownEventType(t controller.EventType) bool {
+	for _, known := range controller.EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
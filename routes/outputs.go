@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"GET", "/api/outputs", outputsFind},
+			api{"GET", "/api/projects/{guid}/outputs/{name}/history", projectOutputHistory},
+		}...)
+	}
+}
+
+func outputsFind(req *http.Request) (data interface{}, err error) {
+	return outputsController().Find(req.URL.Query().Get("name"))
+}
+
+func projectOutputHistory(req *http.Request) (data interface{}, err error) {
+	guid := mux.Vars(req)["guid"]
+
+	project, err := projectsController().Get(guid)
+	if err != nil {
+		return
+	}
+
+	return outputsController().History(project, mux.Vars(req)["name"])
+}
@@ -1,10 +1,9 @@
 package routes
 
 import (
+	"io/ioutil"
 	"net/http"
 
-	"encoding/json"
-
 	"github.com/gorilla/mux"
 	"github.com/webdevwilson/tfwatch/model"
 )
@@ -16,6 +15,7 @@ func init() {
 			api{"GET", "/api/projects/{guid}", projectGet},
 			api{"PUT", "/api/projects", projectCreate},
 			api{"POST", "/api/projects/{guid}", projectUpdate},
+			api{"PATCH", "/api/projects/{guid}", projectPatch},
 			api{"DELETE", "/api/projects/{guid}", projectDelete},
 		}...)
 	}
@@ -31,36 +31,49 @@ func projectGet(req *http.Request) (interface{}, error) {
 }
 
 func projectCreate(req *http.Request) (data interface{}, err error) {
-	var prj model.Project
-	json.NewDecoder(req.Body).Decode(&prj)
-	err = projectsController().Create(&prj)
-
+	var in model.ProjectInput
+	err = decodeBody(req, &in)
 	if err != nil {
 		return
 	}
 
-	data = prj
-
-	return
+	return projectsController().CreateFromInput(&in)
 }
 
 func projectUpdate(req *http.Request) (data interface{}, err error) {
-	var prj model.Project
-	json.NewDecoder(req.Body).Decode(&prj)
+	var in model.ProjectInput
+	err = decodeBody(req, &in)
+	if err != nil {
+		return
+	}
 
-	// ensure the project has the same guid as in the url
-	guid := mux.Vars(req)["guid"]
-	prj.GUID = guid
+	// the If-Match header takes precedence over the revision in the body
+	rev, err := ifMatch(req)
+	if err != nil {
+		return
+	}
+	if rev != 0 {
+		in.Revision = rev
+	}
 
-	err = projectsController().Update(&prj)
+	guid := mux.Vars(req)["guid"]
+	return projectsController().UpdateFromInput(guid, &in)
+}
 
+// projectPatch applies the JSON merge patch in the body to the project
+func projectPatch(req *http.Request) (data interface{}, err error) {
+	patch, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return
 	}
 
-	data = prj
+	rev, err := ifMatch(req)
+	if err != nil {
+		return
+	}
 
-	return
+	guid := mux.Vars(req)["guid"]
+	return projectsController().Patch(guid, rev, patch)
 }
 
 func projectDelete(req *http.Request) (data interface{}, err error) {
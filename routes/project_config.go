@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"GET", "/api/projects/{guid}/config", projectConfigGet},
+		}...)
+	}
+}
+
+func projectConfigGet(req *http.Request) (data interface{}, err error) {
+	guid := mux.Vars(req)["guid"]
+
+	project, err := projectsController().Get(guid)
+	if err != nil {
+		return
+	}
+
+	return project.Config()
+}
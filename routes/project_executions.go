@@ -2,8 +2,11 @@ package routes
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/webdevwilson/tfwatch/controller"
 )
 
 func init() {
@@ -20,6 +23,45 @@ func projectExecutions(req *http.Request) (data interface{}, err error) {
 	if err != nil {
 		return
 	}
-	data, err = projectsController().GetExecutions(prj)
+
+	filter, err := executionFilter(req)
+	if err != nil {
+		return
+	}
+
+	data, err = projectsController().GetExecutions(prj, filter)
 	return
 }
+
+// executionFilter reads the filter of an executions listing from the query string
+func executionFilter(req *http.Request) (filter controller.ExecutionFilter, err error) {
+	values := req.URL.Query()
+	filter.Type = values.Get("type")
+	filter.Trigger = values.Get("trigger")
+	filter.Actor = values.Get("actor")
+	filter.Cursor = values.Get("cursor")
+
+	if v := values.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return filter, controller.Invalid("Invalid limit '%s'", v)
+		}
+	}
+
+	if v := values.Get("exit_code"); v != "" {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, controller.Invalid("Invalid exit_code '%s'", v)
+		}
+		filter.ExitCode = &code
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := values.Get(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return filter, controller.Invalid("Invalid %s '%s', expected an RFC 3339 time", name, v)
+			}
+		}
+	}
+
+	return filter, nil
+}
@@ -13,13 +13,21 @@ func init() {
 	registrationCh <- func(s *server) {
 		s.registerAPIEndpoints([]api{
 			api{"GET", "/api/projects/{guid}/tfplan", projectPlanGet},
+			api{"GET", "/api/projects/{guid}/tfplan/modules", projectPlanModules},
+			api{"PUT", "/api/projects/{guid}/tfplan", projectPlanRun},
 			api{"POST", "/api/projects/{guid}/tfplan", projectPlanApply},
 		}...)
 	}
 }
 
+// planRequest runs a plan, variables override the stored values for this plan only
+type planRequest struct {
+	Variables map[string]string `json:"variables"`
+}
+
 type planDescription struct {
 	Resources []model.ResourceChange `json:"resources"`
+	Summary   *model.PlanSummary     `json:"summary"`
 }
 
 func projectPlanGet(req *http.Request) (data interface{}, err error) {
@@ -30,13 +38,52 @@ func projectPlanGet(req *http.Request) (data interface{}, err error) {
 		return
 	}
 
-	data = planDescription{project.PendingChanges}
+	data = planDescription{project.PendingChanges, project.Summary}
 
 	log.Printf("[DEBUG] Found %d resource modifications", len(project.PendingChanges))
 
 	return
 }
 
+func projectPlanModules(req *http.Request) (data interface{}, err error) {
+	guid := mux.Vars(req)["guid"]
+
+	project, err := projectsController().Get(guid)
+	if err != nil {
+		return
+	}
+
+	// sources are informational, a configuration that cannot be parsed still has a tree
+	sources, err := project.ModuleSources()
+	if err != nil {
+		log.Printf("[WARN] Error reading module sources for project '%s': %s", guid, err)
+	}
+
+	data = model.ModuleTree(project.PendingChanges, sources)
+
+	return data, nil
+}
+
+func projectPlanRun(req *http.Request) (data interface{}, err error) {
+	guid := mux.Vars(req)["guid"]
+
+	project, err := projectsController().Get(guid)
+	if err != nil {
+		return
+	}
+
+	var plan planRequest
+	if req.ContentLength != 0 {
+		err = decodeBody(req, &plan)
+		if err != nil {
+			return
+		}
+	}
+
+	data, err = projectsController().Plan(project, plan.Variables, actor(req))
+	return
+}
+
 func projectPlanApply(req *http.Request) (data interface{}, err error) {
 	guid := mux.Vars(req)["guid"]
 
@@ -45,7 +92,7 @@ func projectPlanApply(req *http.Request) (data interface{}, err error) {
 		return
 	}
 
-	data, err = projectsController().ExecutePlan(project)
+	data, err = projectsController().ExecutePlan(project, actor(req))
 	return
 }
 
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/webdevwilson/tfwatch/controller"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"GET", "/api/projects/{guid}/state", projectStateGet},
+			api{"GET", "/api/projects/{guid}/state/resources", projectStateResources},
+			api{"GET", "/api/projects/{guid}/state/resources/{address}", projectStateResource},
+			api{"GET", "/api/projects/{guid}/state/outputs", projectStateOutputs},
+		}...)
+	}
+}
+
+// projectState reads the state of the project in the request
+func projectState(req *http.Request) (*model.State, error) {
+	guid := mux.Vars(req)["guid"]
+
+	project, err := projectsController().Get(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	return stateController().Get(project)
+}
+
+func projectStateGet(req *http.Request) (data interface{}, err error) {
+	state, err := projectState(req)
+	if err != nil {
+		return
+	}
+	return state.Info(), nil
+}
+
+func projectStateResources(req *http.Request) (data interface{}, err error) {
+	state, err := projectState(req)
+	if err != nil {
+		return
+	}
+	return state.Resources(), nil
+}
+
+func projectStateResource(req *http.Request) (data interface{}, err error) {
+	state, err := projectState(req)
+	if err != nil {
+		return
+	}
+
+	address := mux.Vars(req)["address"]
+	resource := state.Resource(address)
+	if resource == nil {
+		return nil, controller.NotFound("Resource '%s' not found in state", address)
+	}
+
+	return resource, nil
+}
+
+func projectStateOutputs(req *http.Request) (data interface{}, err error) {
+	state, err := projectState(req)
+	if err != nil {
+		return
+	}
+	return state.Outputs(), nil
+}
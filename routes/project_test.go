@@ -1,38 +1,58 @@
 package routes
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/webdevwilson/tfwatch/client"
 	"github.com/webdevwilson/tfwatch/controller"
 	"github.com/webdevwilson/tfwatch/execute"
 	"github.com/webdevwilson/tfwatch/model"
 	"github.com/webdevwilson/tfwatch/persist"
+	"github.com/webdevwilson/tfwatch/secure"
 	"github.com/webdevwilson/tfwatch/test"
 )
 
-var project = model.Project{
-	GUID:      "001",
-	Name:      "foo",
-	LocalPath: "/foo",
+var project = model.ProjectInput{
+	Name: "foo",
+	Path: "terraform_applied",
 	Settings: map[string]string{
 		"FOO": "BAR",
 	},
-	Status: model.ProjectStatusNew,
+}
+
+var testServer struct {
+	addr string
+	once sync.Once
 }
 
 // Ideally, we should just build a context, however there are cyclic dependency issues
-// so I am sticking this here for now
+// so I am sticking this here for now. The server is a singleton, so it is only started once.
 func startTestServer() string {
+	testServer.once.Do(func() {
+		testServer.addr = startServer()
+	})
+	return testServer.addr
+}
+
+func startServer() string {
 
 	// get random ephemeral port
 	test.SuppressLogs()
+	rand.Seed(time.Now().UnixNano())
 	port := uint16(rand.Int31n(16383) + 49152)
 
 	cwd, err := os.Getwd()
@@ -43,31 +63,244 @@ func startTestServer() string {
 	// use fixtures directory
 	checkoutDir := path.Clean(path.Join(cwd, "..", "fixtures"))
 	siteDir := path.Clean(path.Join(cwd, "..", "site", "dist"))
-	stateDir := path.Join(checkoutDir, ".tfwatch")
+	stateDir, err := ioutil.TempDir("", "tfwatch")
+	if err != nil {
+		panic(err)
+	}
 	logDir := path.Join(stateDir, "logs")
 
-	store, err := persist.NewBoltStore(stateDir)
+	bolt, err := persist.NewBoltStore(stateDir, persist.GobEncoding)
+	if err != nil {
+		panic(err)
+	}
+	store := persist.NewWatchedStore(bolt)
+	events := controller.NewEventsController(store, store)
+	exec := execute.NewExecutor(store, logDir, nil)
+	sys := controller.NewSystemController([]controller.SystemConfigurationValue{}, exec)
+	cipher, err := secure.NewCipher(bytes.Repeat([]byte{1}, secure.KeySize))
 	if err != nil {
 		panic(err)
 	}
-	exec := execute.NewExecutor(store, logDir)
-	sys := controller.NewSystemController([]*controller.SystemConfigurationValue{}, exec)
-	prj := controller.NewProjectsController(checkoutDir, store, exec, 5*time.Minute, false)
+	variables := controller.NewVariablesController(store, cipher)
+	credentials := controller.NewCredentialsController(store, cipher)
+	state := controller.NewStateController(exec, credentials)
+	outputs := controller.NewOutputsController(store, state)
+	prj := controller.NewProjectsController(checkoutDir, store, exec, outputs, variables, credentials, events, 5*time.Minute, false)
 
-	server := InitializeServer(port, ioutil.Discard, sys, prj, siteDir)
+	// auth is tested on servers of its own, see auth_test.go
+	server := InitializeServer(port, ioutil.Discard, Controllers{
+		Credentials: credentials,
+		Events:      events,
+		Outputs:     outputs,
+		Projects:    prj,
+		State:       state,
+		System:      sys,
+		Variables:   variables,
+	}, siteDir, AuthConfig{})
 	go server.Start()
 
-	return fmt.Sprintf("http://localhost:%d", port)
+	// wait for the server to accept connections
+	addr := fmt.Sprintf("localhost:%d", port)
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Sprintf("http://%s", addr)
 }
 
 func Test_Project_Create(t *testing.T) {
 	sockAddr := startTestServer()
 	projects := client.NewProjectClient(sockAddr)
 
-	err := projects.Create(&project)
+	prj, err := projects.Create(&project)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	defer projects.Delete(prj.GUID)
+
+	assert.Equal(t, "foo", prj.Name)
+	assert.Equal(t, model.ProjectStatusNew, prj.Status)
+	assert.Equal(t, "BAR", prj.Settings["FOO"])
+}
+
+func Test_Project_Create_invalid(t *testing.T) {
+	sockAddr := startTestServer()
+	projects := client.NewProjectClient(sockAddr)
+
+	for _, in := range []model.ProjectInput{
+		{Name: ""},
+		{Name: "missing"},
+		{Name: "escape", Path: "../persist"},
+		{Name: "notf", Path: "."},
+		{Name: "sets", Path: "terraform_applied", VariableSets: []string{"missing"}},
+	} {
+		_, err := projects.Create(&in)
+		if assert.IsType(t, &client.APIError{}, err, in.Name) {
+			assert.Equal(t, http.StatusBadRequest, err.(*client.APIError).StatusCode, in.Name)
+		}
+	}
+}
+
+func Test_Project_Update_keeps_server_fields(t *testing.T) {
+	sockAddr := startTestServer()
+	projects := client.NewProjectClient(sockAddr)
+
+	prj, err := projects.Create(&model.ProjectInput{Name: "fields", Path: "terraform_applied"})
+	assert.Nil(t, err)
+	defer projects.Delete(prj.GUID)
+
+	// server managed fields in the body are ignored
+	body := `{"name": "fields", "settings": {"a": "b"}, "status": "ok", "pending_changes": [{"resource_id": "forged"}]}`
+	resp, err := http.Post(fmt.Sprintf("%s/api/projects/%s", sockAddr, prj.GUID), "application/json", strings.NewReader(body))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	updated, err := projects.Get(prj.GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, model.ProjectStatusNew, updated.Status)
+	assert.Empty(t, updated.PendingChanges)
+	assert.Equal(t, "b", updated.Settings["a"])
+
+	// patches change only the fields they name
+	patched, err := projects.Patch(prj.GUID, map[string]interface{}{
+		"settings": map[string]interface{}{"a": nil, "c": "d"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "fields", patched.Name)
+	assert.Equal(t, map[string]string{"c": "d"}, patched.Settings)
+	assert.Equal(t, uint64(3), patched.Revision)
+
+	_, err = projects.Patch(prj.GUID, map[string]interface{}{"revision": 1, "name": "stale"})
+	if assert.IsType(t, &client.APIError{}, err) {
+		assert.Equal(t, http.StatusConflict, err.(*client.APIError).StatusCode)
+	}
+
+	_, err = projects.Patch(prj.GUID, map[string]interface{}{"path": "terraform_planned"})
+	if assert.IsType(t, &client.APIError{}, err) {
+		assert.Equal(t, http.StatusBadRequest, err.(*client.APIError).StatusCode)
 	}
+}
+
+func Test_Project_Update_conflict(t *testing.T) {
+	sockAddr := startTestServer()
+	projects := client.NewProjectClient(sockAddr)
+
+	created, err := projects.Create(&model.ProjectInput{Name: "conflict", Path: "terraform_applied"})
+	assert.Nil(t, err)
+	defer projects.Delete(created.GUID)
+	assert.Equal(t, uint64(1), created.Revision)
+
+	prj := *created
+	stale := prj
+	prj.Settings = map[string]string{"a": "b"}
+	assert.Nil(t, projects.Update(&prj))
+	assert.Equal(t, uint64(2), prj.Revision)
+
+	// the body's revision is no longer current
+	assert.Error(t, projects.Update(&stale))
+
+	// nor is the If-Match header's
+	body, _ := json.Marshal(prj.Input())
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/projects/%s", sockAddr, prj.GUID), bytes.NewBuffer(body))
+	req.Header.Set("If-Match", `"1"`)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
 
-	projects.Delete(project.GUID)
+	resp, err = http.Get(fmt.Sprintf("%s/api/projects/%s", sockAddr, prj.GUID))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+}
+
+func Test_Events_stream(t *testing.T) {
+	sockAddr := startTestServer()
+	projects := client.NewProjectClient(sockAddr)
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/events?type=project_status_changed", sockAddr))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	prj, err := projects.Create(&model.ProjectInput{Name: "events", Path: "terraform_applied"})
+	assert.Nil(t, err)
+	defer projects.Delete(prj.GUID)
+
+	// read events until the project's status is received
+	lines := bufio.NewScanner(resp.Body)
+	var id, event string
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e := controller.Event{}
+			assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+			if e.ProjectGUID != prj.GUID {
+				continue
+			}
+			assert.Equal(t, "project_status_changed", event)
+			assert.Equal(t, fmt.Sprint(e.ID), id)
+			return
+		}
+	}
+	t.Error("Stream ended without the project's event")
+}
+
+func Test_Events_invalid_filter(t *testing.T) {
+	sockAddr := startTestServer()
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/events?type=unknown", sockAddr))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_Project_errors(t *testing.T) {
+	sockAddr := startTestServer()
+
+	// errors have a JSON body with the request id of the response
+	expect := func(resp *http.Response, status int, code string) {
+		defer resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode)
+
+		var body struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		}
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, code, body.Code)
+		assert.NotEmpty(t, body.Message)
+		assert.NotEmpty(t, body.RequestID)
+		assert.Equal(t, resp.Header.Get("X-Request-ID"), body.RequestID)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/projects/missing", sockAddr))
+	assert.Nil(t, err)
+	expect(resp, http.StatusNotFound, "not_found")
+
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/api/projects", sockAddr), strings.NewReader(`{"name": `))
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	expect(resp, http.StatusBadRequest, "invalid")
+
+	resp, err = http.Post(fmt.Sprintf("%s/api/projects/missing", sockAddr), "application/json", strings.NewReader(`[]`))
+	assert.Nil(t, err)
+	expect(resp, http.StatusBadRequest, "invalid")
+
+	_, err = client.NewProjectClient(sockAddr).Get("missing")
+	if assert.IsType(t, &client.APIError{}, err) {
+		assert.Equal(t, "not_found", err.(*client.APIError).Code)
+	}
 }
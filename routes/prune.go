@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/webdevwilson/tfwatch/controller"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"PUT", "/api/prune", pruneRun},
+		}...)
+	}
+}
+
+// pruneRun prunes executions now, a dry run reports what would be pruned
+func pruneRun(req *http.Request) (data interface{}, err error) {
+	dryRun := false
+	if v := req.URL.Query().Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			return nil, controller.Invalid("Invalid dry_run '%s'", v)
+		}
+	}
+
+	data, err = prunerController().Prune(dryRun)
+	return
+}
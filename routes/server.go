@@ -17,13 +17,38 @@ type HTTPServer interface {
 	Start()
 }
 
+// Controllers are the controllers exposed by the HTTP server
+type Controllers struct {
+	Admin       controller.Admin
+	Credentials controller.Credentials
+	Events      controller.Events
+	Outputs     controller.Outputs
+	Projects    controller.Projects
+	Pruner      controller.Pruner
+	State       controller.State
+	System      controller.System
+	Tokens      controller.Tokens
+	Users       controller.Users
+	Variables   controller.Variables
+}
+
 type server struct {
-	port      uint16
-	accessLog io.Writer
-	projects  controller.Projects
-	router    *mux.Router
-	system    controller.System
-	siteDir   string
+	admin       controller.Admin
+	auth        AuthConfig
+	credentials controller.Credentials
+	events      controller.Events
+	port        uint16
+	accessLog   io.Writer
+	outputs     controller.Outputs
+	projects    controller.Projects
+	pruner      controller.Pruner
+	router      *mux.Router
+	state       controller.State
+	system      controller.System
+	tokens      controller.Tokens
+	users       controller.Users
+	variables   controller.Variables
+	siteDir     string
 }
 
 var serverSingleton struct {
@@ -38,16 +63,72 @@ func projectsController() controller.Projects {
 	return serverSingleton.instance.projects
 }
 
-// InitializeServer creates an HTTPServer
-func InitializeServer(port uint16, accessLog io.Writer, system controller.System, projects controller.Projects, siteDir string) HTTPServer {
+// convenience method for getting the admin controller
+func adminController() controller.Admin {
+	return serverSingleton.instance.admin
+}
+
+// convenience method for getting the credentials controller
+func credentialsController() controller.Credentials {
+	return serverSingleton.instance.credentials
+}
+
+// convenience method for getting the events controller
+func eventsController() controller.Events {
+	return serverSingleton.instance.events
+}
+
+// convenience method for getting the outputs controller
+func outputsController() controller.Outputs {
+	return serverSingleton.instance.outputs
+}
+
+// convenience method for getting the pruner
+func prunerController() controller.Pruner {
+	return serverSingleton.instance.pruner
+}
+
+// convenience method for getting the state controller
+func stateController() controller.State {
+	return serverSingleton.instance.state
+}
+
+// convenience method for getting the tokens controller
+func tokensController() controller.Tokens {
+	return serverSingleton.instance.tokens
+}
+
+// convenience method for getting the users controller
+func usersController() controller.Users {
+	return serverSingleton.instance.users
+}
+
+// convenience method for getting the variables controller
+func variablesController() controller.Variables {
+	return serverSingleton.instance.variables
+}
+
+// InitializeServer creates an HTTPServer. With auth enabled, API endpoints require a token, or a user,
+// allowed their scope.
+func InitializeServer(port uint16, accessLog io.Writer, controllers Controllers, siteDir string, auth AuthConfig) HTTPServer {
 	serverSingleton.init.Do(func() {
 		serverSingleton.instance = &server{
-			port:      port,
-			accessLog: accessLog,
-			projects:  projects,
-			router:    mux.NewRouter(),
-			siteDir:   siteDir,
-			system:    system,
+			admin:       controllers.Admin,
+			auth:        auth,
+			credentials: controllers.Credentials,
+			events:      controllers.Events,
+			port:        port,
+			accessLog:   accessLog,
+			outputs:     controllers.Outputs,
+			projects:    controllers.Projects,
+			pruner:      controllers.Pruner,
+			router:      mux.NewRouter(),
+			siteDir:     siteDir,
+			state:       controllers.State,
+			system:      controllers.System,
+			tokens:      controllers.Tokens,
+			users:       controllers.Users,
+			variables:   controllers.Variables,
 		}
 	})
 
@@ -89,7 +170,7 @@ func (s *server) Start() {
 // registerEndpoint binds an HTTP endpoint to the server
 func (s *server) registerEndpoint(method string, path string, handler http.HandlerFunc) {
 	log.Printf("[DEBUG] Registering endpoint '%s %s'", method, path)
-	s.router.HandleFunc(path, handler).Methods(method)
+	s.router.Handle(path, s.authorize(endpointScope(method, path), handler)).Methods(method)
 }
 
 // registerEndpoint binds an API endpoint to the server. API endpoints are wrapped to exhibit
@@ -97,6 +178,7 @@ func (s *server) registerEndpoint(method string, path string, handler http.Handl
 func (s *server) registerAPIEndpoints(endpoints ...api) {
 	for _, endpoint := range endpoints {
 		log.Printf("[DEBUG] Registering API endpoint '%s %s'", endpoint.method, endpoint.path)
-		s.router.Handle(endpoint.path, endpoint.handler).Methods(endpoint.method)
+		scope := endpointScope(endpoint.method, endpoint.path)
+		s.router.Handle(endpoint.path, s.authorize(scope, endpoint.handler)).Methods(endpoint.method)
 	}
 }
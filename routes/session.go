@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/webdevwilson/tfwatch/controller"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"POST", "/api/login", login},
+			api{"POST", "/api/logout", logout},
+			api{"GET", "/api/session", sessionGet},
+			api{"POST", "/api/session/password", sessionPassword},
+		}...)
+	}
+}
+
+// loginRequest is the body of a login
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// passwordRequest changes the password of the user making the request
+type passwordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+// sessionResponse describes who is making requests. Auth is false when auth is disabled, the CSRF
+// token is returned to users logged in with a session.
+type sessionResponse struct {
+	Auth      bool            `json:"auth"`
+	User      *model.Identity `json:"user,omitempty"`
+	CSRFToken string          `json:"csrf_token,omitempty"`
+	cookie    *http.Cookie
+}
+
+// Cookies returns the session cookie to set
+func (s *sessionResponse) Cookies() []*http.Cookie {
+	if s.cookie == nil {
+		return nil
+	}
+	return []*http.Cookie{s.cookie}
+}
+
+// login checks a user's password and sets the cookie of a new session. Logins must be JSON, so they
+// cannot be posted by forms on other sites.
+func login(req *http.Request) (data interface{}, err error) {
+	if t, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); t != "application/json" {
+		return nil, controller.Invalid("Logins must have a Content-Type of application/json")
+	}
+
+	var body loginRequest
+	err = decodeBody(req, &body)
+	if err != nil {
+		return
+	}
+
+	session, err := usersController().Login(body.Username, body.Password)
+	if err != nil {
+		return
+	}
+
+	user, err := usersController().Lookup(session.Username)
+	if err != nil {
+		return
+	}
+	return &sessionResponse{true, user.Identity(), session.CSRFToken, newSessionCookie(req, session)}, nil
+}
+
+// logout ends the request's session and clears its cookie
+func logout(req *http.Request) (data interface{}, err error) {
+	cleared := &sessionResponse{Auth: true, cookie: newSessionCookie(req, nil)}
+	session, err := requestSession(usersController(), req)
+	if err != nil {
+		return cleared, nil
+	}
+	if err = checkCSRF(req, session); err != nil {
+		return
+	}
+
+	err = usersController().Logout(session.ID)
+	if err != nil {
+		return
+	}
+	return cleared, nil
+}
+
+// sessionGet returns who is making the request, and the CSRF token of its session. A user named by a
+// trusted proxy is given a session, for its CSRF token.
+func sessionGet(req *http.Request) (data interface{}, err error) {
+	identity := requestIdentity(req)
+	if identity == nil {
+		return &sessionResponse{}, nil
+	}
+	if identity.Kind != model.IdentityUser {
+		return &sessionResponse{Auth: true, User: identity}, nil
+	}
+
+	session, err := requestSession(usersController(), req)
+	if err == nil && session.Username == identity.Name {
+		return &sessionResponse{true, identity, session.CSRFToken, nil}, nil
+	}
+
+	session, err = usersController().CreateSession(identity.Name)
+	if err != nil {
+		return
+	}
+	return &sessionResponse{true, identity, session.CSRFToken, newSessionCookie(req, session)}, nil
+}
+
+// sessionPassword changes the password of the user making the request, the current password is required.
+// The user's other sessions are ended.
+func sessionPassword(req *http.Request) (data interface{}, err error) {
+	identity := requestIdentity(req)
+	if identity == nil || identity.Kind != model.IdentityUser {
+		return nil, controller.Forbidden("Only users have passwords")
+	}
+
+	var body passwordRequest
+	err = decodeBody(req, &body)
+	if err != nil {
+		return
+	}
+
+	user, err := usersController().Authenticate(identity.Name, body.CurrentPassword)
+	if _, ok := err.(*controller.UnauthorizedError); ok {
+		return nil, controller.Forbidden("The current password is wrong")
+	}
+	if err != nil {
+		return
+	}
+
+	// the user's other sessions end, the session making the request is kept
+	keep := ""
+	if session, err := requestSession(usersController(), req); err == nil {
+		keep = session.ID
+	}
+
+	err = usersController().SetPassword(user.GUID, body.Password, keep)
+	return
+}
+
+// newSessionCookie returns the cookie of a session, a nil session clears the cookie. The cookie is
+// only sent over HTTPS when the request was.
+func newSessionCookie(req *http.Request, session *model.Session) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if session == nil {
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(0, 0)
+		return cookie
+	}
+
+	cookie.Value = session.ID
+	cookie.Expires = session.Expires
+	return cookie
+}
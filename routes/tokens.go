@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"GET", "/api/tokens", tokenList},
+			api{"PUT", "/api/tokens", tokenCreate},
+			api{"DELETE", "/api/tokens/{token}", tokenRevoke},
+		}...)
+	}
+}
+
+func tokenList(req *http.Request) (data interface{}, err error) {
+	return tokensController().List()
+}
+
+// tokenCreate creates a token, the response is the only time its secret is returned
+func tokenCreate(req *http.Request) (data interface{}, err error) {
+	var token model.Token
+	err = decodeBody(req, &token)
+	if err != nil {
+		return
+	}
+
+	err = tokensController().Create(&token)
+	if err != nil {
+		return
+	}
+
+	return token, nil
+}
+
+func tokenRevoke(req *http.Request) (data interface{}, err error) {
+	err = tokensController().Revoke(mux.Vars(req)["token"])
+	return
+}
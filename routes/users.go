@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"GET", "/api/users", userList},
+			api{"PUT", "/api/users", userCreate},
+			api{"DELETE", "/api/users/{user}", userDelete},
+			api{"POST", "/api/users/{user}/password", userPassword},
+		}...)
+	}
+}
+
+func userList(req *http.Request) (data interface{}, err error) {
+	return usersController().List()
+}
+
+func userCreate(req *http.Request) (data interface{}, err error) {
+	var user model.User
+	err = decodeBody(req, &user)
+	if err != nil {
+		return
+	}
+
+	err = usersController().Create(&user)
+	if err != nil {
+		return
+	}
+
+	return user, nil
+}
+
+func userDelete(req *http.Request) (data interface{}, err error) {
+	err = usersController().Delete(mux.Vars(req)["user"])
+	return
+}
+
+// userPassword sets the password of a user, such as one who has forgotten theirs, ending the user's sessions
+func userPassword(req *http.Request) (data interface{}, err error) {
+	var body passwordRequest
+	err = decodeBody(req, &body)
+	if err != nil {
+		return
+	}
+
+	err = usersController().SetPassword(mux.Vars(req)["user"], body.Password, "")
+	return
+}
@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+func init() {
+	registrationCh <- func(s *server) {
+		s.registerAPIEndpoints([]api{
+			api{"GET", "/api/variables", variableList},
+			api{"PUT", "/api/variables", variableCreate},
+			api{"GET", "/api/variables/{variable}", variableGet},
+			api{"POST", "/api/variables/{variable}", variableUpdate},
+			api{"DELETE", "/api/variables/{variable}", variableDelete},
+			api{"GET", "/api/variable-sets", variableSetList},
+			api{"PUT", "/api/variable-sets", variableSetCreate},
+			api{"GET", "/api/variable-sets/{set}", variableSetGet},
+			api{"POST", "/api/variable-sets/{set}", variableSetUpdate},
+			api{"DELETE", "/api/variable-sets/{set}", variableSetDelete},
+			api{"GET", "/api/variable-sets/{set}/variables", variableList},
+			api{"PUT", "/api/variable-sets/{set}/variables", variableCreate},
+			api{"GET", "/api/variable-sets/{set}/variables/{variable}", variableGet},
+			api{"POST", "/api/variable-sets/{set}/variables/{variable}", variableUpdate},
+			api{"DELETE", "/api/variable-sets/{set}/variables/{variable}", variableDelete},
+			api{"GET", "/api/projects/{guid}/variables", variableList},
+			api{"PUT", "/api/projects/{guid}/variables", variableCreate},
+			api{"GET", "/api/projects/{guid}/variables/{variable}", variableGet},
+			api{"POST", "/api/projects/{guid}/variables/{variable}", variableUpdate},
+			api{"DELETE", "/api/projects/{guid}/variables/{variable}", variableDelete},
+			api{"GET", "/api/projects/{guid}/effective-variables", variableEffective},
+		}...)
+	}
+}
+
+// variableScope returns the scope of the variables in the request, ensuring the project or set exists
+func variableScope(req *http.Request) (scope model.VariableScope, err error) {
+	vars := mux.Vars(req)
+	scope.ProjectGUID = vars["guid"]
+	scope.SetGUID = vars["set"]
+
+	switch {
+	case scope.ProjectGUID != "":
+		_, err = projectsController().Get(scope.ProjectGUID)
+	case scope.SetGUID != "":
+		_, err = variablesController().GetSet(scope.SetGUID)
+	}
+	return
+}
+
+func variableList(req *http.Request) (data interface{}, err error) {
+	scope, err := variableScope(req)
+	if err != nil {
+		return
+	}
+	return variablesController().List(scope)
+}
+
+func variableGet(req *http.Request) (data interface{}, err error) {
+	scope, err := variableScope(req)
+	if err != nil {
+		return
+	}
+	return variablesController().Get(scope, mux.Vars(req)["variable"])
+}
+
+func variableCreate(req *http.Request) (data interface{}, err error) {
+	scope, err := variableScope(req)
+	if err != nil {
+		return
+	}
+
+	var v model.Variable
+	err = decodeBody(req, &v)
+	if err != nil {
+		return
+	}
+	v.ProjectGUID = scope.ProjectGUID
+	v.SetGUID = scope.SetGUID
+
+	err = variablesController().Create(&v)
+	if err != nil {
+		return
+	}
+
+	return v, nil
+}
+
+func variableUpdate(req *http.Request) (data interface{}, err error) {
+	scope, err := variableScope(req)
+	if err != nil {
+		return
+	}
+
+	var v model.Variable
+	err = decodeBody(req, &v)
+	if err != nil {
+		return
+	}
+	v.ProjectGUID = scope.ProjectGUID
+	v.SetGUID = scope.SetGUID
+	v.GUID = mux.Vars(req)["variable"]
+
+	err = variablesController().Update(&v)
+	if err != nil {
+		return
+	}
+
+	return v, nil
+}
+
+func variableDelete(req *http.Request) (data interface{}, err error) {
+	scope, err := variableScope(req)
+	if err != nil {
+		return
+	}
+	err = variablesController().Delete(scope, mux.Vars(req)["variable"])
+	return
+}
+
+func variableSetList(req *http.Request) (data interface{}, err error) {
+	return variablesController().ListSets()
+}
+
+func variableSetGet(req *http.Request) (data interface{}, err error) {
+	return variablesController().GetSet(mux.Vars(req)["set"])
+}
+
+func variableSetCreate(req *http.Request) (data interface{}, err error) {
+	var set model.VariableSet
+	err = decodeBody(req, &set)
+	if err != nil {
+		return
+	}
+
+	err = variablesController().CreateSet(&set)
+	if err != nil {
+		return
+	}
+
+	return set, nil
+}
+
+func variableSetUpdate(req *http.Request) (data interface{}, err error) {
+	var set model.VariableSet
+	err = decodeBody(req, &set)
+	if err != nil {
+		return
+	}
+	set.GUID = mux.Vars(req)["set"]
+
+	err = variablesController().UpdateSet(&set)
+	if err != nil {
+		return
+	}
+
+	return set, nil
+}
+
+func variableSetDelete(req *http.Request) (data interface{}, err error) {
+	err = variablesController().DeleteSet(mux.Vars(req)["set"])
+	return
+}
+
+// variableEffective returns the values the project's variables resolve to and where they come from
+func variableEffective(req *http.Request) (data interface{}, err error) {
+	prj, err := projectsController().Get(mux.Vars(req)["guid"])
+	if err != nil {
+		return
+	}
+
+	resolved, err := variablesController().Resolve(prj, nil)
+	if err != nil {
+		return
+	}
+
+	for i, v := range resolved {
+		resolved[i] = v.Masked()
+	}
+	return resolved, nil
+}
@@ -0,0 +1,97 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of master keys, selecting AES-256
+const KeySize = 32
+
+// Cipher encrypts values stored at rest with AES-GCM under the master key
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a Cipher for a master key of KeySize bytes
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("Master key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead}, nil
+}
+
+// Encrypt seals the plaintext, the random nonce is prefixed to the returned ciphertext
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a ciphertext created by Encrypt
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, fmt.Errorf("Ciphertext is too short")
+	}
+	return c.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+}
+
+// LoadKey returns the base64 encoded master key in encoded when it is set, otherwise the key is read
+// from file. When the file does not exist, a key is generated and written to it.
+func LoadKey(encoded, file string) ([]byte, error) {
+	if encoded != "" {
+		return decodeKey(encoded)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err == nil {
+		return decodeKey(string(b))
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.Printf("[WARN] Master key file '%s' does not exist, generating a new master key", file)
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("Master key is not valid base64: %s", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,87 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webdevwilson/tfwatch/test"
+)
+
+func TestMain(m *testing.M) {
+	test.SuppressLogs()
+	os.Exit(m.Run())
+}
+
+func TestCipher_round_trip(t *testing.T) {
+	c, err := NewCipher(bytes.Repeat([]byte{1}, KeySize))
+	assert.NoError(t, err)
+
+	ciphertext, err := c.Encrypt([]byte("hunter2"))
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(ciphertext, []byte("hunter2")))
+
+	plaintext, err := c.Decrypt(ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", string(plaintext))
+}
+
+func TestCipher_nonce_is_random(t *testing.T) {
+	c, _ := NewCipher(bytes.Repeat([]byte{1}, KeySize))
+
+	a, _ := c.Encrypt([]byte("value"))
+	b, _ := c.Encrypt([]byte("value"))
+	assert.NotEqual(t, a, b)
+}
+
+func TestCipher_wrong_key(t *testing.T) {
+	a, _ := NewCipher(bytes.Repeat([]byte{1}, KeySize))
+	b, _ := NewCipher(bytes.Repeat([]byte{2}, KeySize))
+
+	ciphertext, _ := a.Encrypt([]byte("value"))
+	_, err := b.Decrypt(ciphertext)
+	assert.Error(t, err)
+
+	_, err = b.Decrypt([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestNewCipher_key_size(t *testing.T) {
+	_, err := NewCipher([]byte("too short"))
+	assert.Error(t, err)
+}
+
+func TestLoadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tfwatch-secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "master.key")
+
+	// generated on first load, then read back
+	generated, err := LoadKey("", file)
+	assert.NoError(t, err)
+	assert.Equal(t, KeySize, len(generated))
+
+	loaded, err := LoadKey("", file)
+	assert.NoError(t, err)
+	assert.Equal(t, generated, loaded)
+
+	fi, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// the encoded key takes precedence over the file
+	key := bytes.Repeat([]byte{3}, KeySize)
+	loaded, err = LoadKey(base64.StdEncoding.EncodeToString(key), file)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	_, err = LoadKey("not base64!", file)
+	assert.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package secure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EnvProvider reads secrets from the environment of the server, references name the variable. Only
+// the variables allowed can be read, names ending in * allow every variable with the prefix. The
+// variables tfwatch itself is configured with are never read.
+type EnvProvider struct {
+	Allowed []string
+}
+
+// deniedEnv are the variables holding tfwatch's own secrets
+var deniedEnv = map[string]bool{
+	"TFWATCH_MASTER_KEY": true,
+	"VAULT_TOKEN":        true,
+	"ADMIN_PASSWORD":     true,
+}
+
+// Secret returns the value of the environment variable
+func (p *EnvProvider) Secret(ref string) (string, error) {
+	if !p.allowed(ref) {
+		return "", fmt.Errorf("Environment variable '%s' is not allowed to be read", ref)
+	}
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("Environment variable '%s' is not set", ref)
+	}
+	return value, nil
+}
+
+// allowed returns true when the variable may be read
+func (p *EnvProvider) allowed(name string) bool {
+	if deniedEnv[name] {
+		return false
+	}
+	for _, allowed := range p.Allowed {
+		if prefix := strings.TrimSuffix(allowed, "*"); prefix != allowed {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// FileProvider reads secrets from files in a directory, such as mounted Kubernetes secrets. References
+// are paths relative to the directory, and cannot refer to files outside of it, through symlinks
+// either. Trailing newlines are removed.
+type FileProvider struct {
+	Dir string
+}
+
+// Secret returns the contents of the file
+func (p *FileProvider) Secret(ref string) (string, error) {
+	dir := filepath.Clean(p.Dir)
+	file := filepath.Join(dir, ref)
+	if !strings.HasPrefix(file, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is outside of the secrets directory", ref)
+	}
+
+	// symlinks are followed before checking again, Kubernetes mounts secrets as links within the directory
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	file, err = filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(file, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' links outside of the secrets directory", ref)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// VaultProvider reads secrets from a Vault KV version 2 secrets engine. References have the form
+// "<mount>/<path>#<key>", e.g. "secret/databases/main#password". Only the secrets allowed can be read,
+// paths ending in * allow every secret with the prefix, whatever else the token can read.
+type VaultProvider struct {
+	Address string
+	Token   string
+	Allowed []string
+	client  *http.Client
+}
+
+// NewVaultProvider creates a provider reading the allowed secrets from the Vault server at address
+func NewVaultProvider(address, token string, allowed []string) *VaultProvider {
+	return &VaultProvider{
+		Address: strings.TrimRight(address, "/"),
+		Token:   token,
+		Allowed: allowed,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// allowed returns true when the secret at the path may be read
+func (p *VaultProvider) allowed(secret string) bool {
+	for _, allowed := range p.Allowed {
+		allowed = strings.TrimLeft(allowed, "/")
+		if prefix := strings.TrimSuffix(allowed, "*"); prefix != allowed {
+			if strings.HasPrefix(secret, prefix) {
+				return true
+			}
+		} else if secret == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// vaultKVResponse is the response to a KV version 2 read
+type vaultKVResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// Secret reads the latest version of the secret and returns the key. Values that are not strings
+// are returned as JSON.
+func (p *VaultProvider) Secret(ref string) (string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("Vault reference '%s' has no key, expected '<mount>/<path>#<key>'", ref)
+	}
+	segments := strings.SplitN(strings.Trim(parts[0], "/"), "/", 2)
+	if len(segments) != 2 {
+		return "", fmt.Errorf("Vault reference '%s' has no path, expected '<mount>/<path>#<key>'", ref)
+	}
+	key := parts[1]
+
+	// paths are compared once cleaned, so ".." cannot reach secrets that are not allowed
+	secret := segments[0] + "/" + segments[1]
+	if path.Clean(secret) != secret {
+		return "", fmt.Errorf("Vault reference '%s' is not a clean path", ref)
+	}
+	if !p.allowed(secret) {
+		return "", fmt.Errorf("Vault secret '%s' is not allowed to be read", secret)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/data/%s", p.Address, segments[0], segments[1])
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var kv vaultKVResponse
+	if err := json.NewDecoder(resp.Body).Decode(&kv); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("Error decoding Vault response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned %d reading '%s': %s", resp.StatusCode, parts[0], strings.Join(kv.Errors, ", "))
+	}
+
+	value, ok := kv.Data.Data[key]
+	if !ok {
+		return "", fmt.Errorf("Vault secret '%s' has no key '%s'", parts[0], key)
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
@@ -0,0 +1,74 @@
+package secure
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// SecretPrefix begins values that reference a secret instead of containing it. References have the
+// form "secret:<provider>:<reference>", e.g. "secret:env:DB_PASSWORD".
+const SecretPrefix = "secret:"
+
+// SecretProvider looks up secrets held outside of tfwatch
+type SecretProvider interface {
+	Secret(ref string) (string, error)
+}
+
+// Secrets resolves secret references using the registered providers
+type Secrets struct {
+	providers map[string]SecretProvider
+}
+
+// NewSecrets creates Secrets without any providers
+func NewSecrets() *Secrets {
+	return &Secrets{
+		providers: make(map[string]SecretProvider),
+	}
+}
+
+// Register makes a provider available to references by name
+func (s *Secrets) Register(name string, provider SecretProvider) {
+	log.Printf("[INFO] Registering secret provider '%s'", name)
+	s.providers[name] = provider
+}
+
+// Providers returns the names of the registered providers
+func (s *Secrets) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsSecretRef returns true if the value references a secret
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretPrefix)
+}
+
+// Resolve returns the secret a value references, values that are not references are returned as is.
+// Errors name the reference, never the secret.
+func (s *Secrets) Resolve(value string) (string, error) {
+	if !IsSecretRef(value) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, SecretPrefix), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("Invalid secret reference '%s', expected '%s<provider>:<reference>'", value, SecretPrefix)
+	}
+
+	provider, ok := s.providers[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("Unknown secret provider '%s' in '%s'", parts[0], value)
+	}
+
+	secret, err := provider.Secret(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("Error resolving secret '%s': %s", value, err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,129 @@
+package secure
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecrets_resolve(t *testing.T) {
+	os.Setenv("TFWATCH_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("TFWATCH_TEST_SECRET")
+
+	secrets := NewSecrets()
+	secrets.Register("env", &EnvProvider{Allowed: []string{"TFWATCH_TEST_*"}})
+
+	value, err := secrets.Resolve("plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", value)
+
+	value, err = secrets.Resolve("secret:env:TFWATCH_TEST_SECRET")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	_, err = secrets.Resolve("secret:env:TFWATCH_TEST_UNSET")
+	assert.Error(t, err)
+
+	// only allowed variables are read
+	os.Setenv("TFWATCH_OTHER_SECRET", "hunter2")
+	defer os.Unsetenv("TFWATCH_OTHER_SECRET")
+	_, err = secrets.Resolve("secret:env:TFWATCH_OTHER_SECRET")
+	assert.Error(t, err)
+
+	_, err = secrets.Resolve("secret:vault:secret/db#password")
+	assert.Error(t, err)
+
+	_, err = secrets.Resolve("secret:env")
+	assert.Error(t, err)
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tfwatch-secrets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "db"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db", "password"), []byte("hunter2\n"), 0600))
+
+	p := &FileProvider{Dir: dir}
+	value, err := p.Secret("db/password")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	_, err = p.Secret("../etc/passwd")
+	assert.Error(t, err)
+
+	_, err = p.Secret("db/missing")
+	assert.Error(t, err)
+
+	// links within the directory are followed, links out of it are not
+	outside, err := ioutil.TempDir("", "tfwatch-outside")
+	assert.NoError(t, err)
+	defer os.RemoveAll(outside)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(outside, "key"), []byte("private"), 0600))
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "key"), filepath.Join(dir, "key")))
+	assert.NoError(t, os.Symlink("db", filepath.Join(dir, "linked")))
+
+	value, err = p.Secret("linked/password")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+	_, err = p.Secret("key")
+	assert.Error(t, err)
+}
+
+func TestVaultProvider(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/databases/main" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"password": "hunter2", "port": 5432},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	defer vault.Close()
+
+	p := NewVaultProvider(vault.URL+"/", "root", []string{"secret/databases/*"})
+	value, err := p.Secret("secret/databases/main#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	value, err = p.Secret("secret/databases/main#port")
+	assert.NoError(t, err)
+	assert.Equal(t, "5432", value)
+
+	_, err = p.Secret("secret/databases/main#user")
+	assert.Error(t, err)
+
+	_, err = p.Secret("secret/databases/other#password")
+	assert.Error(t, err)
+
+	_, err = p.Secret("secret/databases/main")
+	assert.Error(t, err)
+
+	_, err = NewVaultProvider(vault.URL, "wrong", p.Allowed).Secret("secret/databases/main#password")
+	assert.Error(t, err)
+
+	// secrets that are not allowed are not requested
+	_, err = p.Secret("secret/admin/root#password")
+	assert.EqualError(t, err, "Vault secret 'secret/admin/root' is not allowed to be read")
+	_, err = p.Secret("secret/databases/../admin/root#password")
+	assert.Error(t, err)
+	_, err = NewVaultProvider(vault.URL, "root", []string{"secret/databases/other"}).Secret("secret/databases/main#password")
+	assert.Error(t, err)
+}
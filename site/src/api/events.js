@@ -0,0 +1,12 @@
+// subscribe opens a stream of server-sent events, calling handler with each event of the given types.
+// The browser reconnects a dropped stream, resuming after the last event received. Events missed by a
+// slow client are replaced by a reset event, calling reset to reload everything. The session cookie
+// authenticates the stream.
+export default function subscribe (types, handler, reset) {
+    let source = new EventSource('/api/events?type=' + types.join(','), { withCredentials: true })
+    types.forEach(type => {
+        source.addEventListener(type, e => handler(JSON.parse(e.data)))
+    })
+    source.addEventListener('reset', () => (reset || handler)(null))
+    return source
+}
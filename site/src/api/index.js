@@ -1,12 +1,24 @@
 import Vue from 'vue'
 import VueResource from 'vue-resource'
+import { getCSRFToken } from './session'
 
 //Vue.http.options.crossOrigin = true
-//Vue.http.options.credentials = true
+Vue.http.options.credentials = true
 
 Vue.use(VueResource)
 
+// requests that change something send the CSRF token of the session
+Vue.http.interceptors.push((request, next) => {
+    if (!['GET', 'HEAD'].includes(request.method) && getCSRFToken()) {
+        request.headers.set('X-CSRF-Token', getCSRFToken())
+    }
+    next()
+})
+
 export default {
     ProjectResource: require('./project'),
-    ConfigurationResource: require('./configuration')
-}
\ No newline at end of file
+    ConfigurationResource: require('./configuration'),
+    Events: require('./events'),
+    Session: require('./session'),
+    VariableResource: require('./variable')
+}
@@ -0,0 +1,27 @@
+import Vue from 'vue'
+
+// csrfToken is the CSRF token of the session, sent with requests that change something
+let csrfToken = ''
+
+export function setCSRFToken (token) {
+    csrfToken = token || ''
+}
+
+export function getCSRFToken () {
+    return csrfToken
+}
+
+export default {
+    get () {
+        return Vue.http.get('/api/session')
+    },
+    login (username, password) {
+        return Vue.http.post('/api/login', { username, password })
+    },
+    logout () {
+        return Vue.http.post('/api/logout')
+    },
+    changePassword (currentPassword, password) {
+        return Vue.http.post('/api/session/password', { current_password: currentPassword, password })
+    }
+}
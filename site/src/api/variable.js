@@ -0,0 +1,6 @@
+import Vue from 'vue'
+
+export default Vue.resource('/api/projects{/guid}/variables{/id}', {}, {
+    save: { method: 'PUT' },
+    update: { method: 'POST' }
+})
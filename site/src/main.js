@@ -7,6 +7,17 @@ import "./filters"
 
 Vue.use(Vuetify)
 
+// requests refused for want of a session go to the login page, returning to the page once logged in
+Vue.http.interceptors.push((request, next) => {
+  next(response => {
+    if (response.status === 401 && request.url !== '/api/login' && router.currentRoute.name !== 'Login') {
+      router.push({ name: 'Login', query: { redirect: router.currentRoute.fullPath } })
+    }
+  })
+})
+
+store.dispatch('LOAD_SESSION')
+
 new Vue({
   el: '#app',
   router,
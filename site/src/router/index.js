@@ -4,6 +4,7 @@ import Router from 'vue-router'
 // top-level view imports
 import About from '../components/About.vue'
 import Dashboard from '../components/Dashboard.vue'
+import Login from '../components/Login.vue'
 import Project from '../components/Project.vue'
 
 Vue.use(Router)
@@ -21,6 +22,12 @@ export default new Router({
       component: Project,
       props: true
     },
+    {
+      path: '/login',
+      name: 'Login',
+      component: Login,
+      props: route => ({ redirect: route.query.redirect })
+    },
     {
       path: '/about',
       name: 'About',
@@ -2,6 +2,7 @@ import Vue from 'vue'
 import Vuex from 'vuex'
 import project from './modules/project'
 import configuration from './modules/configuration'
+import session from './modules/session'
 
 Vue.use(Vuex)
 
@@ -15,6 +16,7 @@ export default new Vuex.Store({
     mutations,
     modules: {
         project,
-        configuration
+        configuration,
+        session
     }
 })
\ No newline at end of file
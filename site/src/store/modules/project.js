@@ -40,7 +40,8 @@ const testData = [
       ] 
 
 const state = {
-    projects: []
+    projects: [],
+    events: null
 }
 
 const getters = {
@@ -58,12 +59,31 @@ const actions = {
       .then(response => {
         ctx.commit('projects', response.body)
       })
+  },
+  WATCH_PROJECTS (ctx) {
+    if (ctx.state.events) {
+      return
+    }
+    // reload the list when a status changes or a plan finishes, plans update the pending changes, and
+    // when events were missed
+    let types = ['project_status_changed', 'plan_finished']
+    let reload = () => ctx.dispatch('LOAD_PROJECT_LIST')
+    ctx.commit('events', api.Events.default(types, reload, reload))
+  },
+  UNWATCH_PROJECTS (ctx) {
+    if (ctx.state.events) {
+      ctx.state.events.close()
+      ctx.commit('events', null)
+    }
   }
 }
 
 const mutations = {
   projects (state, data) {
     state.projects = data
+  },
+  events (state, source) {
+    state.events = source
   }
 }
 
@@ -0,0 +1,52 @@
+import api from '../../api'
+
+const state = {
+    auth: false,
+    user: null
+}
+
+const getters = {
+  auth: state => {
+    return state.auth
+  },
+  user: state => {
+    return state.user
+  }
+}
+
+const actions = {
+  LOAD_SESSION (ctx) {
+    return api.Session.default.get()
+      .then(response => {
+        ctx.commit('session', response.body)
+      })
+  },
+  LOGIN (ctx, { username, password }) {
+    return api.Session.default.login(username, password)
+      .then(response => {
+        ctx.commit('session', response.body)
+      })
+  },
+  LOGOUT (ctx) {
+    return api.Session.default.logout()
+      .then(() => {
+        ctx.dispatch('UNWATCH_PROJECTS')
+        ctx.commit('session', { auth: true })
+      })
+  }
+}
+
+const mutations = {
+  session (state, data) {
+    state.auth = data.auth
+    state.user = data.user || null
+    api.Session.setCSRFToken(data.csrf_token)
+  }
+}
+
+export default {
+  state,
+  getters,
+  actions,
+  mutations
+}
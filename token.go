@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/webdevwilson/tfwatch/context"
+	"github.com/webdevwilson/tfwatch/model"
+)
+
+// token creates, lists or revokes API tokens, returning the exit code
+func token(cfg *context.Configuration) int {
+	tokens, err := context.Tokens(cfg)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		return 1
+	}
+
+	switch cfg.TokenCommand {
+	case "create":
+		t := cfg.Token
+		if err := tokens.Create(&t); err != nil {
+			log.Printf("[ERROR] Error creating token: %s", err)
+			return 1
+		}
+		fmt.Printf("Created token '%s' (%s), it is not shown again:\n%s\n", t.Name, t.GUID, t.Secret)
+	case "list":
+		list, err := tokens.List()
+		if err != nil {
+			log.Printf("[ERROR] Error listing tokens: %s", err)
+			return 1
+		}
+		for _, t := range list {
+			fmt.Printf("%s\t%s\t%s\t%s\n", t.GUID, t.Name, scopeList(t.Scopes), expiry(t))
+		}
+	case "revoke":
+		if err := tokens.Revoke(cfg.Token.GUID); err != nil {
+			log.Printf("[ERROR] Error revoking token '%s': %s", cfg.Token.GUID, err)
+			return 1
+		}
+		fmt.Printf("Revoked token %s\n", cfg.Token.GUID)
+	}
+	return 0
+}
+
+// scopeList returns the scopes as a comma separated list
+func scopeList(scopes []model.TokenScope) string {
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ",")
+}
+
+// expiry describes when a token expires
+func expiry(t *model.Token) string {
+	if t.Expires.IsZero() {
+		return "never expires"
+	}
+	return "expires " + t.Expires.Format("2006-01-02 15:04:05 MST")
+}
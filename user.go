@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/webdevwilson/tfwatch/context"
+)
+
+// user lists users, or gives a user a new password, returning the exit code
+func user(cfg *context.Configuration) int {
+	users, err := context.Users(cfg)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		return 1
+	}
+
+	switch cfg.UserCommand {
+	case "list":
+		list, err := users.List()
+		if err != nil {
+			log.Printf("[ERROR] Error listing users: %s", err)
+			return 1
+		}
+		for _, u := range list {
+			fmt.Printf("%s\t%s\t%s\n", u.GUID, u.Username, u.Role)
+		}
+	case "reset":
+		password, err := users.ResetPassword(cfg.User.Username)
+		if err != nil {
+			log.Printf("[ERROR] Error resetting the password of user '%s': %s", cfg.User.Username, err)
+			return 1
+		}
+		fmt.Printf("Reset the password of user '%s', it is not shown again:\n%s\n", cfg.User.Username, password)
+	}
+	return 0
+}